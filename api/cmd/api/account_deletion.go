@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+var errAccountDeleted = errors.New("account has been deleted")
+
+type DeleteAccountPayload struct {
+	// Password confirms the deletion of an account with a password, Code the
+	// deletion of a passwordless one.
+	Password string `json:"password" validate:"max=72"`
+	Code     string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+// DeleteAccountHandler deletes the account of the user after they re-enter
+// their password or, for passwordless accounts, the code sent by
+// SendAccountDeletionCodeHandler. Signing in within the grace period restores
+// it.
+func (app *application) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DeleteAccountPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if user.HasPassword() {
+		if payload.Password == "" {
+			app.badRequestResponse(w, r, errors.New("password is required"))
+			return
+		}
+
+		if err := user.Password.Compare(payload.Password); err != nil {
+			app.wrongPasswordResponse(w, r, user, err)
+			return
+		}
+	} else {
+		if payload.Code == "" {
+			app.badRequestResponse(w, r, errors.New("code is required, ask for one to be emailed first"))
+			return
+		}
+
+		err := app.store.EmailOTPs.Verify(ctx, user.ID, store.EmailOTPAccountDeletion, payload.Code, app.config.Auth.EmailOTP.MaxAttempts)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired code"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	if err := app.store.Users.Delete(ctx, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errAccountDeleted)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Infow("account deleted", "event", "account_deleted", "user_id", user.ID, "ip", clientIP(r))
+
+	app.wakeOutboxRelay()
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Account deleted"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// SendAccountDeletionCodeHandler emails a passwordless user the code that
+// confirms the deletion of their account. Accounts with a password confirm
+// with it instead.
+func (app *application) SendAccountDeletionCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if user.HasPassword() {
+		app.badRequestResponse(w, r, errors.New("account has a password, confirm the deletion with it"))
+		return
+	}
+
+	if err := app.sendEmailOTP(r.Context(), user, store.EmailOTPAccountDeletion, mailer.AccountDeletionCodeTemplate); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := EmailOTPStarted{
+		Message:     "A code has been sent to your email",
+		ResendAfter: int(app.config.Auth.EmailOTP.ResendCooldown.Seconds()),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// restoreAccount restores a deleted account when its owner signs in within
+// the grace period. It returns errAccountDeleted once the period is over.
+func (app *application) restoreAccount(ctx context.Context, user *store.User) error {
+	if !user.IsDeleted {
+		return nil
+	}
+
+	deletedAfter := time.Now().Add(-app.config.Auth.AccountDeletion.GracePeriod)
+
+	if err := app.store.Users.Restore(ctx, user, deletedAfter); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return errAccountDeleted
+		default:
+			return err
+		}
+	}
+
+	app.logger.Infow("account restored", "event", "account_restored", "user_id", user.ID)
+
+	app.wakeOutboxRelay()
+
+	return nil
+}
+
+// purgeDeletedAccounts periodically erases the accounts whose grace period
+// is over.
+func (app *application) purgeDeletedAccounts(ctx context.Context) {
+	cfg := app.config.Auth.AccountDeletion
+
+	ticker := time.NewTicker(cfg.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			users, err := app.store.Users.Purge(ctx, time.Now().Add(-cfg.GracePeriod))
+			if err != nil {
+				app.logger.Errorw("failed to purge deleted accounts", "error", err)
+				continue
+			}
+
+			for _, user := range users {
+				app.logger.Infow("account purged", "event", "account_purged", "user_id", user.ID)
+			}
+
+			if len(users) > 0 {
+				app.wakeOutboxRelay()
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeEmailOTPStore struct {
+	*store.EmailOTPStore
+
+	// codes holds the usable code of each purpose.
+	codes map[string]string
+}
+
+func (s *fakeEmailOTPStore) Create(_ context.Context, otp *store.EmailOTP, code string) error {
+	otp.CreatedAt = time.Now()
+	s.codes[otp.Purpose] = code
+	return nil
+}
+
+func (s *fakeEmailOTPStore) GetLatest(context.Context, string, string) (*store.EmailOTP, error) {
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeEmailOTPStore) Verify(_ context.Context, _, purpose, code string, _ int) error {
+	if want, ok := s.codes[purpose]; !ok || code != want {
+		return store.ErrNotFound
+	}
+
+	delete(s.codes, purpose)
+
+	return nil
+}
+
+type fakeDeletedUserStore struct {
+	*store.UserStore
+
+	deleted bool
+}
+
+func (s *fakeDeletedUserStore) Delete(context.Context, *store.User) error {
+	s.deleted = true
+	return nil
+}
+
+func TestDeleteAccount(t *testing.T) {
+	withPassword := func(t *testing.T) *store.User {
+		user := &store.User{ID: "user_test", FirstName: "Ada", Email: "ada@example.com"}
+		if err := user.Password.Set("correct horse"); err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	passwordless := func(*testing.T) *store.User {
+		return &store.User{ID: "user_test", FirstName: "Ada", Email: "ada@example.com"}
+	}
+
+	tests := []struct {
+		name string
+		user func(*testing.T) *store.User
+		// sendCode has a code emailed before the deletion, for the login
+		// instead of the deletion when loginCode is set.
+		sendCode  bool
+		loginCode bool
+		body      func(code string) string
+		wantCode  int
+	}{
+		{
+			name:     "deletes an account with its password",
+			user:     withPassword,
+			body:     func(string) string { return `{"password":"correct horse"}` },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "rejects a wrong password",
+			user:     withPassword,
+			body:     func(string) string { return `{"password":"wrong"}` },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "does not take a code for an account with a password",
+			user:     withPassword,
+			body:     func(string) string { return `{"code":"123456"}` },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "deletes a passwordless account with the emailed code",
+			user:     passwordless,
+			sendCode: true,
+			body:     func(code string) string { return `{"code":"` + code + `"}` },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "requires a code for a passwordless account",
+			user:     passwordless,
+			body:     func(string) string { return `{}` },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "rejects a wrong code",
+			user:     passwordless,
+			sendCode: true,
+			body:     func(code string) string { return `{"code":"` + code[:5] + string('0'+(code[5]-'0'+1)%10) + `"}` },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:      "rejects a sign-in code",
+			user:      passwordless,
+			loginCode: true,
+			body:      func(code string) string { return `{"code":"` + code + `"}` },
+			wantCode:  http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeDeletedUserStore{}
+			otps := &fakeEmailOTPStore{codes: map[string]string{}}
+			app := newTestApplication(t, store.Storage{Users: users, EmailOTPs: otps})
+			user := tt.user(t)
+
+			withUser := func(r *http.Request) *http.Request {
+				return r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+			}
+
+			var code string
+			switch {
+			case tt.sendCode:
+				w := httptest.NewRecorder()
+				app.SendAccountDeletionCodeHandler(w, withUser(httptest.NewRequest(http.MethodPost, "/", nil)))
+				if w.Code != http.StatusOK {
+					t.Fatalf("sending the code: got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+				}
+
+				outbox := app.mailer.(*mailer.InMemoryMailer).Outbox()
+				if len(outbox) != 1 || outbox[0].To != user.Email {
+					t.Fatalf("got %d emails, want 1 to %s", len(outbox), user.Email)
+				}
+				code = regexp.MustCompile(`\d{6}`).FindString(outbox[0].Subject)
+			case tt.loginCode:
+				if err := app.sendEmailOTP(context.Background(), user, store.EmailOTPLogin, mailer.EmailOTPTemplate); err != nil {
+					t.Fatal(err)
+				}
+				code = otps.codes[store.EmailOTPLogin]
+			default:
+				code = "123456"
+			}
+
+			r := withUser(httptest.NewRequest(http.MethodDelete, "/", strings.NewReader(tt.body(code))))
+			w := httptest.NewRecorder()
+			app.DeleteAccountHandler(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if users.deleted != (tt.wantCode == http.StatusOK) {
+				t.Errorf("got deleted %v, want %v", users.deleted, tt.wantCode == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestSendAccountDeletionCodeRefusesAccountsWithPassword(t *testing.T) {
+	app := newTestApplication(t, store.Storage{EmailOTPs: &fakeEmailOTPStore{codes: map[string]string{}}})
+
+	user := &store.User{ID: "user_test", Email: "ada@example.com"}
+	if err := user.Password.Set("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+	w := httptest.NewRecorder()
+	app.SendAccountDeletionCodeHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if n := len(app.mailer.(*mailer.InMemoryMailer).Outbox()); n != 0 {
+		t.Errorf("got %d emails, want none", n)
+	}
+}
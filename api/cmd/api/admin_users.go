@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+const (
+	permUsersRead  = "users:read"
+	permUsersWrite = "users:write"
+)
+
+const (
+	adminActionBlock         = "block"
+	adminActionUnblock       = "unblock"
+	adminActionForceLogout   = "force_logout"
+	adminActionResetMFA      = "reset_mfa"
+	adminActionPasswordReset = "password_reset"
+)
+
+// adminActionHistory is how many of the latest admin actions are shown with
+// a user.
+const adminActionHistory = 50
+
+var errCannotTargetSelf = errors.New("admins cannot perform this action on their own account")
+
+// newAdminAction returns an action of the authenticated admin on a user, to
+// be recorded by the store along with the action itself.
+func newAdminAction(r *http.Request, targetUserID, action, reason string) *store.AdminAction {
+	return &store.AdminAction{
+		AdminID:      sql.NullString{String: getUserFromContext(r).ID, Valid: true},
+		TargetUserID: sql.NullString{String: targetUserID, Valid: true},
+		Action:       action,
+		Reason:       reason,
+		IP:           sql.NullString{String: clientIP(r), Valid: true},
+	}
+}
+
+// getTargetUser loads the user of the route. It answers 404 and returns nil
+// when there is none.
+func (app *application) getTargetUser(w http.ResponseWriter, r *http.Request) *store.AdminUser {
+	user, err := app.store.AdminUsers.GetByID(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil
+	}
+
+	return user
+}
+
+type AdminUserList struct {
+	Users []*store.AdminUser `json:"users"`
+	Page
+}
+
+// ListUsersHandler searches users with the q, blocked, limit and offset
+// query parameters.
+func (app *application) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := readPage(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter := store.UserFilter{
+		Query:  strings.TrimSpace(r.URL.Query().Get("q")),
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+
+	if s := r.URL.Query().Get("blocked"); s != "" {
+		blocked, err := strconv.ParseBool(s)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("blocked must be true or false"))
+			return
+		}
+		filter.Blocked = &blocked
+	}
+
+	users, total, err := app.store.AdminUsers.Search(r.Context(), filter)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	page.Total = total
+
+	for _, user := range users {
+		app.presentUser(&user.User)
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, AdminUserList{users, page}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type AdminUserDetail struct {
+	User         *store.AdminUser     `json:"user"`
+	AdminActions []*store.AdminAction `json:"admin_actions"`
+}
+
+func (app *application) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	app.presentUser(&user.User)
+
+	actions, err := app.store.AdminActions.ListForUser(r.Context(), user.ID, adminActionHistory)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, AdminUserDetail{user, actions}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type AdminActionPayload struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type BlockUserPayload struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+func (app *application) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var payload BlockUserPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.ID == getUserFromContext(r).ID {
+		app.badRequestResponse(w, r, errCannotTargetSelf)
+		return
+	}
+
+	action := newAdminAction(r, user.ID, adminActionBlock, payload.Reason)
+
+	if err := app.store.AdminUsers.Block(r.Context(), &user.User, action); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("user is already blocked"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.wakeOutboxRelay()
+
+	app.adminActionDone(w, r, action, "User blocked")
+}
+
+func (app *application) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	payload, ok := app.readAdminActionPayload(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	action := newAdminAction(r, user.ID, adminActionUnblock, payload.Reason)
+
+	if err := app.store.AdminUsers.Unblock(r.Context(), &user.User, action); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("user is not blocked"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.wakeOutboxRelay()
+
+	app.adminActionDone(w, r, action, "User unblocked")
+}
+
+// ForceLogoutUserHandler ends every session of the user.
+func (app *application) ForceLogoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	payload, ok := app.readAdminActionPayload(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	action := newAdminAction(r, user.ID, adminActionForceLogout, payload.Reason)
+
+	if err := app.store.AdminUsers.ForceLogout(r.Context(), &user.User, action); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, auditLogoutAll, user.ID, nil)
+
+	app.adminActionDone(w, r, action, "User logged out from all devices")
+}
+
+// ResetUserMFAHandler removes the authenticator app and the recovery codes of
+// a user who lost them. Passkeys are left alone.
+func (app *application) ResetUserMFAHandler(w http.ResponseWriter, r *http.Request) {
+	payload, ok := app.readAdminActionPayload(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.ID == getUserFromContext(r).ID {
+		app.badRequestResponse(w, r, errCannotTargetSelf)
+		return
+	}
+
+	action := newAdminAction(r, user.ID, adminActionResetMFA, payload.Reason)
+
+	if err := app.store.AdminUsers.ResetMFA(r.Context(), user.ID, action); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditMFAReset, user.ID, nil)
+
+	app.adminActionDone(w, r, action, "Two-factor authentication reset")
+}
+
+// SendUserPasswordResetHandler emails the user a password reset link, as if
+// they had asked for it.
+func (app *application) SendUserPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	payload, ok := app.readAdminActionPayload(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.getTargetUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if user.IsBlocked {
+		app.conflictResponse(w, r, errors.New("user is blocked"))
+		return
+	}
+
+	action := newAdminAction(r, user.ID, adminActionPasswordReset, payload.Reason)
+
+	if err := app.sendPasswordResetEmail(r.Context(), &user.User, action); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.adminActionDone(w, r, action, "Password reset email sent")
+}
+
+// readAdminActionPayload reads the optional reason of an admin action. An
+// empty body is accepted.
+func (app *application) readAdminActionPayload(w http.ResponseWriter, r *http.Request) (AdminActionPayload, bool) {
+	var payload AdminActionPayload
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestResponse(w, r, err)
+		return payload, false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return payload, false
+	}
+
+	return payload, true
+}
+
+// adminActionDone logs a completed admin action, already recorded by the
+// store, and answers with message.
+func (app *application) adminActionDone(w http.ResponseWriter, r *http.Request, action *store.AdminAction, message string) {
+	app.logger.Infow("admin action",
+		"event", "admin_action",
+		"action", action.Action,
+		"admin_id", action.AdminID.String,
+		"target_user_id", action.TargetUserID.String,
+		"ip", action.IP.String,
+	)
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, message); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
@@ -12,9 +13,14 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/menaguilherme/trigon/configs"
 	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/blob"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/ratelimit"
 	"github.com/menaguilherme/trigon/internal/store"
+	"github.com/menaguilherme/trigon/internal/webhook"
 	"go.uber.org/zap"
 )
 
@@ -23,29 +29,201 @@ type application struct {
 	logger        *zap.SugaredLogger
 	store         store.Storage
 	authenticator auth.Authenticator
+	mailer        mailer.Client
+	secretBox     *auth.SecretBox
+	webauthn      *webauthn.WebAuthn
+	rateLimiter   ratelimit.Limiter
+	urlSigner     *auth.URLSigner
+	blobs         blob.Store
+	webhooks      *webhook.Client
+	// trustedProxies are the proxies whose forwarding headers are read by
+	// RealIPMiddleware.
+	trustedProxies []netip.Prefix
+	// exportQueue wakes up processDataExports when an export is requested.
+	exportQueue chan struct{}
+	// webhookQueue wakes up processWebhookDeliveries when an event is queued.
+	webhookQueue chan struct{}
+	// outboxQueue wakes up relayOutbox when a domain event is written.
+	outboxQueue chan struct{}
 }
 
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.RealIPMiddleware)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	r.Get("/.well-known/jwks.json", app.JWKSHandler)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.Route("/auth", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("auth"))
 			r.Post("/register", app.RegisterUserHandler)
 			r.Post("/login", app.LoginHandler)
 			r.Post("/refresh", app.RefreshTokenHandler)
+			r.Post("/verify-email", app.VerifyEmailHandler)
+			r.Post("/resend-verification", app.ResendVerificationHandler)
+			r.Post("/password/forgot", app.ForgotPasswordHandler)
+			r.Post("/password/reset", app.ResetPasswordHandler)
+			r.Post("/mfa/verify", app.VerifyMFAHandler)
+			r.Post("/unlock", app.UnlockAccountHandler)
+			r.Post("/magic-link", app.RequestMagicLinkHandler)
+			r.Post("/magic-link/consume", app.ConsumeMagicLinkHandler)
+			r.Post("/otp/start", app.StartEmailOTPHandler)
+			r.Post("/otp/verify", app.VerifyEmailOTPHandler)
+			r.Post("/email-change/confirm", app.ConfirmEmailChangeHandler)
+			r.Post("/email-change/cancel", app.CancelEmailChangeHandler)
+
+			r.Route("/webauthn", func(r chi.Router) {
+				r.Post("/signup/begin", app.BeginPasskeySignupHandler)
+				r.Post("/signup/finish", app.FinishPasskeySignupHandler)
+				r.Post("/login/begin", app.BeginPasskeyLoginHandler)
+				r.Post("/login/finish", app.FinishPasskeyLoginHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Post("/register/begin", app.BeginPasskeyRegistrationHandler)
+					r.Post("/register/finish", app.FinishPasskeyRegistrationHandler)
+					r.Get("/credentials", app.ListPasskeysHandler)
+					r.Delete("/credentials/{credentialID}", app.DeletePasskeyHandler)
+				})
+			})
 
 			r.Group(func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
 				r.Post("/logout", app.LogoutHandler)
 				r.Post("/logout-all", app.LogoutAllHandler)
+
+				r.Route("/mfa/totp", func(r chi.Router) {
+					r.Post("/setup", app.SetupTOTPHandler)
+					r.Post("/confirm", app.ConfirmTOTPHandler)
+					r.Post("/disable", app.DisableTOTPHandler)
+				})
+
+				r.Get("/mfa/recovery-codes", app.GetRecoveryCodesStatusHandler)
+				r.Post("/mfa/recovery-codes", app.RegenerateRecoveryCodesHandler)
+			})
+		})
+
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Use(app.APIKeyScopesMiddleware(scopeSessionsRead, scopeSessionsWrite))
+			r.Use(app.AuthTokenMiddleware)
+			r.Get("/", app.ListSessionsHandler)
+			r.Post("/revoke-others", app.RevokeOtherSessionsHandler)
+			r.Delete("/{sessionID}", app.RevokeSessionHandler)
+		})
+
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.RequireVerifiedEmailMiddleware)
+			r.Post("/", app.CreateAPIKeyHandler)
+			r.Get("/", app.ListAPIKeysHandler)
+			r.Get("/{apiKeyID}", app.GetAPIKeyHandler)
+			r.Patch("/{apiKeyID}", app.UpdateAPIKeyHandler)
+			r.Delete("/{apiKeyID}", app.RevokeAPIKeyHandler)
+		})
+
+		r.Route("/users/me", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.APIKeyScopesMiddleware(scopeProfileRead, scopeProfileWrite))
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/", app.GetCurrentUserHandler)
+				r.Get("/activity", app.GetActivityHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireVerifiedEmailMiddleware)
+					r.Patch("/", app.UpdateCurrentUserHandler)
+					r.Put("/avatar", app.UploadAvatarHandler)
+					r.Delete("/avatar", app.DeleteAvatarHandler)
+				})
 			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Delete("/", app.DeleteAccountHandler)
+				r.Post("/deletion-code", app.SendAccountDeletionCodeHandler)
+				r.Post("/password", app.ChangePasswordHandler)
+				r.Post("/email", app.ChangeEmailHandler)
+				r.Post("/email/code", app.SendEmailChangeCodeHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireVerifiedEmailMiddleware)
+					r.Post("/export", app.RequestDataExportHandler)
+					r.Get("/exports", app.ListDataExportsHandler)
+				})
+			})
+		})
+
+		r.Route("/avatars", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Get("/{userID}/{version}/{file}", app.ServeAvatarHandler)
+		})
+
+		r.Route("/exports", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Get("/{exportID}/download", app.DownloadDataExportHandler)
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.RequireVerifiedEmailMiddleware)
+
+			r.Route("/users", func(r chi.Router) {
+				r.With(app.RequirePermission(permUsersRead)).Get("/", app.ListUsersHandler)
+
+				r.Route("/{userID}", func(r chi.Router) {
+					r.With(app.RequirePermission(permUsersRead)).Get("/", app.GetUserHandler)
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequirePermission(permUsersWrite))
+						r.Post("/block", app.BlockUserHandler)
+						r.Post("/unblock", app.UnblockUserHandler)
+						r.Post("/logout", app.ForceLogoutUserHandler)
+						r.Post("/mfa/reset", app.ResetUserMFAHandler)
+						r.Post("/password-reset", app.SendUserPasswordResetHandler)
+					})
+				})
+			})
+
+			r.With(app.RequirePermission(permAuditRead)).Get("/audit-events", app.ListAuditEventsHandler)
+
+			r.Route("/webhooks", func(r chi.Router) {
+				r.With(app.RequirePermission(permWebhooksRead)).Get("/", app.ListWebhooksHandler)
+				r.With(app.RequirePermission(permWebhooksWrite)).Post("/", app.CreateWebhookHandler)
+
+				r.Route("/{webhookID}", func(r chi.Router) {
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequirePermission(permWebhooksRead))
+						r.Get("/", app.GetWebhookHandler)
+						r.Get("/deliveries", app.ListWebhookDeliveriesHandler)
+						r.Get("/deliveries/{deliveryID}", app.GetWebhookDeliveryHandler)
+					})
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequirePermission(permWebhooksWrite))
+						r.Patch("/", app.UpdateWebhookHandler)
+						r.Delete("/", app.DeleteWebhookHandler)
+						r.Post("/deliveries/{deliveryID}/replay", app.ReplayWebhookDeliveryHandler)
+					})
+				})
+			})
+		})
+
+		r.Route("/roles", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("api"))
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.RequireVerifiedEmailMiddleware)
+			r.Use(app.RequirePermission(permRolesRead))
+			r.Get("/", app.ListRolesHandler)
 		})
 	})
 
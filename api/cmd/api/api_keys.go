@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// API keys start with apiKeyPrefix so they can be told apart from access
+// tokens, and spotted by secret scanners.
+const apiKeyPrefix = "trg_"
+
+const (
+	scopeSessionsRead  = "sessions:read"
+	scopeSessionsWrite = "sessions:write"
+	scopeProfileRead   = "profile:read"
+	scopeProfileWrite  = "profile:write"
+)
+
+// apiKeyScopes lists the scopes a key can be granted.
+var apiKeyScopes = []string{
+	scopeSessionsRead,
+	scopeSessionsWrite,
+	scopeProfileRead,
+	scopeProfileWrite,
+}
+
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			return errors.New("unknown scope " + scope)
+		}
+	}
+
+	return nil
+}
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=80"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreatedAPIKey struct {
+	*store.APIKey
+	// Key is only ever returned here.
+	Key string `json:"key"`
+}
+
+func (app *application) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := validateScopes(payload.Scopes); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	secret, err := gonanoid.Generate("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 40)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	plaintext := apiKeyPrefix + secret
+
+	key := &store.APIKey{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Prefix: plaintext[:len(apiKeyPrefix)+8],
+		Key:    plaintext,
+		Scopes: slices.Compact(slices.Sorted(slices.Values(payload.Scopes))),
+	}
+
+	if payload.ExpiresAt != nil {
+		key.ExpiresAt.Time, key.ExpiresAt.Valid = *payload.ExpiresAt, true
+	}
+
+	if err := app.store.APIKeys.Create(r.Context(), key); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, CreatedAPIKey{APIKey: key, Key: plaintext}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	keys, err := app.store.APIKeys.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) GetAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	key, err := app.store.APIKeys.GetByID(r.Context(), chi.URLParam(r, "apiKeyID"), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, key); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type UpdateAPIKeyPayload struct {
+	Name   *string  `json:"name" validate:"omitempty,min=1,max=80"`
+	Scopes []string `json:"scopes" validate:"omitempty,min=1,dive,required"`
+}
+
+// UpdateAPIKeyHandler renames a key or changes its scopes. The secret and the
+// expiry cannot be changed, a new key has to be created instead.
+func (app *application) UpdateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := validateScopes(payload.Scopes); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+
+	key, err := app.store.APIKeys.GetByID(ctx, chi.URLParam(r, "apiKeyID"), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if payload.Name != nil {
+		key.Name = *payload.Name
+	}
+
+	if payload.Scopes != nil {
+		key.Scopes = slices.Compact(slices.Sorted(slices.Values(payload.Scopes)))
+	}
+
+	if err := app.store.APIKeys.Update(ctx, key); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, key); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	err := app.store.APIKeys.Revoke(r.Context(), chi.URLParam(r, "apiKeyID"), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "API key revoked"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/menaguilherme/trigon/configs"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+	"go.uber.org/zap"
+)
+
+// newTestApplication returns an application backed by the given storage,
+// with an in-memory mailer and no database or queues. Stores the test does not set are nil
+// and panic when used.
+func newTestApplication(t *testing.T, storage store.Storage) *application {
+	t.Helper()
+
+	var cfg configs.Config
+	cfg.Auth.Token.Iss = "trigon"
+	cfg.Auth.Token.Aud = "trigon"
+	cfg.Auth.RegistrationOpen = true
+	cfg.Auth.EmailVerification.Policy = emailVerificationPolicyAllow
+	cfg.Auth.Throttle.Window = time.Hour
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "Trigon",
+		RPOrigins:     []string{"http://localhost:3000"},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if storage.Sessions == nil {
+		storage.Sessions = &fakeSessionStore{}
+	}
+	if storage.RefreshTokens == nil {
+		storage.RefreshTokens = &fakeRefreshTokenStore{}
+	}
+	if storage.AuditEvents == nil {
+		storage.AuditEvents = &fakeAuditEventStore{}
+	}
+	if storage.AuthAttempts == nil {
+		storage.AuthAttempts = &fakeAuthAttemptStore{}
+	}
+
+	return &application{
+		config:        cfg,
+		logger:        zap.NewNop().Sugar(),
+		store:         storage,
+		authenticator: auth.NewJWTAuthenticator("test-secret", cfg.Auth.Token.Aud, cfg.Auth.Token.Iss),
+		mailer:        mailer.NewInMemoryMailer(),
+		webauthn:      webAuthn,
+	}
+}
+
+// The fakes embed the real store so that they satisfy its interface; only
+// the methods the tests go through are implemented.
+
+type fakeSessionStore struct {
+	*store.SessionStore
+}
+
+func (s *fakeSessionStore) Create(_ context.Context, session *store.Session) error {
+	session.ID = "sess_test"
+	return nil
+}
+
+type fakeRefreshTokenStore struct {
+	*store.RefreshTokenStore
+}
+
+func (s *fakeRefreshTokenStore) Create(context.Context, *store.RefreshToken) error {
+	return nil
+}
+
+type fakeAuditEventStore struct {
+	*store.AuditEventStore
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *fakeAuditEventStore) Create(_ context.Context, event *store.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event.Event)
+
+	return nil
+}
+
+type fakeAuthAttemptStore struct {
+	*store.AuthAttemptStore
+
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+}
+
+func (s *fakeAuthAttemptStore) Record(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		s.attempts = map[string][]time.Time{}
+	}
+	s.attempts[key] = append(s.attempts[key], time.Now())
+
+	return nil
+}
+
+func (s *fakeAuthAttemptStore) Window(_ context.Context, key string, since time.Time, limit int) (*store.AttemptWindow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window := &store.AttemptWindow{}
+
+	// Attempts are recorded in order, the latest last.
+	attempts := s.attempts[key]
+	for i := len(attempts) - 1; i >= 0 && attempts[i].After(since); i-- {
+		window.Count++
+		if window.Count == 1 {
+			window.Latest = attempts[i]
+		}
+		if window.Count == limit {
+			window.LimitingAttempt = attempts[i]
+		}
+	}
+
+	return window, nil
+}
+
+func (s *fakeAuthAttemptStore) Clear(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+func (s *fakeAuthAttemptStore) count(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.attempts[key])
+}
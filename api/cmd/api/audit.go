@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+const permAuditRead = "audit:read"
+
+const (
+	auditUserRegistered           = "user_registered"
+	auditLoginSucceeded           = "login_succeeded"
+	auditLoginFailed              = "login_failed"
+	auditAccountLocked            = "account_locked"
+	auditTokenRefreshed           = "token_refreshed"
+	auditRefreshTokenReuse        = "refresh_token_reuse"
+	auditLogout                   = "logout"
+	auditLogoutAll                = "logout_all"
+	auditPasswordChanged          = "password_changed"
+	auditPasswordReset            = "password_reset"
+	auditMFAEnabled               = "mfa_enabled"
+	auditMFADisabled              = "mfa_disabled"
+	auditMFAReset                 = "mfa_reset"
+	auditRecoveryCodesRegenerated = "recovery_codes_regenerated"
+	auditAccountClaimed           = "account_claimed"
+)
+
+// Login methods, recorded with the login events.
+const (
+	loginMethodPassword     = "password"
+	loginMethodEmailOTP     = "email_otp"
+	loginMethodMagicLink    = "magic_link"
+	loginMethodPasskey      = "passkey"
+	loginMethodTOTP         = "totp"
+	loginMethodRecoveryCode = "recovery_code"
+)
+
+// audit records a security event about the user with the given ID, which is
+// empty when the account is unknown, e.g. a login with an unregistered
+// email. The actor is the authenticated user of the request, if any.
+//
+// The event is also logged. A failure to store it is only logged, so that an
+// unavailable audit log does not take sign-ins down with it.
+func (app *application) audit(r *http.Request, event, userID string, metadata map[string]string) {
+	record := &store.AuditEvent{
+		Event:     event,
+		UserID:    sql.NullString{String: userID, Valid: userID != ""},
+		IP:        sql.NullString{String: clientIP(r), Valid: true},
+		UserAgent: truncate(r.UserAgent(), 512),
+		RequestID: middleware.GetReqID(r.Context()),
+		Metadata:  metadata,
+	}
+
+	if actor := getUserFromContext(r); actor != nil {
+		record.ActorID = sql.NullString{String: actor.ID, Valid: true}
+	}
+
+	app.logger.Infow("audit event",
+		"event", event,
+		"user_id", userID,
+		"actor_id", record.ActorID.String,
+		"ip", record.IP.String,
+		"request_id", record.RequestID,
+		"metadata", metadata,
+	)
+
+	if err := app.store.AuditEvents.Create(r.Context(), record); err != nil {
+		app.logger.Errorw("failed to record audit event", "event", event, "user_id", userID, "error", err)
+	}
+}
+
+type AuditEventList struct {
+	Events []*store.AuditEvent `json:"events"`
+	Page
+}
+
+// readAuditEventFilter reads the event, since, until, limit and offset query
+// parameters shared by the activity endpoints.
+func readAuditEventFilter(r *http.Request) (store.AuditEventFilter, Page, error) {
+	page, err := readPage(r)
+	if err != nil {
+		return store.AuditEventFilter{}, page, err
+	}
+
+	query := r.URL.Query()
+
+	filter := store.AuditEventFilter{
+		Event:  query.Get("event"),
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	}
+
+	if filter.Since, err = readTimeParam(r, "since"); err != nil {
+		return filter, page, err
+	}
+
+	if filter.Until, err = readTimeParam(r, "until"); err != nil {
+		return filter, page, err
+	}
+
+	return filter, page, nil
+}
+
+// readTimeParam reads an optional RFC 3339 query parameter.
+func readTimeParam(r *http.Request, name string) (*time.Time, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New(name + " must be an RFC 3339 timestamp")
+	}
+
+	return &t, nil
+}
+
+func (app *application) listAuditEvents(w http.ResponseWriter, r *http.Request, filter store.AuditEventFilter, page Page) {
+	events, total, err := app.store.AuditEvents.List(r.Context(), filter)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	page.Total = total
+
+	if err := app.jsonResponse(w, http.StatusOK, AuditEventList{events, page}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetActivityHandler lists the security events of the authenticated user.
+func (app *application) GetActivityHandler(w http.ResponseWriter, r *http.Request) {
+	filter, page, err := readAuditEventFilter(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter.UserID = getUserFromContext(r).ID
+
+	app.listAuditEvents(w, r, filter, page)
+}
+
+// ListAuditEventsHandler searches the audit log of every user. On top of the
+// activity parameters it takes user_id, actor_id and ip.
+func (app *application) ListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, page, err := readAuditEventFilter(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter.UserID = query.Get("user_id")
+	filter.ActorID = query.Get("actor_id")
+	filter.IP = query.Get("ip")
+
+	app.listAuditEvents(w, r, filter, page)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"time"
 
@@ -9,12 +12,31 @@ import (
 	"github.com/menaguilherme/trigon/internal/store"
 )
 
+const (
+	tokenTypeAccess            = "access"
+	tokenTypeEmailVerification = "email_verification"
+	tokenTypeMFAChallenge      = "mfa_challenge"
+)
+
+// tokenAudience returns the audience of the tokens of the type other than
+// access tokens. These are only read by the API itself, and must not pass
+// for access tokens with the services that verify those through the JWKS.
+func (app *application) tokenAudience(typ string) string {
+	return app.config.Auth.Token.Aud + ":" + typ
+}
+
+const (
+	emailVerificationPolicyAllow    = "allow"
+	emailVerificationPolicyRestrict = "restrict"
+	emailVerificationPolicyDeny     = "deny"
+)
+
 type RegisterUserPayload struct {
 	FirstName string `json:"first_name" validate:"required,max=80"`
 	LastName  string `json:"last_name" validate:"required,max=80"`
 	Username  string `json:"username" validate:"required,max=255"`
 	Email     string `json:"email" validate:"required,email,max=255"`
-	Password  string `json:"password" validate:"required,min=3,max=72"`
+	Password  string `json:"password" validate:"password"`
 }
 
 type AuthInfo struct {
@@ -30,9 +52,19 @@ type UserWithAuth struct {
 }
 
 func (app *application) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.Auth.RegistrationOpen {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
+	if !app.throttle(w, r, "register", app.config.Auth.Throttle.RegisterIPMax) {
+		return
+	}
+
 	var payload RegisterUserPayload
 	if err := readJSON(w, r, &payload); err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	if err := Validate.Struct(payload); err != nil {
@@ -67,6 +99,9 @@ func (app *application) RegisterUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	app.audit(r, auditUserRegistered, user.ID, map[string]string{"method": loginMethodPassword})
+	app.wakeOutboxRelay()
+
 	response := map[string]interface{}{
 		"message": "Successfully created user.",
 	}
@@ -77,6 +112,107 @@ func (app *application) RegisterUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// issueAuthTokens starts a session on the requesting device, signs a new
+// access token for the user and persists a fresh refresh token. Every flow
+// that ends in a signed-in user goes through here, which is also where
+// deleted accounts are restored and successful logins are audited. method is
+// the last factor the user proved.
+func (app *application) issueAuthTokens(r *http.Request, user *store.User, method string) (*AuthInfo, error) {
+	ctx := r.Context()
+
+	if err := app.restoreAccount(ctx, user); err != nil {
+		return nil, err
+	}
+
+	// The user is returned along with the tokens.
+	app.presentUser(user)
+
+	session := &store.Session{
+		UserID:     user.ID,
+		DeviceName: truncate(r.Header.Get("X-Device-Name"), 255),
+		Platform:   truncate(r.Header.Get("X-Device-Platform"), 64),
+		UserAgent:  truncate(r.UserAgent(), 512),
+		IP:         clientIP(r),
+	}
+
+	if err := app.store.Sessions.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	authInfo, refreshToken, err := app.newAuthTokens(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken.FamilyID = session.ID
+
+	if err := app.store.RefreshTokens.Create(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	app.audit(r, auditLoginSucceeded, user.ID, map[string]string{"method": method, "session_id": session.ID})
+
+	return authInfo, nil
+}
+
+// rotateAuthTokens issues new tokens whose refresh token replaces the given
+// one in its family.
+func (app *application) rotateAuthTokens(ctx context.Context, user *store.User, old *store.RefreshToken) (*AuthInfo, error) {
+	authInfo, refreshToken, err := app.newAuthTokens(user, old.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.store.RefreshTokens.Rotate(ctx, old, refreshToken); err != nil {
+		return nil, err
+	}
+
+	return authInfo, nil
+}
+
+func (app *application) newAuthTokens(user *store.User, sessionID string) (*AuthInfo, *store.RefreshToken, error) {
+	expiresIn := 15 * time.Minute
+	expiresAt := time.Now().Add(expiresIn)
+	refreshToken, err := gonanoid.Nanoid(32)
+	refreshExpiresAt := time.Now().Add(7 * 24 * time.Hour)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"exp": expiresAt.Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.Auth.Token.Iss,
+		"aud": app.config.Auth.Token.Aud,
+		"rtv": user.RefreshTokenVersion,
+		"typ": tokenTypeAccess,
+		"sid": sessionID,
+	}
+
+	accessToken, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authInfo := &AuthInfo{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		Type:         "Bearer",
+		ExpiresAt:    refreshExpiresAt,
+	}
+
+	record := &store.RefreshToken{
+		UserID:    user.ID,
+		Token:     refreshToken,
+		Version:   user.RefreshTokenVersion,
+		ExpiresAt: refreshExpiresAt,
+	}
+
+	return authInfo, record, nil
+}
+
 type LoginPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
 	Password string `json:"password" validate:"required,min=3,max=72"`
@@ -94,10 +230,27 @@ func (app *application) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	ctx := r.Context()
+
+	wait, err := app.loginDelay(ctx, r, payload.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, retryAfter(wait))
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
+			if err := app.loginFailed(ctx, r, loginMethodPassword, payload.Email, nil); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
 			app.unauthorizedErrorResponse(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
@@ -105,57 +258,66 @@ func (app *application) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lockout, err := app.store.AccountLockouts.GetActive(ctx, user.ID)
+	if err != nil && err != store.ErrNotFound {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if lockout != nil {
+		app.rateLimitExceededResponse(w, r, retryAfter(time.Until(lockout.LockedUntil)))
+		return
+	}
+
 	if err := user.Password.Compare(payload.Password); err != nil {
+		if err := app.loginFailed(ctx, r, loginMethodPassword, payload.Email, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
 		app.unauthorizedErrorResponse(w, r, err)
 		return
 	}
 
-	expiresIn := 15 * time.Minute
-	expiresAt := time.Now().Add(expiresIn)
-	refreshToken, err := gonanoid.Nanoid(32)
-	refreshExpiresAt := time.Now().Add(7 * 24 * time.Hour)
-	if err != nil {
+	if err := app.store.AuthAttempts.Clear(ctx, loginAccountKey(payload.Email)); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": expiresAt.Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.Auth.Token.Iss,
-		"aud": app.config.Auth.Token.Aud,
-		"rtv": user.RefreshTokenVersion,
+	app.completeLogin(w, r, user, loginMethodPassword)
+}
+
+// completeLogin answers a request whose first factor has been verified. It
+// either challenges the user for their second factor or signs them in.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User, method string) {
+	if !user.IsEmailVerified() && app.config.Auth.EmailVerification.Policy == emailVerificationPolicyDeny {
+		app.emailNotVerifiedResponse(w, r)
+		return
 	}
 
-	accessToken, err := app.authenticator.GenerateToken(claims)
+	mfaEnabled, err := app.hasMFAEnabled(r.Context(), user)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	ctx := r.Context()
-
-	err = app.store.RefreshTokens.Create(ctx, &store.RefreshToken{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		Version:   user.RefreshTokenVersion,
-		ExpiresAt: refreshExpiresAt,
-	})
+	if mfaEnabled {
+		app.mfaChallengeResponse(w, r, user)
+		return
+	}
 
+	authInfo, err := app.issueAuthTokens(r, user, method)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch {
+		case errors.Is(err, errAccountDeleted):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
 	response := UserWithAuth{
-		Auth: AuthInfo{
-			Token:        accessToken,
-			RefreshToken: refreshToken,
-			Type:         "Bearer",
-			ExpiresAt:    refreshExpiresAt,
-		},
+		Auth: *authInfo,
 		User: user,
 	}
 
@@ -170,6 +332,10 @@ type RefreshTokenPayload struct {
 }
 
 func (app *application) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.throttle(w, r, "refresh", app.config.Auth.Throttle.RefreshIPMax) {
+		return
+	}
+
 	var payload RefreshTokenPayload
 	if err := readJSON(w, r, &payload); err != nil {
 		app.badRequestResponse(w, r, err)
@@ -198,6 +364,10 @@ func (app *application) RefreshTokenHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	if tokenRecord.RevokedAt.Valid {
+		if tokenRecord.ReplacedBy.Valid {
+			app.refreshTokenReused(r, tokenRecord)
+		}
+
 		app.jsonMessageResponse(w, http.StatusBadRequest, "Revoked refresh token")
 		return
 	}
@@ -218,70 +388,81 @@ func (app *application) RefreshTokenHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	expiresIn := 15 * time.Minute
-	expiresAt := time.Now().Add(expiresIn)
-	refreshToken, err := gonanoid.Nanoid(32)
-	refreshExpiresAt := time.Now().Add(7 * 24 * time.Hour)
+	authInfo, err := app.rotateAuthTokens(r.Context(), user, tokenRecord)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch err {
+		case store.ErrRefreshTokenReused:
+			app.refreshTokenReused(r, tokenRecord)
+			app.jsonMessageResponse(w, http.StatusBadRequest, "Revoked refresh token")
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": expiresAt.Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
-		"iss": app.config.Auth.Token.Iss,
-		"aud": app.config.Auth.Token.Aud,
-		"rtv": user.RefreshTokenVersion,
+	app.audit(r, auditTokenRefreshed, user.ID, map[string]string{"session_id": tokenRecord.FamilyID})
+
+	if err := app.store.Sessions.Touch(r.Context(), tokenRecord.FamilyID, clientIP(r), truncate(r.UserAgent(), 512)); err != nil {
+		app.logger.Errorw("failed to update session", "session_id", tokenRecord.FamilyID, "error", err)
 	}
 
-	accessToken, err := app.authenticator.GenerateToken(claims)
-	if err != nil {
+	response := UserWithAuth{
+		Auth: *authInfo,
+		User: user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+}
 
+// refreshTokenReused handles the replay of a refresh token that was already
+// rotated. Either the legitimate client or an attacker holds a stolen copy,
+// and since there is no telling which, the whole family is revoked.
+func (app *application) refreshTokenReused(r *http.Request, token *store.RefreshToken) {
 	ctx := r.Context()
 
-	err = app.store.RefreshTokens.RevokeTokenByID(ctx, tokenRecord.ID)
-	if err != nil {
-		app.internalServerError(w, r, err)
-		return
+	app.logger.Warnw("security event: refresh token reuse detected",
+		"event", "refresh_token_reuse",
+		"user_id", token.UserID,
+		"family_id", token.FamilyID,
+		"token_id", token.ID,
+		"ip", r.RemoteAddr,
+		"user_agent", r.UserAgent(),
+	)
+
+	app.audit(r, auditRefreshTokenReuse, token.UserID, map[string]string{"session_id": token.FamilyID, "token_id": token.ID})
+
+	if err := app.store.Sessions.Revoke(ctx, token.FamilyID, token.UserID); err != nil {
+		app.logger.Errorw("failed to revoke session", "session_id", token.FamilyID, "error", err)
 	}
 
-	err = app.store.RefreshTokens.Create(ctx, &store.RefreshToken{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		Version:   user.RefreshTokenVersion,
-		ExpiresAt: refreshExpiresAt,
-	})
+	if !app.config.Auth.Token.RevokeAllOnRefreshReuse {
+		return
+	}
 
+	user, err := app.store.Users.GetByID(ctx, token.UserID)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		app.logger.Errorw("failed to load user", "user_id", token.UserID, "error", err)
 		return
 	}
 
-	response := UserWithAuth{
-		Auth: AuthInfo{
-			Token:        accessToken,
-			RefreshToken: refreshToken,
-			Type:         "Bearer",
-			ExpiresAt:    refreshExpiresAt,
-		},
-		User: user,
+	if err := app.store.Users.IncreaseTokenVersion(ctx, user); err != nil {
+		app.logger.Errorw("failed to increase token version", "user_id", token.UserID, "error", err)
 	}
+}
 
-	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
+// LogoutHandler ends the session of the given refresh token, or the session
+// of the access token when none is sent. Only sessions of the authenticated
+// user can be ended.
 func (app *application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	var payload RefreshTokenPayload
-	if err := readJSON(w, r, &payload); err != nil {
+	var payload LogoutPayload
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
 		app.badRequestResponse(w, r, err)
 		return
 	}
@@ -292,13 +473,47 @@ func (app *application) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	user := getUserFromContext(r)
+	sessionID := getSessionIDFromContext(r)
+
+	if payload.RefreshToken != "" {
+		tokenRecord, err := app.store.RefreshTokens.GetByToken(ctx, payload.RefreshToken)
+		if err != nil {
+			switch err {
+			case store.ErrNotFound:
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if tokenRecord.UserID != user.ID {
+			app.notFoundResponse(w, r, store.ErrNotFound)
+			return
+		}
+
+		sessionID = tokenRecord.FamilyID
+	}
+
+	if sessionID == "" {
+		app.badRequestResponse(w, r, errors.New("refresh_token is required"))
+		return
+	}
 
-	err := app.store.RefreshTokens.RevokeTokenByID(ctx, payload.RefreshToken)
+	err := app.store.Sessions.Revoke(ctx, sessionID, user.ID)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
+	app.audit(r, auditLogout, user.ID, map[string]string{"session_id": sessionID})
+
 	if err := app.jsonMessageResponse(w, http.StatusOK, "Successfully logged out"); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -323,6 +538,8 @@ func (app *application) LogoutAllHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	app.audit(r, auditLogoutAll, user.ID, nil)
+
 	if err := app.jsonMessageResponse(w, http.StatusOK, "Successfully logged out from all devices"); err != nil {
 		app.internalServerError(w, r, err)
 		return
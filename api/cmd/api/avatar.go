@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/avatar"
+	"github.com/menaguilherme/trigon/internal/blob"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+const (
+	avatarFullFile      = "full.jpg"
+	avatarThumbnailFile = "thumb.jpg"
+)
+
+// Avatars are stored under "avatars/<user id>/<version>/<file>" and served
+// from the same path below /v1. A new upload gets a new version, so a URL
+// always shows the same picture and can be cached.
+func avatarBlobKey(avatarKey, file string) string {
+	return avatarKey + "/" + file
+}
+
+// presentUser fills in the signed avatar URLs of the user. The expiry is
+// rounded so the URLs stay the same, and cacheable, for at least URLExp.
+func (app *application) presentUser(user *store.User) {
+	if !user.AvatarKey.Valid {
+		return
+	}
+
+	exp := app.config.Avatar.URLExp
+	expiresAt := time.Now().Truncate(exp).Add(2 * exp)
+
+	url := func(file string) sql.NullString {
+		p := "/v1/" + avatarBlobKey(user.AvatarKey.String, file)
+		return sql.NullString{String: app.config.APIURL + p + "?" + app.urlSigner.Sign(p, expiresAt), Valid: true}
+	}
+
+	user.ProfileURL = url(avatarFullFile)
+	user.ThumbnailURL = url(avatarThumbnailFile)
+}
+
+// UploadAvatarHandler replaces the avatar of the user with the image sent in
+// the "avatar" field of a multipart form.
+func (app *application) UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := app.config.Avatar.MaxBytes
+
+	// Leave room for the multipart headers and other fields.
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes)+64<<10)
+
+	data, err := readAvatarPart(r, maxBytes)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, errAvatarTooLarge), errors.As(err, &maxBytesErr):
+			app.payloadTooLargeResponse(w, r, "avatar must be at most "+strconv.Itoa(maxBytes)+" bytes")
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := avatar.Process(data, app.config.Avatar.MaxPixels)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedType), errors.Is(err, avatar.ErrTooLarge):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	version, err := gonanoid.Nanoid(16)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	key := path.Join("avatars", user.ID, version)
+
+	if err := app.blobs.Put(ctx, avatarBlobKey(key, avatarFullFile), images.Full, avatar.ContentType); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.blobs.Put(ctx, avatarBlobKey(key, avatarThumbnailFile), images.Thumbnail, avatar.ContentType); err != nil {
+		app.deleteAvatar(ctx, key)
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	previous, err := app.store.Users.SetAvatar(ctx, user, sql.NullString{String: key, Valid: true})
+	if err != nil {
+		app.deleteAvatar(ctx, key)
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if previous.Valid {
+		app.deleteAvatar(ctx, previous.String)
+	}
+
+	app.presentUser(user)
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+var (
+	errAvatarTooLarge = errors.New("avatar is too large")
+	errAvatarMissing  = errors.New("avatar field is missing")
+)
+
+// readAvatarPart reads the avatar field of a multipart form without buffering
+// the other fields.
+func readAvatarPart(r *http.Request, maxBytes int) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errAvatarMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "avatar" {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, int64(maxBytes)+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) > maxBytes {
+			return nil, errAvatarTooLarge
+		}
+
+		return data, nil
+	}
+}
+
+func (app *application) DeleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	previous, err := app.store.Users.SetAvatar(ctx, user, sql.NullString{})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if previous.Valid {
+		app.deleteAvatar(ctx, previous.String)
+	}
+
+	user.ProfileURL = sql.NullString{}
+	user.ThumbnailURL = sql.NullString{}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// deleteAvatar removes the blobs of an avatar that is no longer used.
+// Failures are only logged, they leave an orphaned blob behind.
+func (app *application) deleteAvatar(ctx context.Context, key string) {
+	for _, file := range []string{avatarFullFile, avatarThumbnailFile} {
+		if err := app.blobs.Delete(ctx, avatarBlobKey(key, file)); err != nil {
+			app.logger.Errorw("failed to delete avatar", "key", key, "file", file, "error", err)
+		}
+	}
+}
+
+// ServeAvatarHandler serves an avatar to whoever holds its signed URL.
+func (app *application) ServeAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	file := chi.URLParam(r, "file")
+	if file != avatarFullFile && file != avatarThumbnailFile {
+		app.notFoundResponse(w, r, errors.New("unknown avatar file"))
+		return
+	}
+
+	if err := app.urlSigner.Verify(r.URL.Path, r.URL.Query(), time.Now()); err != nil {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	key := path.Join("avatars", chi.URLParam(r, "userID"), chi.URLParam(r, "version"))
+
+	body, err := app.blobs.Get(r.Context(), avatarBlobKey(key, file))
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	// Cache until the signature expires. The content never changes.
+	expires, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	maxAge := int(math.Max(0, time.Until(time.Unix(expires, 0)).Seconds()))
+
+	w.Header().Set("Content-Type", avatar.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge)+", immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		app.logger.Errorw("failed to serve avatar", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// PersonalData is the content of a data export: everything stored about a
+// user, minus secrets such as password hashes, token digests and keys.
+type PersonalData struct {
+	GeneratedAt   time.Time                   `json:"generated_at"`
+	Profile       *store.User                 `json:"profile"`
+	Roles         []string                    `json:"roles"`
+	MFA           PersonalMFAData             `json:"mfa"`
+	Sessions      []*store.Session            `json:"sessions"`
+	RefreshTokens []*store.RefreshToken       `json:"refresh_tokens"`
+	Passkeys      []*store.WebAuthnCredential `json:"passkeys"`
+	APIKeys       []*store.APIKey             `json:"api_keys"`
+	AdminActions  []*store.AdminAction        `json:"admin_actions"`
+	AuditEvents   []*store.AuditEvent         `json:"audit_events"`
+}
+
+type PersonalMFAData struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type DataExportResponse struct {
+	*store.DataExport
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func dataExportPath(id string) string {
+	return "/v1/exports/" + id + "/download"
+}
+
+// dataExportResponse adds the signed download URL to ready exports.
+func (app *application) dataExportResponse(export *store.DataExport) DataExportResponse {
+	response := DataExportResponse{DataExport: export}
+
+	if export.Status == store.DataExportReady && export.ExpiresAt.Valid && export.ExpiresAt.Time.After(time.Now()) {
+		path := dataExportPath(export.ID)
+		response.DownloadURL = app.config.APIURL + path + "?" + app.urlSigner.Sign(path, export.ExpiresAt.Time)
+	}
+
+	return response
+}
+
+// RequestDataExportHandler queues an export of the personal data of the
+// user. They are emailed a download link once it is ready.
+func (app *application) RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	export := &store.DataExport{UserID: user.ID}
+
+	if err := app.store.DataExports.Create(r.Context(), export); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("an export is already in progress"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// Wake the worker up rather than wait for its next poll.
+	select {
+	case app.exportQueue <- struct{}{}:
+	default:
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, app.dataExportResponse(export)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) ListDataExportsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	exports, err := app.store.DataExports.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := make([]DataExportResponse, 0, len(exports))
+	for _, export := range exports {
+		response = append(response, app.dataExportResponse(export))
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DownloadDataExportHandler serves an archive to whoever holds its signed
+// URL, so it can be opened from the email without signing in.
+func (app *application) DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "exportID")
+
+	if err := app.urlSigner.Verify(dataExportPath(id), r.URL.Query(), time.Now()); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrURLExpired):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.forbiddenResponse(w, r)
+		}
+		return
+	}
+
+	export, err := app.store.DataExports.GetReady(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("trigon-export-%s.json", export.CompletedAt.Time.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// processDataExports builds the queued exports, polling for them and
+// waking up early when one is requested.
+func (app *application) processDataExports(ctx context.Context) {
+	poll := time.NewTicker(app.config.DataExport.PollInterval)
+	defer poll.Stop()
+
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-prune.C:
+			if err := app.store.DataExports.Prune(ctx, time.Now()); err != nil {
+				app.logger.Errorw("failed to prune data exports", "error", err)
+			}
+			continue
+		case <-poll.C:
+		case <-app.exportQueue:
+		}
+
+		for {
+			export, err := app.store.DataExports.Claim(ctx)
+			if err != nil {
+				if !errors.Is(err, store.ErrNotFound) {
+					app.logger.Errorw("failed to claim data export", "error", err)
+				}
+				break
+			}
+
+			if err := app.buildDataExport(ctx, export); err != nil {
+				app.logger.Errorw("failed to build data export", "export_id", export.ID, "user_id", export.UserID, "error", err)
+
+				if err := app.store.DataExports.Fail(ctx, export.ID, err.Error()); err != nil {
+					app.logger.Errorw("failed to mark data export failed", "export_id", export.ID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (app *application) buildDataExport(ctx context.Context, export *store.DataExport) error {
+	user, err := app.store.Users.GetByID(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	data, err := app.collectPersonalData(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	archive, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	export.Archive = archive
+	export.ExpiresAt.Time = time.Now().Add(app.config.DataExport.Exp)
+	export.ExpiresAt.Valid = true
+
+	if err := app.store.DataExports.Complete(ctx, export); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username    string
+		DownloadURL string
+		ExpiresIn   string
+	}{
+		Username:    user.FirstName,
+		DownloadURL: app.dataExportResponse(export).DownloadURL,
+		ExpiresIn:   app.config.DataExport.Exp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.DataExportReadyTemplate, user.FirstName, user.Email, vars); err != nil {
+		app.logger.Errorw("failed to send data export ready email", "user_id", user.ID, "error", err.Error())
+	}
+
+	return nil
+}
+
+func (app *application) collectPersonalData(ctx context.Context, user *store.User) (*PersonalData, error) {
+	data := &PersonalData{
+		GeneratedAt: time.Now().UTC(),
+		Profile:     user,
+	}
+
+	access, err := app.store.Roles.GetAccess(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	data.Roles = access.Roles
+
+	if data.MFA.TOTPEnabled, err = app.hasMFAEnabled(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if data.MFA.RecoveryCodesRemaining, err = app.store.RecoveryCodes.CountRemaining(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if data.Sessions, err = app.store.Sessions.ListForUser(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if data.RefreshTokens, err = app.store.RefreshTokens.ListForUser(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if data.Passkeys, err = app.store.WebAuthnCredentials.GetByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if data.APIKeys, err = app.store.APIKeys.GetByUserID(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if data.AdminActions, err = app.store.AdminActions.ListForUser(ctx, user.ID, 0); err != nil {
+		return nil, err
+	}
+
+	if data.AuditEvents, _, err = app.store.AuditEvents.List(ctx, store.AuditEventFilter{UserID: user.ID}); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
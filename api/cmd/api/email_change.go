@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type ChangeEmailPayload struct {
+	NewEmail string `json:"new_email" validate:"required,email,max=255"`
+	// Password confirms the change for an account with a password, Code for
+	// a passwordless one.
+	Password string `json:"password" validate:"max=72"`
+	Code     string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+// ChangeEmailHandler starts an email change after the user re-enters their
+// password or, for passwordless accounts, the code sent by
+// SendEmailChangeCodeHandler. The new address receives a confirmation link
+// and the old one a notice with a link to cancel.
+func (app *application) ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if user.HasPassword() {
+		if err := user.Password.Compare(payload.Password); err != nil {
+			app.wrongPasswordResponse(w, r, user, errors.New("password is incorrect"))
+			return
+		}
+	} else {
+		if payload.Code == "" {
+			app.badRequestResponse(w, r, errors.New("code is required, ask for one to be emailed first"))
+			return
+		}
+
+		err := app.store.EmailOTPs.Verify(ctx, user.ID, store.EmailOTPEmailChange, payload.Code, app.config.Auth.EmailOTP.MaxAttempts)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedErrorResponse(w, r, errors.New("invalid or expired code"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	if strings.EqualFold(payload.NewEmail, user.Email) {
+		app.badRequestResponse(w, r, errors.New("new email is the current email"))
+		return
+	}
+
+	existing, err := app.store.Users.GetByEmail(ctx, payload.NewEmail)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if existing != nil {
+		app.conflictResponse(w, r, store.ErrDuplicateEmail)
+		return
+	}
+
+	token, err := gonanoid.Nanoid(32)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	cancelToken, err := gonanoid.Nanoid(32)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	cfg := app.config.Auth.EmailChange
+
+	change := &store.EmailChange{
+		UserID:          user.ID,
+		OldEmail:        user.Email,
+		NewEmail:        payload.NewEmail,
+		Token:           token,
+		CancelToken:     cancelToken,
+		ExpiresAt:       time.Now().Add(cfg.Exp),
+		CancelExpiresAt: time.Now().Add(cfg.CancelExp),
+	}
+
+	if err := app.store.EmailChanges.Create(ctx, change); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	confirmVars := struct {
+		Username   string
+		ConfirmURL string
+		ExpiresIn  string
+	}{
+		Username:   user.FirstName,
+		ConfirmURL: fmt.Sprintf("%s/confirm-email-change?token=%s", app.config.FrontendURL, url.QueryEscape(token)),
+		ExpiresIn:  cfg.Exp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.EmailChangeConfirmTemplate, user.FirstName, change.NewEmail, confirmVars); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	noticeVars := struct {
+		Username        string
+		NewEmail        string
+		CancelURL       string
+		CancelExpiresIn string
+	}{
+		Username:        user.FirstName,
+		NewEmail:        change.NewEmail,
+		CancelURL:       fmt.Sprintf("%s/cancel-email-change?token=%s", app.config.FrontendURL, url.QueryEscape(cancelToken)),
+		CancelExpiresIn: cfg.CancelExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.EmailChangeNoticeTemplate, user.FirstName, change.OldEmail, noticeVars); err != nil {
+		app.logger.Errorw("failed to send email change notice", "user_id", user.ID, "error", err.Error())
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusAccepted, "Confirmation email sent to the new address"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// SendEmailChangeCodeHandler emails a passwordless user the code confirming
+// an email change to the current address.
+func (app *application) SendEmailChangeCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if user.HasPassword() {
+		app.badRequestResponse(w, r, errors.New("account has a password, confirm the change with it"))
+		return
+	}
+
+	if err := app.sendEmailOTP(r.Context(), user, store.EmailOTPEmailChange, mailer.EmailChangeCodeTemplate); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := EmailOTPStarted{
+		Message:     "A code has been sent to your email",
+		ResendAfter: int(app.config.Auth.EmailOTP.ResendCooldown.Seconds()),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type EmailChangeTokenPayload struct {
+	Token string `json:"token" validate:"required,max=255"`
+}
+
+// ConfirmEmailChangeHandler applies the change. Every session of the user
+// ends, so they sign in again with the new address.
+func (app *application) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload EmailChangeTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	change, err := app.store.EmailChanges.Confirm(r.Context(), payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired confirmation token"))
+		case errors.Is(err, store.ErrDuplicateEmail):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Infow("email changed", "event", "email_changed", "user_id", change.UserID, "ip", clientIP(r))
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Email changed, sign in again with the new address"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// CancelEmailChangeHandler is used from the notice sent to the old address.
+// A confirmed change is reverted and every session of the user ends.
+func (app *application) CancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload EmailChangeTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	change, err := app.store.EmailChanges.Cancel(r.Context(), payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired cancel token"))
+		case errors.Is(err, store.ErrDuplicateEmail):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !change.ConfirmedAt.Valid {
+		if err := app.jsonMessageResponse(w, http.StatusOK, "Email change cancelled"); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Warnw("security event: email change reverted",
+		"event", "email_change_reverted",
+		"user_id", change.UserID,
+		"ip", clientIP(r),
+	)
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Email change reverted, sign in again with your previous address"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeEmailChangeStore struct {
+	*store.EmailChangeStore
+
+	created []*store.EmailChange
+}
+
+func (s *fakeEmailChangeStore) Create(_ context.Context, change *store.EmailChange) error {
+	s.created = append(s.created, change)
+	return nil
+}
+
+func TestChangeEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		// sendCode has a code emailed for the change, or for purpose when
+		// it is set.
+		sendCode bool
+		purpose  string
+		body     func(code string) string
+		wantCode int
+		// wantFailure is whether a failed login is recorded.
+		wantFailure bool
+	}{
+		{
+			name:     "with the password",
+			password: "correct horse",
+			body:     func(string) string { return `{"new_email":"new@example.com","password":"correct horse"}` },
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:        "with a wrong password",
+			password:    "correct horse",
+			body:        func(string) string { return `{"new_email":"new@example.com","password":"wrong"}` },
+			wantCode:    http.StatusUnauthorized,
+			wantFailure: true,
+		},
+		{
+			name:     "passwordless with the emailed code",
+			sendCode: true,
+			body:     func(code string) string { return `{"new_email":"new@example.com","code":"` + code + `"}` },
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "passwordless without a code",
+			body:     func(string) string { return `{"new_email":"new@example.com"}` },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "passwordless with an account deletion code",
+			sendCode: true,
+			purpose:  store.EmailOTPAccountDeletion,
+			body:     func(code string) string { return `{"new_email":"new@example.com","code":"` + code + `"}` },
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &store.User{ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"}
+			if tt.password != "" {
+				if err := user.Password.Set(tt.password); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			changes := &fakeEmailChangeStore{}
+			otps := &fakeEmailOTPStore{codes: map[string]string{}}
+			attempts := &fakeAuthAttemptStore{}
+
+			app := newTestApplication(t, store.Storage{
+				Users:        &fakeUserStore{users: map[string]*store.User{user.ID: user}},
+				EmailChanges: changes,
+				EmailOTPs:    otps,
+				AuthAttempts: attempts,
+			})
+
+			withUser := func(r *http.Request) *http.Request {
+				return r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+			}
+
+			var code string
+			if tt.sendCode {
+				if tt.purpose != "" {
+					if err := app.sendEmailOTP(context.Background(), user, tt.purpose, mailer.AccountDeletionCodeTemplate); err != nil {
+						t.Fatal(err)
+					}
+					code = otps.codes[tt.purpose]
+				} else {
+					w := httptest.NewRecorder()
+					app.SendEmailChangeCodeHandler(w, withUser(httptest.NewRequest(http.MethodPost, "/", nil)))
+					if w.Code != http.StatusOK {
+						t.Fatalf("sending the code: got status %d: %s", w.Code, w.Body)
+					}
+
+					outbox := app.mailer.(*mailer.InMemoryMailer).Outbox()
+					code = regexp.MustCompile(`\d{6}`).FindString(outbox[0].Subject)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			app.ChangeEmailHandler(w, withUser(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body(code)))))
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			if started := len(changes.created) == 1; started != (tt.wantCode == http.StatusAccepted) {
+				t.Errorf("change started %v", started)
+			}
+
+			if failed := len(attempts.attempts[loginAccountKey(user.Email)]) > 0; failed != tt.wantFailure {
+				t.Errorf("failed login recorded %v, want %v", failed, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestSendEmailChangeCodeRefusesAccountsWithPassword(t *testing.T) {
+	user := &store.User{ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"}
+	if err := user.Password.Set("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t, store.Storage{})
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+
+	w := httptest.NewRecorder()
+	app.SendEmailChangeCodeHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
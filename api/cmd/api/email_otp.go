@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type StartEmailOTPPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type EmailOTPStarted struct {
+	Message string `json:"message"`
+	// ResendAfter is the number of seconds before another code can be asked
+	// for.
+	ResendAfter int `json:"resend_after"`
+}
+
+// StartEmailOTPHandler emails a 6-digit sign-in code. The code is sent by the
+// outbox relay, so the response is the same, and as fast, whether or not the
+// email belongs to an account, and whether or not a code was actually sent
+// because of the resend cooldown.
+func (app *application) StartEmailOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var payload StartEmailOTPPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "email_otp", payload.Email) {
+		return
+	}
+
+	request := &store.EmailRequest{Email: payload.Email}
+	if err := app.requestEmail(r.Context(), store.EventEmailOTPRequested, request); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := EmailOTPStarted{
+		Message:     "If the email belongs to an account, a code has been sent to it",
+		ResendAfter: int(app.config.Auth.EmailOTP.ResendCooldown.Seconds()),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// sendEmailOTP emails the user a code for the purpose with the template,
+// unless one was sent within the resend cooldown.
+func (app *application) sendEmailOTP(ctx context.Context, user *store.User, purpose, template string) error {
+	cfg := app.config.Auth.EmailOTP
+
+	latest, err := app.store.EmailOTPs.GetLatest(ctx, user.ID, purpose)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	if latest != nil && time.Since(latest.CreatedAt) < cfg.ResendCooldown {
+		return nil
+	}
+
+	code, err := generateEmailOTPCode()
+	if err != nil {
+		return err
+	}
+
+	otp := &store.EmailOTP{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(cfg.Exp),
+	}
+
+	if err := app.store.EmailOTPs.Create(ctx, otp, code); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username  string
+		Code      string
+		ExpiresIn string
+	}{
+		Username:  user.FirstName,
+		Code:      code,
+		ExpiresIn: cfg.Exp.String(),
+	}
+
+	return app.mailer.Send(template, user.FirstName, user.Email, vars)
+}
+
+func generateEmailOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+type VerifyEmailOTPPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Code  string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// VerifyEmailOTPHandler exchanges a code for the response of LoginHandler.
+// Wrong codes count as failed logins towards throttling and lockout.
+func (app *application) VerifyEmailOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyEmailOTPPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	invalidCode := errors.New("invalid or expired code")
+
+	wait, err := app.loginDelay(ctx, r, payload.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, retryAfter(wait))
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			if err := app.loginFailed(ctx, r, loginMethodEmailOTP, payload.Email, nil); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			app.unauthorizedErrorResponse(w, r, invalidCode)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	lockout, err := app.store.AccountLockouts.GetActive(ctx, user.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if lockout != nil {
+		app.rateLimitExceededResponse(w, r, retryAfter(time.Until(lockout.LockedUntil)))
+		return
+	}
+
+	err = app.store.EmailOTPs.Verify(ctx, user.ID, store.EmailOTPLogin, payload.Code, app.config.Auth.EmailOTP.MaxAttempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			if err := app.loginFailed(ctx, r, loginMethodEmailOTP, payload.Email, user); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			app.unauthorizedErrorResponse(w, r, invalidCode)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.AuthAttempts.Clear(ctx, loginAccountKey(payload.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !user.IsEmailVerified() {
+		user, err = app.claimUnverified(r, user, loginMethodEmailOTP)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	app.completeLogin(w, r, user, loginMethodEmailOTP)
+}
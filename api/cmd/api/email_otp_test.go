@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+func TestStartEmailOTP(t *testing.T) {
+	users := &fakeUserStore{users: map[string]*store.User{
+		"usr_1": {ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"},
+	}}
+	otps := &fakeEmailOTPStore{codes: map[string]string{}}
+
+	app := newTestApplication(t, store.Storage{Users: users, Outbox: &fakeOutboxStore{}, EmailOTPs: otps})
+	mail := app.mailer.(*mailer.InMemoryMailer)
+
+	events := postEmails(t, app, app.StartEmailOTPHandler, "ada@example.com", "nobody@example.com")
+
+	for _, event := range events {
+		if event.Event != store.EventEmailOTPRequested {
+			t.Errorf("got event %q", event.Event)
+		}
+
+		if err := app.sendRequestedEmailOTP(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := mail.Outbox()
+	if len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Fatalf("got %d emails, want 1 to the account", len(sent))
+	}
+
+	if _, ok := otps.codes[store.EmailOTPLogin]; !ok {
+		t.Error("no sign-in code was created")
+	}
+}
+
+type fakeAccountLockoutStore struct {
+	*store.AccountLockoutStore
+}
+
+func (s *fakeAccountLockoutStore) GetActive(context.Context, string) (*store.AccountLockout, error) {
+	return nil, store.ErrNotFound
+}
+
+type fakeClaimedUserStore struct {
+	*fakeUserStore
+
+	claimed []string
+}
+
+// ClaimUnverified mimics the query of UserStore.ClaimUnverified, as far as
+// the user row goes.
+func (s *fakeClaimedUserStore) ClaimUnverified(_ context.Context, userID string) error {
+	user := s.users[userID]
+	if user.IsEmailVerified() {
+		return nil
+	}
+
+	s.claimed = append(s.claimed, userID)
+	user.EmailVerifiedAt = sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true}
+	user.Password = store.User{}.Password
+
+	return nil
+}
+
+func TestVerifyEmailOTPClaimsUnverifiedAccount(t *testing.T) {
+	for _, verified := range []bool{false, true} {
+		user := &store.User{ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"}
+		if err := user.Password.Set("set by someone else"); err != nil {
+			t.Fatal(err)
+		}
+		user.EmailVerifiedAt.Valid = verified
+
+		users := &fakeClaimedUserStore{fakeUserStore: &fakeUserStore{users: map[string]*store.User{user.ID: user}}}
+		audit := &fakeAuditEventStore{}
+
+		app := newTestApplication(t, store.Storage{
+			Users:           users,
+			EmailOTPs:       &fakeEmailOTPStore{codes: map[string]string{store.EmailOTPLogin: "123456"}},
+			AccountLockouts: &fakeAccountLockoutStore{},
+			TOTP:            &fakeTOTPStore{},
+			AuditEvents:     audit,
+		})
+
+		w := httptest.NewRecorder()
+		app.VerifyEmailOTPHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"ada@example.com","code":"123456"}`)))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("verified %v: got status %d: %s", verified, w.Code, w.Body)
+		}
+
+		if verified {
+			if len(users.claimed) != 0 || !user.HasPassword() {
+				t.Error("a verified account was claimed")
+			}
+			continue
+		}
+
+		if len(users.claimed) != 1 || user.HasPassword() || !user.IsEmailVerified() {
+			t.Errorf("the account was not claimed: %+v", user)
+		}
+
+		if !slices.Contains(audit.events, auditAccountClaimed) {
+			t.Errorf("got audit events %v", audit.events)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// sendVerificationEmail invalidates any pending verification of the user,
+// creates a new one and emails a signed token referencing it.
+func (app *application) sendVerificationEmail(ctx context.Context, user *store.User) error {
+	if err := app.store.EmailVerifications.InvalidateForUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	exp := app.config.Auth.EmailVerification.Exp
+
+	verification := &store.EmailVerification{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(exp),
+	}
+
+	if err := app.store.EmailVerifications.Create(ctx, verification); err != nil {
+		return err
+	}
+
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"jti": verification.ID,
+		"exp": verification.ExpiresAt.Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.Auth.Token.Iss,
+		"aud": app.tokenAudience(tokenTypeEmailVerification),
+		"typ": tokenTypeEmailVerification,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username        string
+		VerificationURL string
+		ExpiresIn       string
+	}{
+		Username:        user.FirstName,
+		VerificationURL: fmt.Sprintf("%s/verify-email?token=%s", app.config.FrontendURL, url.QueryEscape(token)),
+		ExpiresIn:       exp.String(),
+	}
+
+	return app.mailer.Send(mailer.VerifyEmailTemplate, user.FirstName, user.Email, vars)
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (app *application) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateTokenFor(payload.Token, app.tokenAudience(tokenTypeEmailVerification))
+	if err != nil {
+		app.jsonMessageResponse(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+
+	if typ, _ := claims["typ"].(string); typ != tokenTypeEmailVerification {
+		app.jsonMessageResponse(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	}
+
+	userID, err := claims.GetSubject()
+	if err != nil {
+		app.jsonMessageResponse(w, http.StatusBadRequest, "Invalid verification token")
+		return
+	}
+
+	verificationID, _ := claims["jti"].(string)
+
+	err = app.store.EmailVerifications.Verify(r.Context(), verificationID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.jsonMessageResponse(w, http.StatusBadRequest, "Invalid verification token")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Email successfully verified"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ResendVerificationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendVerificationHandler always answers with the same message so that it
+// cannot be used to find out which emails are registered.
+func (app *application) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendVerificationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "resend_verification", payload.Email) {
+		return
+	}
+
+	if err := app.requestEmail(r.Context(), store.EventVerificationEmailRequested, &store.EmailRequest{Email: payload.Email}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "If the account exists and is not verified, a new verification email has been sent"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// claimUnverified verifies the email of an unverified user who proved access
+// to the mailbox with the login method, and returns the user as it is now.
+// The credentials and sessions of the account are dropped, in case someone
+// else created it before the owner of the mailbox.
+func (app *application) claimUnverified(r *http.Request, user *store.User, method string) (*store.User, error) {
+	if err := app.store.Users.ClaimUnverified(r.Context(), user.ID); err != nil {
+		return nil, err
+	}
+
+	app.audit(r, auditAccountClaimed, user.ID, map[string]string{"method": method})
+
+	return app.store.Users.GetByID(r.Context(), user.ID)
+}
@@ -11,35 +11,72 @@ func (app *application) internalServerError(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request) {
-	app.logger.Warnw("forbidden", "method", r.Method, "path", r.URL.Path, "error")
+	app.logger.Warnw("forbidden", "method", r.Method, "path", r.URL.Path)
 
 	writeJSONError(w, http.StatusForbidden, "forbidden")
 }
 
+func (app *application) emailNotVerifiedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("email not verified", "method", r.Method, "path", r.URL.Path)
+
+	writeJSONError(w, http.StatusForbidden, "email address is not verified")
+}
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("registration closed", "method", r.Method, "path", r.URL.Path)
+
+	writeJSONError(w, http.StatusForbidden, "registration is closed")
+}
+
+// scopeRequiredResponse rejects an API key that lacks the scope of the
+// route, or any API key when the route takes none.
+func (app *application) scopeRequiredResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	app.logger.Warnw("api key scope required", "method", r.Method, "path", r.URL.Path, "scope", scope)
+
+	if scope == "" {
+		writeJSONError(w, http.StatusForbidden, "api keys cannot be used for this endpoint")
+		return
+	}
+
+	writeJSONError(w, http.StatusForbidden, "api key is missing the "+scope+" scope")
+}
+
+func (app *application) permissionRequiredResponse(w http.ResponseWriter, r *http.Request, permission string) {
+	app.logger.Warnw("permission required", "method", r.Method, "path", r.URL.Path, "permission", permission)
+
+	writeJSONError(w, http.StatusForbidden, "missing the "+permission+" permission")
+}
+
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.logger.Warnw("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
 	writeJSONError(w, http.StatusBadRequest, err.Error())
 }
 
 func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorf("conflict response", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.logger.Warnw("conflict response", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
 	writeJSONError(w, http.StatusConflict, err.Error())
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("not found error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.logger.Warnw("not found error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
 	writeJSONError(w, http.StatusNotFound, "not found")
 }
 
 func (app *application) unauthorizedErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("unauthorized error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.logger.Warnw("unauthorized error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
 	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
 }
 
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.logger.Warnw("payload too large", "method", r.Method, "path", r.URL.Path)
+
+	writeJSONError(w, http.StatusRequestEntityTooLarge, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
 	app.logger.Warnw("rate limit exceeded", "method", r.Method, "path", r.URL.Path)
 
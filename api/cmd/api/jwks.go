@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/menaguilherme/trigon/internal/auth"
+)
+
+// JWKSHandler publishes the public keys that verify our access tokens. With
+// the shared-secret HS256 authenticator the set is empty.
+func (app *application) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	jwks := auth.JWKS{Keys: []auth.JWK{}}
+	if keySet, ok := app.authenticator.(auth.PublicKeySet); ok {
+		jwks = keySet.JWKS()
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := app.jsonResponse(w, http.StatusOK, jwks); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
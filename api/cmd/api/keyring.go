@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/menaguilherme/trigon/configs"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/keyring"
+)
+
+// newKeyRing loads the signing keys stored in the database. The keys of the
+// static configuration are added as verify-only keys so tokens issued before
+// the key ring was enabled stay valid until they expire.
+func newKeyRing(ctx context.Context, cfg configs.Config, manager *keyring.Manager) (*auth.KeyRing, error) {
+	keys, err := loadKeyRing(ctx, cfg, manager)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewKeyRing(cfg.Auth.Token.Aud, cfg.Auth.Token.Iss, keys)
+}
+
+func loadKeyRing(ctx context.Context, cfg configs.Config, manager *keyring.Manager) ([]*auth.SigningKey, error) {
+	keys, err := manager.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenCfg := cfg.Auth.Token
+
+	if tokenCfg.KeyRing.VerifyLegacySecret {
+		keys = append(keys, auth.NewLegacySecretKey(tokenCfg.Secret))
+	}
+
+	if tokenCfg.PrivateKeyFile != "" {
+		pemBytes, err := os.ReadFile(tokenCfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+
+		signer, err := auth.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := tokenCfg.KeyID
+		if kid == "" {
+			kid, err = auth.Thumbprint(signer.Public())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		key, err := auth.NewSigningKey(kid, auth.KeyStatusVerify, signer)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// refreshKeyRing reloads the key ring periodically so keys promoted or
+// retired by the keys command are picked up without a restart.
+func (app *application) refreshKeyRing(ctx context.Context, ring *auth.KeyRing, manager *keyring.Manager) {
+	ticker := time.NewTicker(app.config.Auth.Token.KeyRing.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := loadKeyRing(ctx, app.config, manager)
+			if err != nil {
+				app.logger.Errorw("failed to load signing keys", "error", err)
+				continue
+			}
+
+			if err := ring.Replace(keys); err != nil {
+				app.logger.Errorw("failed to refresh key ring", "error", err)
+			}
+		}
+	}
+}
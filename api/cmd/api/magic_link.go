@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type RequestMagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type MagicLinkRequested struct {
+	Message string `json:"message"`
+	// DeviceToken must be sent back when the link is consumed. It binds the
+	// link to the client that requested it.
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// RequestMagicLinkHandler emails a sign-in link, or a sign-up link when no
+// account uses the email and registration is open. The link is sent by the
+// outbox relay, so the response is the same, and as fast, in every case, and
+// cannot be used to find out which emails are registered.
+func (app *application) RequestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RequestMagicLinkPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "magic_link", payload.Email) {
+		return
+	}
+
+	var deviceToken string
+	if app.config.Auth.MagicLink.BindDevice {
+		var err error
+		deviceToken, err = gonanoid.Nanoid(32)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	request := &store.EmailRequest{Email: payload.Email, DeviceToken: deviceToken}
+	if err := app.requestEmail(r.Context(), store.EventMagicLinkRequested, request); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := MagicLinkRequested{
+		Message:     "If the email can be used to sign in, a link has been sent to it",
+		DeviceToken: deviceToken,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// sendMagicLink emails a link signing in the user or, when user is nil,
+// signing up the address. A link with a deviceHash can only be used by the
+// client holding its device token.
+func (app *application) sendMagicLink(ctx context.Context, email string, user *store.User, deviceHash string) error {
+	token, err := gonanoid.Nanoid(32)
+	if err != nil {
+		return err
+	}
+
+	exp := app.config.Auth.MagicLink.Exp
+
+	link := &store.MagicLink{
+		Email:      email,
+		Token:      token,
+		DeviceHash: deviceHash,
+		ExpiresAt:  time.Now().Add(exp),
+	}
+
+	var username string
+	if user != nil {
+		link.UserID.String, link.UserID.Valid = user.ID, true
+		username = user.FirstName
+	}
+
+	if err := app.store.MagicLinks.Create(ctx, link); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username     string
+		SignUp       bool
+		MagicLinkURL string
+		ExpiresIn    string
+	}{
+		Username:     username,
+		SignUp:       link.IsSignUp(),
+		MagicLinkURL: fmt.Sprintf("%s/magic-link?token=%s", app.config.FrontendURL, url.QueryEscape(token)),
+		ExpiresIn:    exp.String(),
+	}
+
+	return app.mailer.Send(mailer.MagicLinkTemplate, username, email, vars)
+}
+
+type ConsumeMagicLinkPayload struct {
+	Token       string `json:"token" validate:"required,max=255"`
+	DeviceToken string `json:"device_token" validate:"max=255"`
+	// The profile is only read when the link signs up a new account.
+	FirstName string `json:"first_name" validate:"max=80"`
+	LastName  string `json:"last_name" validate:"max=80"`
+	Username  string `json:"username" validate:"max=255"`
+}
+
+// ConsumeMagicLinkHandler exchanges a magic link for the response of
+// LoginHandler. A sign-up link also needs the profile of the new account;
+// without it, or when the username is taken, the link is left unused and the
+// client is asked for it.
+func (app *application) ConsumeMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ConsumeMagicLinkPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	invalidLink := errors.New("invalid or expired link")
+
+	link, err := app.store.MagicLinks.Get(ctx, payload.Token, payload.DeviceToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, invalidLink)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	var user *store.User
+	if link.IsSignUp() {
+		if !app.config.Auth.RegistrationOpen {
+			app.registrationClosedResponse(w, r)
+			return
+		}
+
+		if payload.FirstName == "" || payload.LastName == "" || payload.Username == "" {
+			app.badRequestResponse(w, r, errors.New("first_name, last_name and username are required to create the account"))
+			return
+		}
+
+		user = &store.User{
+			FirstName: payload.FirstName,
+			LastName:  payload.LastName,
+			Username:  payload.Username,
+		}
+
+		// Opening the link proves access to the mailbox, the account is
+		// created verified.
+		_, err := app.store.MagicLinks.ConsumeSignUp(ctx, payload.Token, payload.DeviceToken, user)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestResponse(w, r, invalidLink)
+			case errors.Is(err, store.ErrDuplicateEmail), errors.Is(err, store.ErrDuplicateUsername):
+				app.conflictResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		app.wakeOutboxRelay()
+
+		app.audit(r, auditUserRegistered, user.ID, map[string]string{"method": loginMethodMagicLink})
+	} else {
+		link, err = app.store.MagicLinks.Consume(ctx, payload.Token, payload.DeviceToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestResponse(w, r, invalidLink)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		user, err = app.store.Users.GetByID(ctx, link.UserID.String)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestResponse(w, r, invalidLink)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if !user.IsEmailVerified() {
+			user, err = app.claimUnverified(r, user, loginMethodMagicLink)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+		}
+	}
+
+	mfaEnabled, err := app.hasMFAEnabled(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if mfaEnabled {
+		app.mfaChallengeResponse(w, r, user)
+		return
+	}
+
+	authInfo, err := app.issueAuthTokens(r, user, loginMethodMagicLink)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountDeleted):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if link.IsSignUp() {
+		status = http.StatusCreated
+	}
+
+	response := UserWithAuth{
+		Auth: *authInfo,
+		User: user,
+	}
+
+	if err := app.jsonResponse(w, status, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
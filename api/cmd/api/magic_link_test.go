@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeMagicLinkStore struct {
+	*store.MagicLinkStore
+
+	created []*store.MagicLink
+}
+
+func (s *fakeMagicLinkStore) Create(_ context.Context, link *store.MagicLink) error {
+	s.created = append(s.created, link)
+	return nil
+}
+
+func TestRequestMagicLink(t *testing.T) {
+	for _, registrationOpen := range []bool{true, false} {
+		users := &fakeUserStore{users: map[string]*store.User{
+			"usr_1": {ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"},
+		}}
+		links := &fakeMagicLinkStore{}
+
+		app := newTestApplication(t, store.Storage{Users: users, Outbox: &fakeOutboxStore{}, MagicLinks: links})
+		app.config.Auth.RegistrationOpen = registrationOpen
+		mail := app.mailer.(*mailer.InMemoryMailer)
+
+		events := postEmails(t, app, app.RequestMagicLinkHandler, "ada@example.com", "nobody@example.com")
+
+		for _, event := range events {
+			if event.Event != store.EventMagicLinkRequested {
+				t.Errorf("got event %q", event.Event)
+			}
+
+			if err := app.sendRequestedMagicLink(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := 1
+		if registrationOpen {
+			want = 2
+		}
+
+		if sent := mail.Outbox(); len(sent) != want || sent[0].To != "ada@example.com" {
+			t.Fatalf("registration open %v: got %d emails, want %d", registrationOpen, len(sent), want)
+		}
+
+		if !links.created[0].UserID.Valid || links.created[0].UserID.String != "usr_1" {
+			t.Errorf("the sign-in link is for %+v", links.created[0].UserID)
+		}
+
+		if registrationOpen && (!links.created[1].IsSignUp() || links.created[1].Email != "nobody@example.com") {
+			t.Errorf("got sign-up link %+v", links.created[1])
+		}
+	}
+}
+
+func TestRequestMagicLinkBindsDevice(t *testing.T) {
+	links := &fakeMagicLinkStore{}
+
+	app := newTestApplication(t, store.Storage{Users: &fakeUserStore{}, Outbox: &fakeOutboxStore{}, MagicLinks: links})
+	app.config.Auth.MagicLink.BindDevice = true
+
+	events := postEmails(t, app, app.RequestMagicLinkHandler, "nobody@example.com")
+
+	var request store.EmailRequest
+	if err := json.Unmarshal(events[0].Payload, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.DeviceToken != "" || request.DeviceHash == "" || request.DeviceHash == "hash:" {
+		t.Fatalf("the outbox holds %+v, want only the hash of the device token", request)
+	}
+
+	if err := app.sendRequestedMagicLink(context.Background(), events[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(links.created) != 1 || links.created[0].DeviceHash != request.DeviceHash {
+		t.Errorf("got links %+v", links.created)
+	}
+}
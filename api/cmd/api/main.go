@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/menaguilherme/trigon/configs"
 	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/blob"
 	"github.com/menaguilherme/trigon/internal/db"
+	"github.com/menaguilherme/trigon/internal/keyring"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/outbox"
+	"github.com/menaguilherme/trigon/internal/ratelimit"
 	"github.com/menaguilherme/trigon/internal/store"
+	"github.com/menaguilherme/trigon/internal/webhook"
 	"go.uber.org/zap"
 )
 
@@ -25,22 +37,180 @@ func main() {
 	defer db.Close()
 	logger.Info("database connection pool established")
 
-	jwtAuthenticator := auth.NewJWTAuthenticator(
-		configs.Envs.Auth.Token.Secret,
-		configs.Envs.Auth.Token.Aud,
-		configs.Envs.Auth.Token.Iss,
-	)
+	encryptionKey, err := configs.Envs.EncryptionKey()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	secretBox, err := auth.NewSecretBox(encryptionKey)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          configs.Envs.Auth.WebAuthn.RPID,
+		RPDisplayName: configs.Envs.Auth.WebAuthn.RPDisplayName,
+		RPOrigins:     configs.Envs.Auth.WebAuthn.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	store := store.NewStorage(db)
 
+	var authenticator auth.Authenticator
+	var keyRing *auth.KeyRing
+	keyManager := keyring.NewManager(store.SigningKeys, secretBox)
+
+	if configs.Envs.Auth.Token.KeyRing.Enabled {
+		keyRing, err = newKeyRing(context.Background(), configs.Envs, keyManager)
+		if err != nil {
+			logger.Fatalw("failed to load key ring, run `go run ./cmd/keys rotate` to create the first key", "error", err)
+		}
+		authenticator = keyRing
+	} else {
+		authenticator, err = newAuthenticator(configs.Envs)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	var mailClient mailer.Client
+	if configs.Envs.Mail.SMTPHost != "" {
+		mailClient = mailer.NewSMTPMailer(
+			configs.Envs.Mail.SMTPHost,
+			configs.Envs.Mail.SMTPPort,
+			configs.Envs.Mail.SMTPUsername,
+			configs.Envs.Mail.SMTPPassword,
+			configs.Envs.Mail.FromEmail,
+		)
+	} else {
+		logger.Warn("SMTP_HOST is not set, emails will be kept in memory and not delivered")
+		mailClient = mailer.NewInMemoryMailer()
+	}
+
+	var rateLimiter ratelimit.Limiter
+	var pgLimiter *ratelimit.PostgresLimiter
+	switch configs.Envs.RateLimit.Backend {
+	case "postgres":
+		pgLimiter = ratelimit.NewPostgresLimiter(db)
+		rateLimiter = pgLimiter
+	case "memory":
+		rateLimiter = ratelimit.NewMemoryLimiter()
+	default:
+		logger.Fatalf("unknown RATE_LIMIT_BACKEND %q", configs.Envs.RateLimit.Backend)
+	}
+
+	var blobs blob.Store
+	switch configs.Envs.Blob.Backend {
+	case "local":
+		blobs, err = blob.NewFSStore(configs.Envs.Blob.LocalDir)
+	case "s3":
+		s3 := configs.Envs.Blob.S3
+		blobs, err = blob.NewS3Store(blob.S3Config{
+			Endpoint:        s3.Endpoint,
+			Region:          s3.Region,
+			Bucket:          s3.Bucket,
+			AccessKeyID:     s3.AccessKeyID,
+			SecretAccessKey: s3.SecretAccessKey,
+			UsePathStyle:    s3.UsePathStyle,
+		})
+	default:
+		err = fmt.Errorf("unknown BLOB_BACKEND %q", configs.Envs.Blob.Backend)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	trustedProxies, err := parseTrustedProxies(configs.Envs.TrustedProxies)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	app := &application{
-		config:        configs.Envs,
-		logger:        logger,
-		store:         store,
-		authenticator: jwtAuthenticator,
+		config:         configs.Envs,
+		logger:         logger,
+		store:          store,
+		authenticator:  authenticator,
+		mailer:         mailClient,
+		secretBox:      secretBox,
+		webauthn:       webAuthn,
+		rateLimiter:    rateLimiter,
+		urlSigner:      auth.NewURLSigner(encryptionKey),
+		exportQueue:    make(chan struct{}, 1),
+		blobs:          blobs,
+		webhooks:       webhook.NewClient(configs.Envs.Webhook.Timeout, "trigon-webhooks/1"),
+		webhookQueue:   make(chan struct{}, 1),
+		outboxQueue:    make(chan struct{}, 1),
+		trustedProxies: trustedProxies,
+	}
+
+	relay := outbox.NewRelay(store.Outbox, outbox.Policy{
+		BatchSize:   configs.Envs.Outbox.BatchSize,
+		Lease:       configs.Envs.Outbox.Lease,
+		MaxAttempts: configs.Envs.Outbox.MaxAttempts,
+		BackoffBase: configs.Envs.Outbox.BackoffBase,
+		BackoffMax:  configs.Envs.Outbox.BackoffMax,
+	})
+	app.registerOutboxHandlers(relay)
+
+	if keyRing != nil {
+		go app.refreshKeyRing(context.Background(), keyRing, keyManager)
+	}
+
+	go app.pruneAuthAttempts(context.Background())
+	go app.pruneMFAChallenges(context.Background())
+	go app.pruneWebAuthnSessions(context.Background())
+	go app.purgeDeletedAccounts(context.Background())
+	go app.processDataExports(context.Background())
+	go app.pruneWebhookDeliveries(context.Background())
+	go app.relayOutbox(context.Background(), relay)
+	go app.pruneOutbox(context.Background())
+
+	for range configs.Envs.Webhook.Workers {
+		go app.processWebhookDeliveries(context.Background())
+	}
+
+	if pgLimiter != nil {
+		go app.pruneRateLimitBuckets(context.Background(), pgLimiter)
 	}
 
 	mux := app.mount()
 
 	logger.Fatal(app.run(mux))
 }
+
+// newAuthenticator returns the HS256 authenticator or, for asymmetric
+// algorithms, one signing with the configured private key.
+func newAuthenticator(cfg configs.Config) (auth.Authenticator, error) {
+	tokenCfg := cfg.Auth.Token
+
+	if tokenCfg.Algorithm == "HS256" {
+		return auth.NewJWTAuthenticator(tokenCfg.Secret, tokenCfg.Aud, tokenCfg.Iss), nil
+	}
+
+	pemBytes, err := os.ReadFile(tokenCfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT_PRIVATE_KEY_FILE: %w", err)
+	}
+
+	key, err := auth.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticator, err := auth.NewKeyAuthenticator(key, tokenCfg.KeyID, tokenCfg.Aud, tokenCfg.Iss)
+	if err != nil {
+		return nil, err
+	}
+
+	if authenticator.Algorithm() != tokenCfg.Algorithm {
+		return nil, fmt.Errorf("JWT_ALGORITHM is %s but the private key is for %s", tokenCfg.Algorithm, authenticator.Algorithm())
+	}
+
+	return authenticator, nil
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	errInvalidMFACode      = errors.New("invalid verification code")
+	errMFAChallengeUsed    = errors.New("verification expired or too many attempts, sign in again")
+	errInvalidRecoveryCode = errors.New("invalid recovery code")
+	errMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+)
+
+type MFAChallenge struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (app *application) hasMFAEnabled(ctx context.Context, user *store.User) (bool, error) {
+	totp, err := app.store.TOTP.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return totp.IsConfirmed(), nil
+}
+
+// mfaChallengeResponse answers a correct first factor of an MFA-enabled user
+// with a short-lived token that must be exchanged at /v1/auth/mfa/verify. The
+// token references a stored challenge that limits the codes tried with it.
+func (app *application) mfaChallengeResponse(w http.ResponseWriter, r *http.Request, user *store.User) {
+	expiresAt := time.Now().Add(app.config.Auth.MFA.ChallengeExp)
+
+	challenge := &store.MFAChallenge{
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := app.store.MFAChallenges.Create(r.Context(), challenge); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"jti": challenge.ID,
+		"exp": expiresAt.Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.Auth.Token.Iss,
+		"aud": app.tokenAudience(tokenTypeMFAChallenge),
+		"rtv": user.RefreshTokenVersion,
+		"typ": tokenTypeMFAChallenge,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   expiresAt,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// verifyTOTPCode checks the code against the user's secret and burns its time
+// step so that the same code cannot be replayed.
+func (app *application) verifyTOTPCode(ctx context.Context, totp *store.TOTP, code string) error {
+	secret, err := app.secretBox.Open(totp.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := auth.ValidateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	if err := app.store.TOTP.UseStep(ctx, totp.UserID, step); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTOTPCodeReused):
+			return errInvalidMFACode
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generateRecoveryCodes replaces the user's recovery codes with a fresh set
+// and returns the plaintext codes, which are shown to the user only once.
+func (app *application) generateRecoveryCodes(ctx context.Context, user *store.User) ([]string, error) {
+	alphabet := "abcdefghijkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := gonanoid.Generate(alphabet, 10)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	if err := app.store.RecoveryCodes.Replace(ctx, user.ID, codes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode consumes a recovery code and lets the user know that one
+// was used, since that usually means the authenticator device is gone.
+func (app *application) useRecoveryCode(ctx context.Context, user *store.User, code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	if err := app.store.RecoveryCodes.Use(ctx, user.ID, code); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return errInvalidRecoveryCode
+		default:
+			return err
+		}
+	}
+
+	remaining, err := app.store.RecoveryCodes.CountRemaining(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Infow("mfa recovery code used", "user_id", user.ID, "remaining", remaining)
+
+	vars := struct {
+		Username  string
+		UsedAt    string
+		Remaining int
+	}{
+		Username:  user.FirstName,
+		UsedAt:    time.Now().UTC().Format(time.RFC1123),
+		Remaining: remaining,
+	}
+
+	if err := app.mailer.Send(mailer.RecoveryCodeUsedTemplate, user.FirstName, user.Email, vars); err != nil {
+		app.logger.Errorw("failed to send recovery code notification", "user_id", user.ID, "error", err.Error())
+	}
+
+	return nil
+}
+
+type TOTPSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func (app *application) SetupTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	encrypted, err := app.secretBox.Seal([]byte(secret))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	err = app.store.TOTP.UpsertPending(r.Context(), &store.TOTP{
+		UserID: user.ID,
+		Secret: encrypted,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("two-factor authentication is already enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := TOTPSetup{
+		Secret: secret,
+		URI:    auth.TOTPURI(app.config.Auth.MFA.Issuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type TOTPCodePayload struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+func (app *application) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	totp, err := app.store.TOTP.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, fmt.Errorf("two-factor authentication setup has not been started"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if totp.IsConfirmed() {
+		app.conflictResponse(w, r, fmt.Errorf("two-factor authentication is already enabled"))
+		return
+	}
+
+	if err := app.verifyTOTPCode(ctx, totp, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TOTP.Confirm(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	codes, err := app.generateRecoveryCodes(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditMFAEnabled, user.ID, nil)
+
+	response := RecoveryCodes{
+		Message:       "Two-factor authentication enabled",
+		RecoveryCodes: codes,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type DisableTOTPPayload struct {
+	// Password is only asked of accounts with a password.
+	Password string `json:"password" validate:"max=72"`
+	Code     string `json:"code" validate:"required,numeric,len=6"`
+}
+
+// DisableTOTPHandler removes the authenticator app and the recovery codes of
+// the user after they enter a code from the app and, unless the account is
+// passwordless, their password.
+func (app *application) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var payload DisableTOTPPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if user.HasPassword() {
+		if payload.Password == "" {
+			app.badRequestResponse(w, r, errors.New("password is required"))
+			return
+		}
+
+		if err := user.Password.Compare(payload.Password); err != nil {
+			app.wrongPasswordResponse(w, r, user, err)
+			return
+		}
+	}
+
+	totp, err := app.store.TOTP.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errMFANotEnabled)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !totp.IsConfirmed() {
+		app.badRequestResponse(w, r, errMFANotEnabled)
+		return
+	}
+
+	if err := app.verifyTOTPCode(ctx, totp, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TOTP.Delete(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.RecoveryCodes.DeleteForUser(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditMFADisabled, user.ID, nil)
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Two-factor authentication disabled"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type VerifyMFAPayload struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code,omitempty,max=32"`
+}
+
+// VerifyMFAHandler exchanges an MFA challenge token and a TOTP or recovery
+// code for the response of LoginHandler. Every code tried uses up one of the
+// attempts of the challenge, and wrong codes count as failed logins towards
+// throttling and lockout.
+func (app *application) VerifyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyMFAPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateTokenFor(payload.MFAToken, app.tokenAudience(tokenTypeMFAChallenge))
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+
+	if typ, _ := claims["typ"].(string); typ != tokenTypeMFAChallenge {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token type"))
+		return
+	}
+
+	userID, err := claims.GetSubject()
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	challengeID, _ := claims["jti"].(string)
+	if challengeID == "" {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token: missing challenge"))
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	rtvFloat, _ := claims["rtv"].(float64)
+	if int(rtvFloat) != user.RefreshTokenVersion {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token: version mismatch"))
+		return
+	}
+
+	wait, err := app.loginDelay(ctx, r, user.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, retryAfter(wait))
+		return
+	}
+
+	lockout, err := app.store.AccountLockouts.GetActive(ctx, user.ID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if lockout != nil {
+		app.rateLimitExceededResponse(w, r, retryAfter(time.Until(lockout.LockedUntil)))
+		return
+	}
+
+	totp, err := app.store.TOTP.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errMFANotEnabled)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !totp.IsConfirmed() {
+		app.unauthorizedErrorResponse(w, r, errMFANotEnabled)
+		return
+	}
+
+	err = app.store.MFAChallenges.Attempt(ctx, challengeID, user.ID, app.config.Auth.MFA.MaxAttempts)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errMFAChallengeUsed)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if payload.Code != "" {
+		err = app.verifyTOTPCode(ctx, totp, payload.Code)
+	} else {
+		err = app.useRecoveryCode(ctx, user, payload.RecoveryCode)
+	}
+
+	method := loginMethodTOTP
+	if payload.Code == "" {
+		method = loginMethodRecoveryCode
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode), errors.Is(err, errInvalidRecoveryCode):
+			if err := app.loginFailed(ctx, r, method, user.Email, user); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.MFAChallenges.Consume(ctx, challengeID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedErrorResponse(w, r, errMFAChallengeUsed)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.AuthAttempts.Clear(ctx, loginAccountKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	authInfo, err := app.issueAuthTokens(r, user, method)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountDeleted):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := UserWithAuth{
+		Auth: *authInfo,
+		User: user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type RecoveryCodes struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type RecoveryCodesStatus struct {
+	Remaining int `json:"remaining"`
+}
+
+func (app *application) GetRecoveryCodesStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	enabled, err := app.hasMFAEnabled(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !enabled {
+		app.badRequestResponse(w, r, errMFANotEnabled)
+		return
+	}
+
+	remaining, err := app.store.RecoveryCodes.CountRemaining(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodesStatus{Remaining: remaining}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// RegenerateRecoveryCodesHandler requires a current TOTP code so that a stolen
+// access token alone is not enough to mint new recovery codes.
+func (app *application) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	totp, err := app.store.TOTP.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errMFANotEnabled)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !totp.IsConfirmed() {
+		app.badRequestResponse(w, r, errMFANotEnabled)
+		return
+	}
+
+	if err := app.verifyTOTPCode(ctx, totp, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	codes, err := app.generateRecoveryCodes(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditRecoveryCodesRegenerated, user.ID, nil)
+
+	response := RecoveryCodes{
+		Message:       "Recovery codes regenerated",
+		RecoveryCodes: codes,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// pruneMFAChallenges periodically deletes the expired MFA challenges.
+func (app *application) pruneMFAChallenges(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.store.MFAChallenges.Prune(ctx, time.Now()); err != nil {
+				app.logger.Errorw("failed to prune mfa challenges", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeTOTPStore struct {
+	*store.TOTPStore
+
+	// totp is the authenticator app of the user, if any.
+	totp *store.TOTP
+}
+
+func (s *fakeTOTPStore) GetByUserID(context.Context, string) (*store.TOTP, error) {
+	if s.totp == nil {
+		return nil, store.ErrNotFound
+	}
+	return s.totp, nil
+}
+
+func (s *fakeTOTPStore) UseStep(_ context.Context, _ string, step int64) error {
+	if step <= s.totp.LastUsedStep {
+		return store.ErrTOTPCodeReused
+	}
+
+	s.totp.LastUsedStep = step
+
+	return nil
+}
+
+func (s *fakeTOTPStore) Delete(context.Context, string) error {
+	s.totp = nil
+	return nil
+}
+
+type fakeRecoveryCodeStore struct {
+	*store.RecoveryCodeStore
+}
+
+func (s *fakeRecoveryCodeStore) DeleteForUser(context.Context, string) error {
+	return nil
+}
+
+func TestDisableTOTP(t *testing.T) {
+	secretBox, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := secretBox.Seal([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		body     func(code string) string
+		wantCode int
+	}{
+		{
+			name:     "with the password and a code",
+			password: "correct horse",
+			body:     func(code string) string { return `{"password":"correct horse","code":"` + code + `"}` },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "without the password",
+			password: "correct horse",
+			body:     func(code string) string { return `{"code":"` + code + `"}` },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "with a wrong password",
+			password: "correct horse",
+			body:     func(code string) string { return `{"password":"wrong horse","code":"` + code + `"}` },
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "passwordless with a code",
+			body:     func(code string) string { return `{"code":"` + code + `"}` },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "passwordless with a wrong code",
+			body:     func(code string) string { return `{"code":"000000"}` },
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &store.User{ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"}
+			if tt.password != "" {
+				if err := user.Password.Set(tt.password); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			totps := &fakeTOTPStore{totp: &store.TOTP{
+				UserID:      user.ID,
+				Secret:      sealed,
+				ConfirmedAt: sql.NullString{String: "2026-01-01T00:00:00Z", Valid: true},
+			}}
+
+			app := newTestApplication(t, store.Storage{TOTP: totps, RecoveryCodes: &fakeRecoveryCodeStore{}})
+			app.secretBox = secretBox
+
+			code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodDelete, "/", strings.NewReader(tt.body(code)))
+			r = r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+
+			w := httptest.NewRecorder()
+			app.DisableTOTPHandler(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			if disabled := totps.totp == nil; disabled != (tt.wantCode == http.StatusOK) {
+				t.Errorf("disabled %v", disabled)
+			}
+		})
+	}
+}
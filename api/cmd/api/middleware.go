@@ -25,6 +25,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		}
 
 		token := parts[1]
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
 		jwtToken, err := app.authenticator.ValidateToken(token)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
@@ -33,6 +38,11 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 
 		claims, _ := jwtToken.Claims.(jwt.MapClaims)
 
+		if typ, _ := claims["typ"].(string); typ != tokenTypeAccess {
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token type"))
+			return
+		}
+
 		userID, err := claims.GetSubject()
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
@@ -57,19 +67,142 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		sid, _ := claims["sid"].(string)
+		if sid != "" {
+			session, err := app.store.Sessions.GetByID(r.Context(), sid)
+			if err != nil {
+				app.unauthorizedErrorResponse(w, r, err)
+				return
+			}
+
+			if session.RevokedAt.Valid || session.UserID != user.ID {
+				app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid token: session revoked"))
+				return
+			}
+		}
+
+		access, err := app.store.Roles.GetAccess(r.Context(), user.ID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), userCtxKey, user)
 		ctx = context.WithValue(ctx, rtvCtxKey, rtv)
+		ctx = context.WithValue(ctx, sidCtxKey, sid)
+		ctx = context.WithValue(ctx, accessCtxKey, access)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func (app *application) getUser(ctx context.Context, userID string) (*store.User, error) {
+// authenticateAPIKey authenticates a request made with an API key. Keys are
+// only accepted on routes that declared the scopes they need with
+// APIKeyScopesMiddleware.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	ctx := r.Context()
+
+	scope, _ := ctx.Value(scopeCtxKey).(string)
+	if scope == "" {
+		app.scopeRequiredResponse(w, r, "")
+		return
+	}
+
+	key, err := app.store.APIKeys.GetByKey(ctx, token)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, fmt.Errorf("invalid api key"))
+		return
+	}
+
+	if !key.HasScope(scope) {
+		app.scopeRequiredResponse(w, r, scope)
+		return
+	}
+
+	user, err := app.getUser(ctx, key.UserID)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	access, err := app.store.Roles.GetAccess(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
 
+	if err := app.store.APIKeys.RecordUse(ctx, key.ID, clientIP(r)); err != nil {
+		app.logger.Errorw("failed to record api key use", "api_key_id", key.ID, "error", err)
+	}
+
+	ctx = context.WithValue(ctx, userCtxKey, user)
+	ctx = context.WithValue(ctx, apiKeyCtxKey, key)
+	ctx = context.WithValue(ctx, accessCtxKey, access)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// APIKeyScopesMiddleware lets API keys call the routes it wraps: reads need
+// the read scope and any other method the write scope. It must run before
+// AuthTokenMiddleware. Routes without it only accept access tokens.
+func (app *application) APIKeyScopesMiddleware(read, write string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope := write
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = read
+			}
+
+			ctx := context.WithValue(r.Context(), scopeCtxKey, scope)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequirePermission rejects users whose roles do not grant the permission. It
+// must run after AuthTokenMiddleware.
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasPermission(r, permission) {
+				app.permissionRequiredResponse(w, r, permission)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmailMiddleware rejects users that have not verified their
+// email address yet when the "restrict" policy is in effect. It guards what an
+// unverified account cannot use, such as API keys, profile changes, exports
+// and the admin API, and must run after AuthTokenMiddleware.
+func (app *application) RequireVerifiedEmailMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+
+		if !user.IsEmailVerified() && app.config.Auth.EmailVerification.Policy != emailVerificationPolicyAllow {
+			app.emailNotVerifiedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) getUser(ctx context.Context, userID string) (*store.User, error) {
 	user, err := app.store.Users.GetByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	if user.IsDeleted {
+		return nil, errAccountDeleted
+	}
+
+	app.presentUser(user)
+
 	return user, nil
 }
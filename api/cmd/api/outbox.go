@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/outbox"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// registerOutboxHandlers wires the side effects of the domain events. The
+// handler names are recorded once a handler is done with an event, so they
+// must not be renamed.
+func (app *application) registerOutboxHandlers(relay *outbox.Relay) {
+	relay.Handle(store.EventUserRegistered, "verification_email", app.sendRegistrationEmail)
+	relay.Handle(store.EventUserDeleted, "account_deleted_email", app.sendAccountDeletedEmail)
+	relay.Handle(store.EventUserPurged, "avatar", app.deletePurgedAvatar)
+	relay.Handle(store.EventPasswordResetRequested, "password_reset_email", app.sendRequestedPasswordReset)
+	relay.Handle(store.EventVerificationEmailRequested, "verification_email", app.sendRequestedVerificationEmail)
+	relay.Handle(store.EventEmailOTPRequested, "email_otp_email", app.sendRequestedEmailOTP)
+	relay.Handle(store.EventMagicLinkRequested, "magic_link_email", app.sendRequestedMagicLink)
+
+	for _, event := range webhookEvents {
+		relay.Handle(event, "webhook", app.publishUserWebhook)
+	}
+}
+
+// wakeOutboxRelay has the relay look for new events rather than wait for
+// its next poll.
+func (app *application) wakeOutboxRelay() {
+	select {
+	case app.outboxQueue <- struct{}{}:
+	default:
+	}
+}
+
+// relayOutbox hands the domain events to their handlers, polling for them
+// and waking up early when one is written. It keeps going while batches come
+// back full.
+func (app *application) relayOutbox(ctx context.Context, relay *outbox.Relay) {
+	poll := time.NewTicker(app.config.Outbox.PollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-app.outboxQueue:
+		}
+
+		for {
+			report, err := relay.Process(ctx)
+			if err != nil {
+				app.logger.Errorw("failed to relay outbox events", "error", err)
+			}
+
+			if report == nil {
+				break
+			}
+
+			for _, failure := range report.Failures {
+				log := app.logger.Warnw
+				if failure.GaveUp {
+					log = app.logger.Errorw
+				}
+
+				log("outbox handler failed",
+					"event_id", failure.Event.ID,
+					"event", failure.Event.Event,
+					"handler", failure.Handler,
+					"attempts", failure.Event.Attempts,
+					"gave_up", failure.GaveUp,
+					"error", failure.Err,
+				)
+			}
+
+			if report.Claimed < app.config.Outbox.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// pruneOutbox periodically deletes the handled events that are past the
+// retention.
+func (app *application) pruneOutbox(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-app.config.Outbox.Retention)
+			if err := app.store.Outbox.Prune(ctx, before); err != nil {
+				app.logger.Errorw("failed to prune outbox events", "error", err)
+			}
+		}
+	}
+}
+
+// sendRegistrationEmail sends the first verification email of a new user,
+// unless the address was verified in the meantime or the user is gone.
+func (app *application) sendRegistrationEmail(ctx context.Context, event *store.OutboxEvent) error {
+	user, err := app.store.Users.GetByID(ctx, event.AggregateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if user.IsDeleted || user.IsEmailVerified() {
+		return nil
+	}
+
+	return app.sendVerificationEmail(ctx, user)
+}
+
+// requestEmail queues an email request for the relay. Handlers answer the
+// same way whatever becomes of it, so that they do not tell which addresses
+// are registered.
+func (app *application) requestEmail(ctx context.Context, event string, request *store.EmailRequest) error {
+	if err := app.store.Outbox.RequestEmail(ctx, event, request); err != nil {
+		return err
+	}
+
+	app.wakeOutboxRelay()
+
+	return nil
+}
+
+// requestedUser returns the account of the address of an email request, or
+// nil when there is none.
+func (app *application) requestedUser(ctx context.Context, event *store.OutboxEvent) (*store.User, error) {
+	_, user, err := app.readEmailRequest(ctx, event)
+	return user, err
+}
+
+// readEmailRequest decodes an email request and looks up the account of its
+// address, which is nil when there is none.
+func (app *application) readEmailRequest(ctx context.Context, event *store.OutboxEvent) (*store.EmailRequest, *store.User, error) {
+	var request store.EmailRequest
+	if err := json.Unmarshal(event.Payload, &request); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, request.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return &request, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &request, user, nil
+}
+
+// sendRequestedPasswordReset emails a reset link to the owner of the address
+// of the request, if any.
+func (app *application) sendRequestedPasswordReset(ctx context.Context, event *store.OutboxEvent) error {
+	user, err := app.requestedUser(ctx, event)
+	if err != nil || user == nil {
+		return err
+	}
+
+	return app.sendPasswordResetEmail(ctx, user, nil)
+}
+
+// sendRequestedVerificationEmail sends a new verification email to the owner
+// of the address of the request, unless it is verified.
+func (app *application) sendRequestedVerificationEmail(ctx context.Context, event *store.OutboxEvent) error {
+	user, err := app.requestedUser(ctx, event)
+	if err != nil || user == nil || user.IsEmailVerified() {
+		return err
+	}
+
+	return app.sendVerificationEmail(ctx, user)
+}
+
+// sendRequestedEmailOTP emails a sign-in code to the owner of the address of
+// the request, if any.
+func (app *application) sendRequestedEmailOTP(ctx context.Context, event *store.OutboxEvent) error {
+	user, err := app.requestedUser(ctx, event)
+	if err != nil || user == nil {
+		return err
+	}
+
+	return app.sendEmailOTP(ctx, user, store.EmailOTPLogin, mailer.EmailOTPTemplate)
+}
+
+// sendRequestedMagicLink emails a sign-in link to the owner of the address of
+// the request or, when there is none and registration is open, a sign-up
+// link.
+func (app *application) sendRequestedMagicLink(ctx context.Context, event *store.OutboxEvent) error {
+	request, user, err := app.readEmailRequest(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	if user == nil && !app.config.Auth.RegistrationOpen {
+		return nil
+	}
+
+	return app.sendMagicLink(ctx, request.Email, user, request.DeviceHash)
+}
+
+// sendAccountDeletedEmail tells the owner of a deleted account until when
+// they can restore it.
+func (app *application) sendAccountDeletedEmail(ctx context.Context, event *store.OutboxEvent) error {
+	var user store.UserEvent
+	if err := json.Unmarshal(event.Payload, &user); err != nil {
+		return err
+	}
+
+	restoreBefore := event.CreatedAt.Add(app.config.Auth.AccountDeletion.GracePeriod)
+
+	vars := struct {
+		Username      string
+		RestoreBefore string
+	}{
+		Username:      user.FirstName,
+		RestoreBefore: restoreBefore.UTC().Format("January 2, 2006 15:04 MST"),
+	}
+
+	return app.mailer.Send(mailer.AccountDeletedTemplate, user.FirstName, user.Email, vars)
+}
+
+// deletePurgedAvatar removes the avatar blobs of a purged account. Unlike
+// deleteAvatar it fails, and is retried, rather than leave a blob behind;
+// deleting a blob that is already gone is fine.
+func (app *application) deletePurgedAvatar(ctx context.Context, event *store.OutboxEvent) error {
+	var user store.UserEvent
+	if err := json.Unmarshal(event.Payload, &user); err != nil {
+		return err
+	}
+
+	if user.AvatarKey == "" {
+		return nil
+	}
+
+	for _, file := range []string{avatarFullFile, avatarThumbnailFile} {
+		if err := app.blobs.Delete(ctx, avatarBlobKey(user.AvatarKey, file)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
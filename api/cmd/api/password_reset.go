@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// sendPasswordResetEmail creates a single-use reset token for the user and
+// emails it. Previously issued tokens stay valid until they expire. action is
+// the admin action that sent it, recorded with the token, or nil when the user
+// asked for it.
+func (app *application) sendPasswordResetEmail(ctx context.Context, user *store.User, action *store.AdminAction) error {
+	token, err := gonanoid.Nanoid(32)
+	if err != nil {
+		return err
+	}
+
+	exp := app.config.Auth.PasswordReset.Exp
+
+	reset := &store.PasswordReset{
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(exp),
+	}
+
+	if action != nil {
+		err = app.store.AdminUsers.CreatePasswordReset(ctx, reset, action)
+	} else {
+		err = app.store.PasswordResets.Create(ctx, reset)
+	}
+	if err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username  string
+		ResetURL  string
+		ExpiresIn string
+	}{
+		Username:  user.FirstName,
+		ResetURL:  fmt.Sprintf("%s/reset-password?token=%s", app.config.FrontendURL, url.QueryEscape(token)),
+		ExpiresIn: exp.String(),
+	}
+
+	return app.mailer.Send(mailer.ResetPasswordTemplate, user.FirstName, user.Email, vars)
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ForgotPasswordHandler always answers 200 so that it cannot be used to find
+// out which emails are registered. The email is sent by the outbox relay, so
+// the answer takes as long either way.
+func (app *application) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttleEmail(w, r, "password_forgot", payload.Email) {
+		return
+	}
+
+	if err := app.requestEmail(r.Context(), store.EventPasswordResetRequested, &store.EmailRequest{Email: payload.Email}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "If the account exists, a password reset email has been sent"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"password"`
+}
+
+func (app *application) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user := &store.User{}
+	if err := user.Password.Set(payload.Password); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.PasswordResets.Reset(ctx, payload.Token, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.jsonMessageResponse(w, http.StatusBadRequest, "Invalid or expired reset token")
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// Proving access to the mailbox is enough to lift a lockout.
+	if err := app.store.AccountLockouts.Delete(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.AuthAttempts.Clear(ctx, loginAccountKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditPasswordReset, user.ID, nil)
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Password successfully reset"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
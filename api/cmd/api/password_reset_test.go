@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeOutboxStore struct {
+	*store.OutboxStore
+
+	mu     sync.Mutex
+	events []*store.OutboxEvent
+}
+
+func (s *fakeOutboxStore) RequestEmail(_ context.Context, event string, request *store.EmailRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// OutboxStore.RequestEmail writes a hash of the device token instead.
+	if request.DeviceToken != "" {
+		request.DeviceHash = "hash:" + request.DeviceToken
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	s.events = append(s.events, &store.OutboxEvent{Event: event, AggregateID: request.Email, Payload: payload})
+
+	return nil
+}
+
+type fakePasswordResetStore struct {
+	*store.PasswordResetStore
+
+	created []*store.PasswordReset
+}
+
+func (s *fakePasswordResetStore) Create(_ context.Context, reset *store.PasswordReset) error {
+	s.created = append(s.created, reset)
+	return nil
+}
+
+// postEmails posts each email to a handler of email requests, and returns the
+// requests written to the outbox. The answers must not tell the emails apart,
+// and nothing must be sent before the relay runs.
+func postEmails(t *testing.T, app *application, handler http.HandlerFunc, emails ...string) []*store.OutboxEvent {
+	t.Helper()
+
+	var bodies []string
+	for _, email := range emails {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"`+email+`"}`)))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", email, w.Code, w.Body)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+
+	for _, body := range bodies[1:] {
+		if body != bodies[0] {
+			t.Errorf("the answers differ:\n%s\n%s", bodies[0], body)
+		}
+	}
+
+	if n := len(app.mailer.(*mailer.InMemoryMailer).Outbox()); n != 0 {
+		t.Fatalf("%d emails were sent while answering", n)
+	}
+
+	outbox := app.store.Outbox.(*fakeOutboxStore)
+	if len(outbox.events) != len(emails) {
+		t.Fatalf("got %d requests, want %d", len(outbox.events), len(emails))
+	}
+
+	return outbox.events
+}
+
+func TestForgotPassword(t *testing.T) {
+	users := &fakeUserStore{users: map[string]*store.User{
+		"usr_1": {ID: "usr_1", FirstName: "Ada", Email: "ada@example.com"},
+	}}
+	outbox := &fakeOutboxStore{}
+	resets := &fakePasswordResetStore{}
+
+	app := newTestApplication(t, store.Storage{Users: users, Outbox: outbox, PasswordResets: resets})
+	mail := app.mailer.(*mailer.InMemoryMailer)
+
+	events := postEmails(t, app, app.ForgotPasswordHandler, "ada@example.com", "nobody@example.com")
+
+	for _, event := range events {
+		if event.Event != store.EventPasswordResetRequested {
+			t.Errorf("got event %q", event.Event)
+		}
+
+		if err := app.sendRequestedPasswordReset(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := mail.Outbox()
+	if len(sent) != 1 || sent[0].To != "ada@example.com" {
+		t.Fatalf("got %d emails, want 1 to the account", len(sent))
+	}
+
+	if len(resets.created) != 1 || resets.created[0].UserID != "usr_1" {
+		t.Errorf("got resets %+v", resets.created)
+	}
+}
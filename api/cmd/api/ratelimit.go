@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/ratelimit"
+)
+
+// RateLimitMiddleware applies the named policy of the configuration to the
+// client IP. Routes whose policy is not configured are not limited. When the
+// limiter backend fails the request is let through, so that an outage of the
+// limiter does not take the API down with it.
+func (app *application) RateLimitMiddleware(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		cfg, ok := app.config.RateLimit.Policies[policy]
+		if !ok {
+			app.logger.Warnw("rate limit policy is not configured, routes are not limited", "policy", policy)
+			return next
+		}
+
+		limit := ratelimit.Limit{Rate: cfg.Rate, Period: cfg.Period, Burst: cfg.Burst}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := app.rateLimiter.Allow(r.Context(), policy+":"+clientIP(r), limit)
+			if err != nil {
+				app.logger.Errorw("rate limiter failed", "policy", policy, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(cfg.Rate))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+			if !result.Allowed {
+				app.rateLimitExceededResponse(w, r, retryAfter(result.RetryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// pruneRateLimitBuckets periodically deletes the buckets of the Postgres
+// limiter that are full again.
+func (app *application) pruneRateLimitBuckets(ctx context.Context, limiter *ratelimit.PostgresLimiter) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := limiter.Prune(ctx); err != nil {
+				app.logger.Errorw("failed to prune rate limit buckets", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{trustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "ignores the headers of a direct client",
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "ignores True-Client-IP",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string][]string{"True-Client-Ip": {"198.51.100.1"}},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "reads X-Forwarded-For from a trusted proxy",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "skips the addresses a client prepended",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "skips trusted proxies along the chain",
+			remoteAddr: "192.0.2.1:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7", "10.9.9.9"}},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "keeps the proxy when the header is garbage",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string][]string{"X-Forwarded-For": {"not an address"}},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "falls back to X-Real-IP",
+			remoteAddr: "10.1.2.3:5000",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, values := range tt.headers {
+				r.Header[key] = values
+			}
+
+			var got string
+			app.RealIPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+)
+
+const permRolesRead = "roles:read"
+
+func (app *application) ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
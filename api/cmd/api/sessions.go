@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type SessionResponse struct {
+	*store.Session
+	Current bool `json:"current"`
+}
+
+func (app *application) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	currentID := getSessionIDFromContext(r)
+
+	sessions, err := app.store.Sessions.ListActive(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.ID == currentID,
+		})
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	sessionID := chi.URLParam(r, "sessionID")
+
+	err := app.store.Sessions.Revoke(r.Context(), sessionID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, auditLogout, user.ID, map[string]string{"session_id": sessionID})
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Session revoked"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// RevokeOtherSessionsHandler signs the user out of every device but the one
+// making the request.
+func (app *application) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	currentID := getSessionIDFromContext(r)
+
+	if currentID == "" {
+		app.badRequestResponse(w, r, errors.New("access token is not bound to a session, sign in again"))
+		return
+	}
+
+	if err := app.store.Sessions.RevokeOthers(r.Context(), user.ID, currentID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, auditLogoutAll, user.ID, map[string]string{"kept_session_id": currentID})
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Other sessions revoked"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// truncate caps client supplied strings before they are stored. Invalid
+// UTF-8, including a rune cut in half, is dropped as Postgres rejects it.
+func truncate(s string, max int) string {
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.ToValidUTF8(s, "")
+}
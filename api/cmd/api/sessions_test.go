@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type fakeRevokedSessionStore struct {
+	*fakeSessionStore
+
+	revoked []string
+}
+
+func (s *fakeRevokedSessionStore) Revoke(_ context.Context, id, _ string) error {
+	s.revoked = append(s.revoked, id)
+	return nil
+}
+
+func (s *fakeRevokedSessionStore) RevokeOthers(_ context.Context, _, keepID string) error {
+	s.revoked = append(s.revoked, "all but "+keepID)
+	return nil
+}
+
+func TestRevokeSessionsAreAudited(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantEvent string
+	}{
+		{name: "one session", method: http.MethodDelete, path: "/sessions/sess_2", wantEvent: auditLogout},
+		{name: "other sessions", method: http.MethodPost, path: "/sessions/revoke-others", wantEvent: auditLogoutAll},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := &fakeRevokedSessionStore{fakeSessionStore: &fakeSessionStore{}}
+			audit := &fakeAuditEventStore{}
+			app := newTestApplication(t, store.Storage{Sessions: sessions, AuditEvents: audit})
+
+			router := chi.NewRouter()
+			router.Delete("/sessions/{sessionID}", app.RevokeSessionHandler)
+			router.Post("/sessions/revoke-others", app.RevokeOtherSessionsHandler)
+
+			user := &store.User{ID: "usr_1"}
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			ctx := context.WithValue(r.Context(), userCtxKey, user)
+			ctx = context.WithValue(ctx, sidCtxKey, "sess_1")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r.WithContext(ctx))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", w.Code, w.Body)
+			}
+
+			if len(sessions.revoked) != 1 {
+				t.Errorf("got revoked %v", sessions.revoked)
+			}
+
+			if !slices.Equal(audit.events, []string{tt.wantEvent}) {
+				t.Errorf("got audit events %v, want %s", audit.events, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/mailer"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+func loginIPKey(r *http.Request) string {
+	return "login:ip:" + clientIP(r)
+}
+
+func loginAccountKey(email string) string {
+	return "login:account:" + normalizeEmail(email)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// retryAfter formats a wait as the whole number of seconds of a Retry-After
+// header, rounded up so clients never retry too early.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+// limitedFor returns how long the key stays at or above max attempts within
+// the sliding window. Zero means another attempt is allowed.
+func (app *application) limitedFor(ctx context.Context, key string, max int) (time.Duration, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+
+	window := app.config.Auth.Throttle.Window
+
+	attempts, err := app.store.AuthAttempts.Window(ctx, key, time.Now().Add(-window), max)
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts.Count < max {
+		return 0, nil
+	}
+
+	return time.Until(attempts.LimitingAttempt.Add(window)), nil
+}
+
+// throttle counts the request against the per-IP limit of an endpoint. It
+// answers 429 and returns false when the limit is reached.
+func (app *application) throttle(w http.ResponseWriter, r *http.Request, endpoint string, max int) bool {
+	return app.throttleKey(w, r, endpoint+":ip:"+clientIP(r), max)
+}
+
+// throttleKey counts the request against the limit of any key, such as an
+// account or a user. It answers 429 and returns false when the limit is
+// reached.
+func (app *application) throttleKey(w http.ResponseWriter, r *http.Request, key string, max int) bool {
+	ctx := r.Context()
+
+	wait, err := app.limitedFor(ctx, key, max)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, retryAfter(wait))
+		return false
+	}
+
+	if max > 0 {
+		if err := app.store.AuthAttempts.Record(ctx, key); err != nil {
+			app.internalServerError(w, r, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// throttleEmail counts a request that emails a link or a code to the
+// address against the limits of the endpoint per IP and per address, so that
+// mailboxes cannot be flooded from one client or from many. Unknown addresses
+// are counted alike so that the limits do not reveal which accounts exist.
+func (app *application) throttleEmail(w http.ResponseWriter, r *http.Request, endpoint, email string) bool {
+	cfg := app.config.Auth.Throttle
+
+	if !app.throttle(w, r, endpoint, cfg.EmailIPMax) {
+		return false
+	}
+
+	return app.throttleKey(w, r, endpoint+":account:"+normalizeEmail(email), cfg.EmailAccountMax)
+}
+
+// loginDelay returns how long the client has to wait before trying to log in
+// again, based on the failed logins from its IP and on the account.
+func (app *application) loginDelay(ctx context.Context, r *http.Request, email string) (time.Duration, error) {
+	cfg := app.config.Auth.Throttle
+
+	wait, err := app.limitedFor(ctx, loginIPKey(r), cfg.LoginIPMax)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	// Unknown emails are limited like accounts so that the responses do not
+	// reveal which accounts exist.
+	wait, err = app.limitedFor(ctx, loginAccountKey(email), cfg.LockoutThreshold)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	if cfg.DelayAfter <= 0 {
+		return 0, nil
+	}
+
+	attempts, err := app.store.AuthAttempts.Window(ctx, loginAccountKey(email), time.Now().Add(-cfg.Window), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts.Count < cfg.DelayAfter {
+		return 0, nil
+	}
+
+	delay := cfg.DelayMax
+	if shift := attempts.Count - cfg.DelayAfter; shift < 32 {
+		delay = min(cfg.DelayBase<<shift, cfg.DelayMax)
+	}
+
+	return time.Until(attempts.Latest.Add(delay)), nil
+}
+
+// loginFailed records a failed login. When the account reaches the lockout
+// threshold it is locked and its owner is sent an unlock link.
+//
+// The audit event of an unknown account records the email tried. That of an
+// account only records its ID, so that nothing personal is left behind once
+// the account is purged.
+func (app *application) loginFailed(ctx context.Context, r *http.Request, method, email string, user *store.User) error {
+	cfg := app.config.Auth.Throttle
+	accountKey := loginAccountKey(email)
+
+	metadata := map[string]string{"method": method}
+
+	var userID string
+	if user != nil {
+		userID = user.ID
+	} else {
+		metadata["email"] = email
+	}
+
+	app.audit(r, auditLoginFailed, userID, metadata)
+
+	if err := app.store.AuthAttempts.Record(ctx, loginIPKey(r)); err != nil {
+		return err
+	}
+
+	if err := app.store.AuthAttempts.Record(ctx, accountKey); err != nil {
+		return err
+	}
+
+	if user == nil || cfg.LockoutThreshold <= 0 {
+		return nil
+	}
+
+	attempts, err := app.store.AuthAttempts.Window(ctx, accountKey, time.Now().Add(-cfg.Window), 0)
+	if err != nil {
+		return err
+	}
+
+	if attempts.Count < cfg.LockoutThreshold {
+		return nil
+	}
+
+	app.logger.Warnw("security event: account locked",
+		"event", "account_locked",
+		"user_id", user.ID,
+		"ip", clientIP(r),
+		"failed_attempts", attempts.Count,
+	)
+
+	app.audit(r, auditAccountLocked, user.ID, map[string]string{"failed_attempts": strconv.Itoa(attempts.Count)})
+
+	if err := app.lockAccount(ctx, user); err != nil {
+		return err
+	}
+
+	// The lockout takes over, the attempts start over once it ends.
+	return app.store.AuthAttempts.Clear(ctx, accountKey)
+}
+
+// wrongPasswordResponse answers a wrong password re-entered by the signed in
+// user. It counts as a failed login, so that a stolen session cannot be used
+// to guess the password past the lockout.
+func (app *application) wrongPasswordResponse(w http.ResponseWriter, r *http.Request, user *store.User, err error) {
+	if err := app.loginFailed(r.Context(), r, loginMethodPassword, user.Email, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.unauthorizedErrorResponse(w, r, err)
+}
+
+// passkeyLoginFailed records a failed passkey login. Unlike loginFailed it
+// only counts towards the limit of the IP: an assertion cannot be guessed,
+// and the account it claims to be for is chosen by the client.
+func (app *application) passkeyLoginFailed(ctx context.Context, r *http.Request, user *store.User) error {
+	var userID string
+	if user != nil {
+		userID = user.ID
+	}
+
+	app.audit(r, auditLoginFailed, userID, map[string]string{"method": loginMethodPasskey})
+
+	return app.store.AuthAttempts.Record(ctx, loginIPKey(r))
+}
+
+func (app *application) lockAccount(ctx context.Context, user *store.User) error {
+	cfg := app.config.Auth.Throttle
+
+	token, err := gonanoid.Nanoid(32)
+	if err != nil {
+		return err
+	}
+
+	lockout := &store.AccountLockout{
+		UserID:          user.ID,
+		LockedUntil:     time.Now().Add(cfg.LockoutDuration),
+		UnlockToken:     token,
+		UnlockExpiresAt: time.Now().Add(cfg.UnlockExp),
+	}
+
+	if err := app.store.AccountLockouts.Lock(ctx, lockout); err != nil {
+		return err
+	}
+
+	vars := struct {
+		Username  string
+		UnlockURL string
+		LockedFor string
+	}{
+		Username:  user.FirstName,
+		UnlockURL: fmt.Sprintf("%s/unlock-account?token=%s", app.config.FrontendURL, url.QueryEscape(token)),
+		LockedFor: cfg.LockoutDuration.String(),
+	}
+
+	if err := app.mailer.Send(mailer.AccountLockedTemplate, user.FirstName, user.Email, vars); err != nil {
+		app.logger.Errorw("failed to send account locked email", "user_id", user.ID, "error", err.Error())
+	}
+
+	return nil
+}
+
+type UnlockAccountPayload struct {
+	Token string `json:"token" validate:"required,max=255"`
+}
+
+func (app *application) UnlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UnlockAccountPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttle(w, r, "unlock", app.config.Auth.Throttle.UnlockIPMax) {
+		return
+	}
+
+	ctx := r.Context()
+
+	lockout, err := app.store.AccountLockouts.Unlock(ctx, payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired unlock token"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, lockout.UserID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.AuthAttempts.Clear(ctx, loginAccountKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Account unlocked"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// pruneAuthAttempts periodically deletes attempts that have left the
+// throttling window.
+func (app *application) pruneAuthAttempts(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-app.config.Auth.Throttle.Window)
+			if err := app.store.AuthAttempts.Prune(ctx, before); err != nil {
+				app.logger.Errorw("failed to prune auth attempts", "error", err)
+			}
+		}
+	}
+}
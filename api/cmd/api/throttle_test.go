@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+func TestThrottleEmail(t *testing.T) {
+	send := func(app *application, ip, email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = ip + ":5000"
+
+		w := httptest.NewRecorder()
+		if app.throttleEmail(w, r, "password_forgot", email) {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		return w
+	}
+
+	t.Run("limits an address across IPs", func(t *testing.T) {
+		app := newTestApplication(t, store.Storage{})
+		app.config.Auth.Throttle.EmailIPMax = 10
+		app.config.Auth.Throttle.EmailAccountMax = 2
+
+		for i, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+			if w := send(app, ip, "ada@example.com"); w.Code != http.StatusOK {
+				t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+			}
+		}
+
+		w := send(app, "203.0.113.3", " Ada@Example.com")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Retry-After is not set")
+		}
+
+		if w := send(app, "203.0.113.3", "grace@example.com"); w.Code != http.StatusOK {
+			t.Errorf("other address: got status %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("limits an IP across addresses", func(t *testing.T) {
+		app := newTestApplication(t, store.Storage{})
+		app.config.Auth.Throttle.EmailIPMax = 2
+		app.config.Auth.Throttle.EmailAccountMax = 10
+
+		for i, email := range []string{"ada@example.com", "grace@example.com"} {
+			if w := send(app, "203.0.113.1", email); w.Code != http.StatusOK {
+				t.Fatalf("request %d: got status %d, want %d", i, w.Code, http.StatusOK)
+			}
+		}
+
+		if w := send(app, "203.0.113.1", "alan@example.com"); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+
+		if w := send(app, "203.0.113.2", "alan@example.com"); w.Code != http.StatusOK {
+			t.Errorf("other IP: got status %d, want %d", w.Code, http.StatusOK)
+		}
+	})
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/menaguilherme/trigon/internal/store"
 )
@@ -11,6 +14,12 @@ type contextKey string
 const (
 	userCtxKey contextKey = "user"
 	rtvCtxKey  contextKey = "refreshTokenVersion"
+	sidCtxKey  contextKey = "sessionID"
+
+	accessCtxKey contextKey = "access"
+
+	apiKeyCtxKey contextKey = "apiKey"
+	scopeCtxKey  contextKey = "apiKeyScope"
 )
 
 func getUserFromContext(r *http.Request) *store.User {
@@ -18,7 +27,167 @@ func getUserFromContext(r *http.Request) *store.User {
 	return user
 }
 
+// getAccessFromContext returns the roles and permissions of the
+// authenticated user.
+func getAccessFromContext(r *http.Request) *store.Access {
+	access, _ := r.Context().Value(accessCtxKey).(*store.Access)
+	if access == nil {
+		return &store.Access{}
+	}
+	return access
+}
+
+func hasPermission(r *http.Request, permission string) bool {
+	return slices.Contains(getAccessFromContext(r).Permissions, permission)
+}
+
 func getRefreshTokenVersionFromContext(r *http.Request) int {
 	rtv, _ := r.Context().Value(rtvCtxKey).(int)
 	return rtv
 }
+
+// getSessionIDFromContext returns the session of the access token. It is
+// empty for tokens issued before sessions existed.
+func getSessionIDFromContext(r *http.Request) string {
+	sid, _ := r.Context().Value(sidCtxKey).(string)
+	return sid
+}
+
+type CurrentUser struct {
+	*store.User
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+func (app *application) GetCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	access := getAccessFromContext(r)
+
+	response := CurrentUser{
+		User:        getUserFromContext(r),
+		Roles:       access.Roles,
+		Permissions: access.Permissions,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// UpdateCurrentUserPayload only changes the fields that are present.
+type UpdateCurrentUserPayload struct {
+	FirstName *string `json:"first_name" validate:"omitnil,min=1,max=80"`
+	LastName  *string `json:"last_name" validate:"omitnil,min=1,max=80"`
+	Username  *string `json:"username" validate:"omitnil,min=1,max=255"`
+}
+
+func (app *application) UpdateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateCurrentUserPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if payload.FirstName != nil {
+		user.FirstName = *payload.FirstName
+	}
+
+	if payload.LastName != nil {
+		user.LastName = *payload.LastName
+	}
+
+	if payload.Username != nil {
+		user.Username = *payload.Username
+	}
+
+	if err := app.store.Users.UpdateProfile(r.Context(), user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateUsername):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword      string `json:"current_password" validate:"required,max=72"`
+	NewPassword          string `json:"new_password" validate:"password"`
+	SignOutOtherSessions bool   `json:"sign_out_other_sessions"`
+}
+
+// ChangePasswordHandler changes the password of the user, who has to enter
+// the current one. Outstanding password reset links stop working.
+func (app *application) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangePasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	sessionID := getSessionIDFromContext(r)
+	ctx := r.Context()
+
+	if !user.HasPassword() {
+		app.badRequestResponse(w, r, errors.New("account has no password, use the password reset to set one"))
+		return
+	}
+
+	if payload.SignOutOtherSessions && sessionID == "" {
+		app.badRequestResponse(w, r, errors.New("access token is not bound to a session, sign in again"))
+		return
+	}
+
+	if err := user.Password.Compare(payload.CurrentPassword); err != nil {
+		app.wrongPasswordResponse(w, r, user, errors.New("current password is incorrect"))
+		return
+	}
+
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.PasswordResets.InvalidateForUser(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.SignOutOtherSessions {
+		if err := app.store.Sessions.RevokeOthers(ctx, user.ID, sessionID); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	app.audit(r, auditPasswordChanged, user.ID, map[string]string{"signed_out_other_sessions": strconv.FormatBool(payload.SignOutOtherSessions)})
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Password changed"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
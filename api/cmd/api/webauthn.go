@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+const (
+	webauthnCeremonyRegister = "register"
+	webauthnCeremonySignup   = "signup"
+	webauthnCeremonyLogin    = "login"
+)
+
+var errInvalidWebAuthnSession = errors.New("invalid or expired webauthn session")
+
+// webauthnUser adapts a store.User and its passkeys to webauthn.User. The
+// user handle is the user ID, which is random and never shown to anyone.
+type webauthnUser struct {
+	id          string
+	name        string
+	displayName string
+	credentials []webauthn.Credential
+}
+
+func newWebAuthnUser(user *store.User, credentials []*store.WebAuthnCredential) *webauthnUser {
+	u := &webauthnUser{
+		id:          user.ID,
+		name:        user.Email,
+		displayName: user.FirstName + " " + user.LastName,
+	}
+
+	for _, credential := range credentials {
+		u.credentials = append(u.credentials, toWebAuthnCredential(credential))
+	}
+
+	return u
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return []byte(u.id) }
+func (u *webauthnUser) WebAuthnName() string                       { return u.name }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.displayName }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func (u *webauthnUser) exclusions() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, credential := range u.credentials {
+		descriptors = append(descriptors, credential.Descriptor())
+	}
+
+	return descriptors
+}
+
+func toWebAuthnCredential(c *store.WebAuthnCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+	for _, transport := range c.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    c.UserPresent,
+			UserVerified:   c.UserVerified,
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+func fromWebAuthnCredential(c *webauthn.Credential, name string) *store.WebAuthnCredential {
+	transports := make([]string, 0, len(c.Transport))
+	for _, transport := range c.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return &store.WebAuthnCredential{
+		Name:            name,
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		Transports:      transports,
+		UserPresent:     c.Flags.UserPresent,
+		UserVerified:    c.Flags.UserVerified,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+	}
+}
+
+// webauthnSessionData is what is persisted between the begin and finish
+// requests of a ceremony.
+type webauthnSessionData struct {
+	Session     webauthn.SessionData `json:"session"`
+	Name        string               `json:"name,omitempty"`
+	PendingUser *pendingPasskeyUser  `json:"pending_user,omitempty"`
+}
+
+type pendingPasskeyUser struct {
+	ID        string `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+}
+
+type WebAuthnBeginResponse struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
+
+func (app *application) saveWebAuthnSession(r *http.Request, ceremony, userID string, data *webauthnSessionData) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	session := &store.WebAuthnSession{
+		Ceremony:  ceremony,
+		UserID:    sql.NullString{String: userID, Valid: userID != ""},
+		Data:      raw,
+		ExpiresAt: time.Now().Add(app.config.Auth.WebAuthn.SessionExp),
+	}
+
+	if err := app.store.WebAuthnSessions.Create(r.Context(), session); err != nil {
+		return "", err
+	}
+
+	return session.ID, nil
+}
+
+func (app *application) consumeWebAuthnSession(r *http.Request, ceremony string) (*store.WebAuthnSession, *webauthnSessionData, error) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		return nil, nil, errInvalidWebAuthnSession
+	}
+
+	session, err := app.store.WebAuthnSessions.Consume(r.Context(), sessionID, ceremony)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil, nil, errInvalidWebAuthnSession
+		default:
+			return nil, nil, err
+		}
+	}
+
+	data := &webauthnSessionData{}
+	if err := json.Unmarshal(session.Data, data); err != nil {
+		return nil, nil, err
+	}
+
+	return session, data, nil
+}
+
+func (app *application) respondWebAuthnBegin(w http.ResponseWriter, r *http.Request, sessionID string, options any) {
+	response := WebAuthnBeginResponse{
+		SessionID: sessionID,
+		Options:   options,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type BeginPasskeyRegistrationPayload struct {
+	Name string `json:"name" validate:"required,max=80"`
+}
+
+func (app *application) BeginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload BeginPasskeyRegistrationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if !app.throttleKey(w, r, "webauthn_begin:user:"+user.ID, app.config.Auth.Throttle.WebAuthnBeginMax) {
+		return
+	}
+
+	credentials, err := app.store.WebAuthnCredentials.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	waUser := newWebAuthnUser(user, credentials)
+
+	options, session, err := app.webauthn.BeginRegistration(waUser, webauthn.WithExclusions(waUser.exclusions()))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sessionID, err := app.saveWebAuthnSession(r, webauthnCeremonyRegister, user.ID, &webauthnSessionData{
+		Session: *session,
+		Name:    payload.Name,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.respondWebAuthnBegin(w, r, sessionID, options)
+}
+
+func (app *application) FinishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	session, data, err := app.consumeWebAuthnSession(r, webauthnCeremonyRegister)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidWebAuthnSession):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if session.UserID.String != user.ID {
+		app.badRequestResponse(w, r, errInvalidWebAuthnSession)
+		return
+	}
+
+	limitBody(w, r)
+	parsed, err := protocol.ParseCredentialCreationResponseBody(r.Body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	credentials, err := app.store.WebAuthnCredentials.GetByUserID(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	credential, err := app.webauthn.CreateCredential(newWebAuthnUser(user, credentials), data.Session, parsed)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	record := fromWebAuthnCredential(credential, data.Name)
+	record.UserID = user.ID
+
+	if err := app.store.WebAuthnCredentials.Create(ctx, record); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("passkey is already registered"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, record); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type BeginPasskeySignupPayload struct {
+	FirstName string `json:"first_name" validate:"required,max=80"`
+	LastName  string `json:"last_name" validate:"required,max=80"`
+	Username  string `json:"username" validate:"required,max=255"`
+	Email     string `json:"email" validate:"required,email,max=255"`
+	Name      string `json:"name" validate:"max=80"`
+}
+
+// BeginPasskeySignupHandler starts the registration of a passwordless
+// account. The user is only created once the passkey is verified.
+func (app *application) BeginPasskeySignupHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.Auth.RegistrationOpen {
+		app.registrationClosedResponse(w, r)
+		return
+	}
+
+	var payload BeginPasskeySignupPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttle(w, r, "webauthn_begin", app.config.Auth.Throttle.WebAuthnBeginMax) {
+		return
+	}
+
+	userID, err := store.NewUserID()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	pending := &pendingPasskeyUser{
+		ID:        userID,
+		FirstName: payload.FirstName,
+		LastName:  payload.LastName,
+		Username:  payload.Username,
+		Email:     payload.Email,
+	}
+
+	options, session, err := app.webauthn.BeginRegistration(newWebAuthnUser(pending.user(), nil))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	name := payload.Name
+	if name == "" {
+		name = "Passkey"
+	}
+
+	sessionID, err := app.saveWebAuthnSession(r, webauthnCeremonySignup, "", &webauthnSessionData{
+		Session:     *session,
+		Name:        name,
+		PendingUser: pending,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.respondWebAuthnBegin(w, r, sessionID, options)
+}
+
+func (p *pendingPasskeyUser) user() *store.User {
+	return &store.User{
+		ID:        p.ID,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		Username:  p.Username,
+		Email:     p.Email,
+	}
+}
+
+func (app *application) FinishPasskeySignupHandler(w http.ResponseWriter, r *http.Request) {
+	_, data, err := app.consumeWebAuthnSession(r, webauthnCeremonySignup)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidWebAuthnSession):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if data.PendingUser == nil {
+		app.badRequestResponse(w, r, errInvalidWebAuthnSession)
+		return
+	}
+
+	limitBody(w, r)
+	parsed, err := protocol.ParseCredentialCreationResponseBody(r.Body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := data.PendingUser.user()
+
+	credential, err := app.webauthn.CreateCredential(newWebAuthnUser(user, nil), data.Session, parsed)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	err = app.store.Users.CreateWithWebAuthnCredential(ctx, user, fromWebAuthnCredential(credential, data.Name))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateEmail), errors.Is(err, store.ErrDuplicateUsername):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("passkey is already registered"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, auditUserRegistered, user.ID, map[string]string{"method": loginMethodPasskey})
+	app.wakeOutboxRelay()
+
+	if app.config.Auth.EmailVerification.Policy == emailVerificationPolicyDeny {
+		if err := app.jsonMessageResponse(w, http.StatusCreated, "Successfully created user."); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	authInfo, err := app.issueAuthTokens(r, user, loginMethodPasskey)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountDeleted):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := UserWithAuth{
+		Auth: *authInfo,
+		User: user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type BeginPasskeyLoginPayload struct {
+	Email string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+// BeginPasskeyLoginHandler starts a login ceremony. Without an email, or for
+// unknown emails, a discoverable login is started so the response does not
+// reveal whether an account exists.
+func (app *application) BeginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload BeginPasskeyLoginPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.throttle(w, r, "webauthn_begin", app.config.Auth.Throttle.WebAuthnBeginMax) {
+		return
+	}
+
+	ctx := r.Context()
+
+	var waUser *webauthnUser
+	if payload.Email != "" {
+		if !app.throttleKey(w, r, "webauthn_begin:account:"+normalizeEmail(payload.Email), app.config.Auth.Throttle.WebAuthnBeginMax) {
+			return
+		}
+
+		user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if user != nil {
+			credentials, err := app.store.WebAuthnCredentials.GetByUserID(ctx, user.ID)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			if len(credentials) > 0 {
+				waUser = newWebAuthnUser(user, credentials)
+			}
+		}
+	}
+
+	var (
+		options *protocol.CredentialAssertion
+		session *webauthn.SessionData
+		userID  string
+		err     error
+	)
+
+	if waUser != nil {
+		userID = waUser.id
+		options, session, err = app.webauthn.BeginLogin(waUser)
+	} else {
+		options, session, err = app.webauthn.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sessionID, err := app.saveWebAuthnSession(r, webauthnCeremonyLogin, userID, &webauthnSessionData{
+		Session: *session,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.respondWebAuthnBegin(w, r, sessionID, options)
+}
+
+// FinishPasskeyLoginHandler signs in with the assertion of a login ceremony.
+// Failed assertions count towards the throttling of the IP.
+func (app *application) FinishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	wait, err := app.limitedFor(ctx, loginIPKey(r), app.config.Auth.Throttle.LoginIPMax)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wait > 0 {
+		app.rateLimitExceededResponse(w, r, retryAfter(wait))
+		return
+	}
+
+	session, data, err := app.consumeWebAuthnSession(r, webauthnCeremonyLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidWebAuthnSession):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	limitBody(w, r)
+	parsed, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var (
+		user        *store.User
+		credentials []*store.WebAuthnCredential
+	)
+
+	failed := func(err error) {
+		if err := app.passkeyLoginFailed(ctx, r, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.unauthorizedErrorResponse(w, r, err)
+	}
+
+	loadUser := func(userID string) (*webauthnUser, error) {
+		u, err := app.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := app.store.WebAuthnCredentials.GetByUserID(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		user, credentials = u, c
+
+		return newWebAuthnUser(u, c), nil
+	}
+
+	var credential *webauthn.Credential
+	if session.UserID.Valid {
+		waUser, err := loadUser(session.UserID.String)
+		if err != nil {
+			failed(err)
+			return
+		}
+
+		credential, err = app.webauthn.ValidateLogin(waUser, data.Session, parsed)
+		if err != nil {
+			failed(err)
+			return
+		}
+	} else {
+		credential, err = app.webauthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			return loadUser(string(userHandle))
+		}, data.Session, parsed)
+		if err != nil {
+			failed(err)
+			return
+		}
+	}
+
+	var record *store.WebAuthnCredential
+	for _, c := range credentials {
+		if bytes.Equal(c.CredentialID, credential.ID) {
+			record = c
+			break
+		}
+	}
+
+	if record == nil {
+		failed(fmt.Errorf("unknown credential"))
+		return
+	}
+
+	if credential.Authenticator.CloneWarning {
+		app.logger.Warnw("possible cloned authenticator", "user_id", user.ID, "credential_id", record.ID)
+		failed(fmt.Errorf("signature counter did not increase"))
+		return
+	}
+
+	record.SignCount = credential.Authenticator.SignCount
+	record.UserPresent = credential.Flags.UserPresent
+	record.UserVerified = credential.Flags.UserVerified
+	record.BackupState = credential.Flags.BackupState
+
+	if err := app.store.WebAuthnCredentials.RecordUse(ctx, record); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			failed(fmt.Errorf("signature counter did not increase"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !user.IsEmailVerified() && app.config.Auth.EmailVerification.Policy == emailVerificationPolicyDeny {
+		app.emailNotVerifiedResponse(w, r)
+		return
+	}
+
+	authInfo, err := app.issueAuthTokens(r, user, loginMethodPasskey)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAccountDeleted):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := UserWithAuth{
+		Auth: *authInfo,
+		User: user,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	credentials, err := app.store.WebAuthnCredentials.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, credentials); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DeletePasskeyHandler removes a passkey. Accounts without a password keep at
+// least one, as it is their only way to sign in.
+func (app *application) DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	err := app.store.WebAuthnCredentials.Delete(r.Context(), chi.URLParam(r, "credentialID"), user.ID, !user.HasPassword())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrLastCredential):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Passkey removed"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// pruneWebAuthnSessions periodically deletes the sessions of the ceremonies
+// that expired without being finished.
+func (app *application) pruneWebAuthnSessions(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.store.WebAuthnSessions.Prune(ctx, time.Now()); err != nil {
+				app.logger.Errorw("failed to prune webauthn sessions", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// The fixtures in testdata/webauthn were recorded from a software
+// authenticator holding an ES256 key, against the relying party of
+// newTestApplication. Their sessions do not expire. The login assertions
+// carry a signature counter of 7.
+type webauthnFixture struct {
+	UserID     string `json:"user_id"`
+	Credential struct {
+		CredentialID string `json:"credential_id"`
+		PublicKey    string `json:"public_key"`
+	} `json:"credential"`
+	Session  webauthnSessionData `json:"session"`
+	Response json.RawMessage     `json:"response"`
+}
+
+func loadWebAuthnFixture(t *testing.T, name string) *webauthnFixture {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "webauthn", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := &webauthnFixture{}
+	if err := json.Unmarshal(data, fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	return fixture
+}
+
+// credential returns the passkey the login fixtures were signed with, as
+// stored after a sign-in that saw the given signature counter.
+func (f *webauthnFixture) credential(t *testing.T, signCount uint32) *store.WebAuthnCredential {
+	t.Helper()
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(f.Credential.CredentialID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(f.Credential.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &store.WebAuthnCredential{
+		ID:              "wacred_test",
+		UserID:          f.UserID,
+		Name:            "Laptop",
+		CredentialID:    credentialID,
+		PublicKey:       publicKey,
+		AttestationType: "none",
+		SignCount:       signCount,
+		UserPresent:     true,
+		UserVerified:    true,
+	}
+}
+
+type fakeUserStore struct {
+	*store.UserStore
+
+	users       map[string]*store.User
+	credentials *fakeWebAuthnCredentialStore
+}
+
+func (s *fakeUserStore) GetByID(_ context.Context, id string) (*store.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	copy := *user
+
+	return &copy, nil
+}
+
+func (s *fakeUserStore) GetByEmail(_ context.Context, email string) (*store.User, error) {
+	for _, user := range s.users {
+		if user.Email == email {
+			copy := *user
+			return &copy, nil
+		}
+	}
+
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeUserStore) CreateWithWebAuthnCredential(ctx context.Context, user *store.User, credential *store.WebAuthnCredential) error {
+	credential.UserID = user.ID
+
+	if err := s.credentials.Create(ctx, credential); err != nil {
+		return err
+	}
+
+	s.users[user.ID] = user
+
+	return nil
+}
+
+type fakeWebAuthnCredentialStore struct {
+	*store.WebAuthnCredentialStore
+
+	credentials []*store.WebAuthnCredential
+}
+
+func (s *fakeWebAuthnCredentialStore) Create(_ context.Context, credential *store.WebAuthnCredential) error {
+	for _, c := range s.credentials {
+		if bytes.Equal(c.CredentialID, credential.CredentialID) {
+			return store.ErrConflict
+		}
+	}
+
+	s.credentials = append(s.credentials, credential)
+
+	return nil
+}
+
+func (s *fakeWebAuthnCredentialStore) GetByUserID(_ context.Context, userID string) ([]*store.WebAuthnCredential, error) {
+	credentials := []*store.WebAuthnCredential{}
+	for _, c := range s.credentials {
+		if c.UserID == userID {
+			copy := *c
+			credentials = append(credentials, &copy)
+		}
+	}
+
+	return credentials, nil
+}
+
+// RecordUse only lets the counter go up, as the real store does.
+func (s *fakeWebAuthnCredentialStore) RecordUse(_ context.Context, credential *store.WebAuthnCredential) error {
+	for _, c := range s.credentials {
+		if c.ID != credential.ID {
+			continue
+		}
+
+		if c.SignCount >= credential.SignCount && (c.SignCount != 0 || credential.SignCount != 0) {
+			return store.ErrConflict
+		}
+
+		c.SignCount = credential.SignCount
+
+		return nil
+	}
+
+	return store.ErrConflict
+}
+
+type fakeWebAuthnSessionStore struct {
+	*store.WebAuthnSessionStore
+
+	sessions map[string]*store.WebAuthnSession
+}
+
+func (s *fakeWebAuthnSessionStore) Consume(_ context.Context, id, ceremony string) (*store.WebAuthnSession, error) {
+	session, ok := s.sessions[id]
+	if !ok || session.Ceremony != ceremony {
+		return nil, store.ErrNotFound
+	}
+
+	delete(s.sessions, id)
+
+	return session, nil
+}
+
+type webauthnTest struct {
+	app         *application
+	users       *fakeUserStore
+	credentials *fakeWebAuthnCredentialStore
+	sessions    *fakeWebAuthnSessionStore
+}
+
+func newWebAuthnTest(t *testing.T) *webauthnTest {
+	t.Helper()
+
+	credentials := &fakeWebAuthnCredentialStore{}
+	users := &fakeUserStore{users: map[string]*store.User{}, credentials: credentials}
+	sessions := &fakeWebAuthnSessionStore{sessions: map[string]*store.WebAuthnSession{}}
+
+	app := newTestApplication(t, store.Storage{
+		Users:               users,
+		WebAuthnCredentials: credentials,
+		WebAuthnSessions:    sessions,
+	})
+
+	return &webauthnTest{app: app, users: users, credentials: credentials, sessions: sessions}
+}
+
+// finish stores the session of the fixture as a ceremony of sessionUserID,
+// if any, and posts its response to handler on behalf of user, if any.
+func (wt *webauthnTest) finish(t *testing.T, handler http.HandlerFunc, ceremony string, fixture *webauthnFixture, sessionUserID string, user *store.User) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(fixture.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := &store.WebAuthnSession{
+		ID:       "wasess_test",
+		Ceremony: ceremony,
+		UserID:   sql.NullString{String: sessionUserID, Valid: sessionUserID != ""},
+		Data:     data,
+	}
+	wt.sessions.sessions[session.ID] = session
+
+	r := httptest.NewRequest(http.MethodPost, "/?session_id="+session.ID, bytes.NewReader(fixture.Response))
+	r.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		r = r.WithContext(context.WithValue(r.Context(), userCtxKey, user))
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestFinishPasskeySignup(t *testing.T) {
+	t.Run("creates the user with the passkey", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		fixture := loadWebAuthnFixture(t, "signup.json")
+
+		w := wt.finish(t, wt.app.FinishPasskeySignupHandler, webauthnCeremonySignup, fixture, "", nil)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+		}
+
+		user, ok := wt.users.users[fixture.Session.PendingUser.ID]
+		if !ok {
+			t.Fatal("user was not created")
+		}
+		if user.Email != fixture.Session.PendingUser.Email {
+			t.Errorf("got email %q, want %q", user.Email, fixture.Session.PendingUser.Email)
+		}
+
+		if len(wt.credentials.credentials) != 1 {
+			t.Fatalf("got %d passkeys, want 1", len(wt.credentials.credentials))
+		}
+		if c := wt.credentials.credentials[0]; c.UserID != user.ID || c.Name != fixture.Session.Name {
+			t.Errorf("got passkey %q of %q, want %q of %q", c.Name, c.UserID, fixture.Session.Name, user.ID)
+		}
+	})
+
+	t.Run("rejects a mismatched challenge", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		fixture := loadWebAuthnFixture(t, "signup.json")
+		fixture.Session.Session.Challenge = "c29tZSBvdGhlciBjaGFsbGVuZ2U"
+
+		w := wt.finish(t, wt.app.FinishPasskeySignupHandler, webauthnCeremonySignup, fixture, "", nil)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+
+		if len(wt.users.users) != 0 {
+			t.Error("user was created")
+		}
+	})
+
+	t.Run("rejects a session of another ceremony", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		fixture := loadWebAuthnFixture(t, "signup.json")
+
+		w := wt.finish(t, wt.app.FinishPasskeySignupHandler, webauthnCeremonyRegister, fixture, "", nil)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestFinishPasskeyRegistration(t *testing.T) {
+	fixture := loadWebAuthnFixture(t, "register.json")
+
+	t.Run("adds the passkey to the user", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		user := &store.User{ID: fixture.UserID, Email: "grace@example.com"}
+
+		w := wt.finish(t, wt.app.FinishPasskeyRegistrationHandler, webauthnCeremonyRegister, fixture, user.ID, user)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+		}
+
+		if len(wt.credentials.credentials) != 1 {
+			t.Fatalf("got %d passkeys, want 1", len(wt.credentials.credentials))
+		}
+		if c := wt.credentials.credentials[0]; c.UserID != user.ID {
+			t.Errorf("got passkey of %q, want %q", c.UserID, user.ID)
+		}
+	})
+
+	t.Run("rejects the session of another user", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		user := &store.User{ID: fixture.UserID}
+
+		w := wt.finish(t, wt.app.FinishPasskeyRegistrationHandler, webauthnCeremonyRegister, fixture, "user_other", user)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if len(wt.credentials.credentials) != 0 {
+			t.Error("passkey was added")
+		}
+	})
+
+	t.Run("rejects a mismatched challenge", func(t *testing.T) {
+		wt := newWebAuthnTest(t)
+		user := &store.User{ID: fixture.UserID}
+
+		mismatched := *fixture
+		mismatched.Session.Session.Challenge = "c29tZSBvdGhlciBjaGFsbGVuZ2U"
+
+		w := wt.finish(t, wt.app.FinishPasskeyRegistrationHandler, webauthnCeremonyRegister, &mismatched, user.ID, user)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if len(wt.credentials.credentials) != 0 {
+			t.Error("passkey was added")
+		}
+	})
+}
+
+func TestFinishPasskeyLogin(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		challenge string
+		// signCount is the counter stored for the passkey before the login.
+		signCount uint32
+		wantCode  int
+	}{
+		{name: "signs in", fixture: "login.json", signCount: 3, wantCode: http.StatusOK},
+		{name: "signs in with a passkey that has no counter yet", fixture: "login.json", wantCode: http.StatusOK},
+		{name: "rejects a counter that went backwards", fixture: "login.json", signCount: 10, wantCode: http.StatusUnauthorized},
+		{name: "rejects a counter that did not increase", fixture: "login.json", signCount: 7, wantCode: http.StatusUnauthorized},
+		{name: "rejects a mismatched challenge", fixture: "login.json", challenge: "c29tZSBvdGhlciBjaGFsbGVuZ2U", wantCode: http.StatusUnauthorized},
+		{name: "rejects a foreign origin", fixture: "login_foreign_origin.json", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wt := newWebAuthnTest(t)
+			fixture := loadWebAuthnFixture(t, tt.fixture)
+			if tt.challenge != "" {
+				fixture.Session.Session.Challenge = tt.challenge
+			}
+
+			user := &store.User{ID: fixture.UserID, Email: "alan@example.com"}
+			wt.users.users[user.ID] = user
+			wt.credentials.credentials = []*store.WebAuthnCredential{fixture.credential(t, tt.signCount)}
+
+			// The session was started for the user, as when they enter their
+			// email, but the user is not signed in.
+			w := wt.finish(t, wt.app.FinishPasskeyLoginHandler, webauthnCeremonyLogin, fixture, user.ID, nil)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			wantCount := tt.signCount
+			if tt.wantCode == http.StatusOK {
+				wantCount = 7
+			}
+			if got := wt.credentials.credentials[0].SignCount; got != wantCount {
+				t.Errorf("got stored counter %d, want %d", got, wantCount)
+			}
+		})
+	}
+}
+
+func TestFinishPasskeyLoginThrottling(t *testing.T) {
+	wt := newWebAuthnTest(t)
+	wt.app.config.Auth.Throttle.LoginIPMax = 2
+	attempts := wt.app.store.AuthAttempts.(*fakeAuthAttemptStore)
+
+	fixture := loadWebAuthnFixture(t, "login_foreign_origin.json")
+	user := &store.User{ID: fixture.UserID}
+	wt.users.users[user.ID] = user
+	wt.credentials.credentials = []*store.WebAuthnCredential{fixture.credential(t, 0)}
+
+	ipKey := loginIPKey(httptest.NewRequest(http.MethodPost, "/", nil))
+
+	for i := range 2 {
+		w := wt.finish(t, wt.app.FinishPasskeyLoginHandler, webauthnCeremonyLogin, fixture, user.ID, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got status %d, want %d", i, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if got := attempts.count(ipKey); got != 2 {
+		t.Fatalf("got %d failures recorded for the IP, want 2", got)
+	}
+
+	// Once the IP reaches the limit, even a valid assertion is refused.
+	fixture = loadWebAuthnFixture(t, "login.json")
+	w := wt.finish(t, wt.app.FinishPasskeyLoginHandler, webauthnCeremonyLogin, fixture, user.ID, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
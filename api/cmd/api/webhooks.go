@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid"
+	"github.com/menaguilherme/trigon/internal/backoff"
+	"github.com/menaguilherme/trigon/internal/store"
+	"github.com/menaguilherme/trigon/internal/webhook"
+)
+
+const (
+	permWebhooksRead  = "webhooks:read"
+	permWebhooksWrite = "webhooks:write"
+)
+
+// Webhook secrets start with webhookSecretPrefix so they can be spotted by
+// secret scanners.
+const webhookSecretPrefix = "whsec_"
+
+// webhookEvents lists the events an endpoint can subscribe to.
+var webhookEvents = []string{
+	store.EventUserRegistered,
+	store.EventUserBlocked,
+	store.EventUserUnblocked,
+	store.EventUserDeleted,
+	store.EventUserRestored,
+	store.EventUserPurged,
+}
+
+func validateWebhookEvents(events []string) error {
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return errors.New("unknown event " + event)
+		}
+	}
+
+	return nil
+}
+
+// WebhookEvent is the body of every webhook request.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// WebhookUser is the part of a user shared with webhook receivers. Purged
+// users only have their ID.
+type WebhookUser struct {
+	ID        string `json:"id"`
+	Email     string `json:"email,omitempty"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+}
+
+type WebhookUserEvent struct {
+	User WebhookUser `json:"user"`
+	// Reason is set on user.blocked.
+	Reason string `json:"reason,omitempty"`
+	// PurgeAfter is set on user.deleted. The account can be restored until
+	// then.
+	PurgeAfter *time.Time `json:"purge_after,omitempty"`
+}
+
+// publishUserWebhook is the outbox handler queueing the user events for the
+// endpoints.
+func (app *application) publishUserWebhook(ctx context.Context, event *store.OutboxEvent) error {
+	var user store.UserEvent
+	if err := json.Unmarshal(event.Payload, &user); err != nil {
+		return err
+	}
+
+	// The payload of a purged user only has its ID.
+	data := WebhookUserEvent{
+		User: WebhookUser{
+			ID:        user.UserID,
+			Email:     user.Email,
+			Username:  user.Username,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		},
+		Reason: user.Reason,
+	}
+
+	if event.Event == store.EventUserDeleted {
+		purgeAfter := event.CreatedAt.Add(app.config.Auth.AccountDeletion.GracePeriod).UTC()
+		data.PurgeAfter = &purgeAfter
+	}
+
+	return app.publishWebhook(ctx, event, data)
+}
+
+// publishWebhook queues the event for the subscribed endpoints. The webhook
+// event takes the ID of the outbox event, so publishing it again does not
+// queue it twice.
+func (app *application) publishWebhook(ctx context.Context, event *store.OutboxEvent, data any) error {
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        event.ID,
+		Type:      event.Event,
+		CreatedAt: event.CreatedAt.UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	queued, err := app.store.WebhookDeliveries.Enqueue(ctx, event.ID, event.Event, payload)
+	if err != nil {
+		return err
+	}
+
+	if queued > 0 {
+		app.wakeWebhookWorkers()
+	}
+
+	return nil
+}
+
+// wakeWebhookWorkers has a worker look for due deliveries rather than wait
+// for its next poll.
+func (app *application) wakeWebhookWorkers() {
+	select {
+	case app.webhookQueue <- struct{}{}:
+	default:
+	}
+}
+
+// processWebhookDeliveries sends the due deliveries, polling for them and
+// waking up early when an event is published. Several workers can run side
+// by side.
+func (app *application) processWebhookDeliveries(ctx context.Context) {
+	poll := time.NewTicker(app.config.Webhook.PollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-app.webhookQueue:
+		}
+
+		for {
+			delivery, err := app.store.WebhookDeliveries.Claim(ctx)
+			if err != nil {
+				if !errors.Is(err, store.ErrNotFound) {
+					app.logger.Errorw("failed to claim webhook delivery", "error", err)
+				}
+				break
+			}
+
+			if err := app.deliverWebhook(ctx, delivery); err != nil {
+				app.logger.Errorw("failed to deliver webhook", "delivery_id", delivery.ID, "error", err)
+			}
+		}
+	}
+}
+
+// deliverWebhook makes an attempt to send a claimed delivery and schedules
+// the next one when it fails. A delivery that runs out of attempts is dead.
+// The returned error means the attempt could not be made or recorded, the
+// delivery is then picked up again once it is considered stuck.
+func (app *application) deliverWebhook(ctx context.Context, delivery *store.WebhookDelivery) error {
+	cfg := app.config.Webhook
+
+	endpoint, err := app.store.WebhookEndpoints.GetByID(ctx, delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	secret, err := app.secretBox.Open(endpoint.Secret)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	resp, err := app.webhooks.Send(ctx, webhook.Request{
+		URL:    endpoint.URL,
+		Secret: secret,
+		ID:     delivery.EventID,
+		Body:   delivery.Payload,
+	})
+
+	attempt := &store.WebhookAttempt{
+		DurationMS: int(time.Since(start).Milliseconds()),
+	}
+
+	switch {
+	case err != nil:
+		attempt.Error = err.Error()
+	case !resp.OK():
+		attempt.Error = "receiver answered " + strconv.Itoa(resp.StatusCode)
+	}
+
+	if resp != nil {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(resp.StatusCode), Valid: true}
+		attempt.ResponseBody = resp.Body
+	}
+
+	delivery.Attempts++
+	delivery.NextAttemptAt = sql.NullTime{}
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = store.WebhookDeliverySucceeded
+	case delivery.Attempts >= cfg.MaxAttempts:
+		delivery.Status = store.WebhookDeliveryDead
+
+		app.logger.Warnw("webhook delivery is dead",
+			"delivery_id", delivery.ID,
+			"endpoint_id", endpoint.ID,
+			"event", delivery.Event,
+			"attempts", delivery.Attempts,
+			"error", attempt.Error,
+		)
+	default:
+		delivery.Status = store.WebhookDeliveryPending
+		delivery.NextAttemptAt = sql.NullTime{Time: time.Now().Add(backoff.Delay(delivery.Attempts, cfg.BackoffBase, cfg.BackoffMax)), Valid: true}
+	}
+
+	return app.store.WebhookDeliveries.RecordAttempt(ctx, delivery, attempt)
+}
+
+// pruneWebhookDeliveries periodically deletes the finished deliveries that
+// are past the retention.
+func (app *application) pruneWebhookDeliveries(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := time.Now().Add(-app.config.Webhook.Retention)
+			if err := app.store.WebhookDeliveries.Prune(ctx, before); err != nil {
+				app.logger.Errorw("failed to prune webhook deliveries", "error", err)
+			}
+		}
+	}
+}
+
+type CreateWebhookPayload struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2048"`
+	Description string   `json:"description" validate:"max=255"`
+	Events      []string `json:"events" validate:"dive,required"`
+}
+
+type CreatedWebhookEndpoint struct {
+	*store.WebhookEndpoint
+	// Secret is only ever returned here.
+	Secret string `json:"secret"`
+}
+
+// CreateWebhookHandler registers an endpoint. It receives every event when
+// it subscribes to none.
+func (app *application) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateWebhookPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := validateWebhookEvents(payload.Events); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	secret, err := gonanoid.Generate("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 40)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	plaintext := webhookSecretPrefix + secret
+
+	encrypted, err := app.secretBox.Seal([]byte(plaintext))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	endpoint := &store.WebhookEndpoint{
+		URL:         payload.URL,
+		Description: payload.Description,
+		Secret:      encrypted,
+		Events:      slices.Compact(slices.Sorted(slices.Values(payload.Events))),
+		IsActive:    true,
+		CreatedBy:   sql.NullString{String: getUserFromContext(r).ID, Valid: true},
+	}
+
+	if endpoint.Events == nil {
+		endpoint.Events = []string{}
+	}
+
+	if err := app.store.WebhookEndpoints.Create(r.Context(), endpoint); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, CreatedWebhookEndpoint{endpoint, plaintext}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+func (app *application) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := app.store.WebhookEndpoints.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, endpoints); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// getWebhookEndpoint loads the endpoint of the route. It answers 404 and
+// returns nil when there is none.
+func (app *application) getWebhookEndpoint(w http.ResponseWriter, r *http.Request) *store.WebhookEndpoint {
+	endpoint, err := app.store.WebhookEndpoints.GetByID(r.Context(), chi.URLParam(r, "webhookID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil
+	}
+
+	return endpoint
+}
+
+func (app *application) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := app.getWebhookEndpoint(w, r)
+	if endpoint == nil {
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, endpoint); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type UpdateWebhookPayload struct {
+	URL         *string  `json:"url" validate:"omitnil,http_url,max=2048"`
+	Description *string  `json:"description" validate:"omitnil,max=255"`
+	Events      []string `json:"events" validate:"omitempty,dive,required"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// UpdateWebhookHandler changes an endpoint. Deactivated endpoints keep their
+// queued deliveries until they are activated again. The secret cannot be
+// changed, a new endpoint has to be registered instead.
+func (app *application) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateWebhookPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := validateWebhookEvents(payload.Events); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	endpoint := app.getWebhookEndpoint(w, r)
+	if endpoint == nil {
+		return
+	}
+
+	if payload.URL != nil {
+		endpoint.URL = *payload.URL
+	}
+
+	if payload.Description != nil {
+		endpoint.Description = *payload.Description
+	}
+
+	if payload.Events != nil {
+		endpoint.Events = slices.Compact(slices.Sorted(slices.Values(payload.Events)))
+	}
+
+	if payload.IsActive != nil {
+		endpoint.IsActive = *payload.IsActive
+	}
+
+	if err := app.store.WebhookEndpoints.Update(r.Context(), endpoint); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if endpoint.IsActive {
+		app.wakeWebhookWorkers()
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, endpoint); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DeleteWebhookHandler removes an endpoint along with its deliveries.
+func (app *application) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.store.WebhookEndpoints.Delete(r.Context(), chi.URLParam(r, "webhookID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonMessageResponse(w, http.StatusOK, "Webhook deleted"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type WebhookDeliveryList struct {
+	Deliveries []*store.WebhookDelivery `json:"deliveries"`
+	Page
+}
+
+// ListWebhookDeliveriesHandler lists the deliveries of an endpoint with the
+// status, limit and offset query parameters.
+func (app *application) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := readPage(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+
+	statuses := []string{
+		store.WebhookDeliveryPending,
+		store.WebhookDeliveryProcessing,
+		store.WebhookDeliverySucceeded,
+		store.WebhookDeliveryDead,
+	}
+
+	if status != "" && !slices.Contains(statuses, status) {
+		app.badRequestResponse(w, r, errors.New("unknown status "+status))
+		return
+	}
+
+	endpoint := app.getWebhookEndpoint(w, r)
+	if endpoint == nil {
+		return
+	}
+
+	deliveries, total, err := app.store.WebhookDeliveries.ListForEndpoint(r.Context(), endpoint.ID, status, page.Limit, page.Offset)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	page.Total = total
+
+	if err := app.jsonResponse(w, http.StatusOK, WebhookDeliveryList{deliveries, page}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// getWebhookDelivery loads the delivery of the route. It answers 404 and
+// returns nil when there is none.
+func (app *application) getWebhookDelivery(w http.ResponseWriter, r *http.Request) *store.WebhookDelivery {
+	delivery, err := app.store.WebhookDeliveries.GetByID(r.Context(), chi.URLParam(r, "webhookID"), chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil
+	}
+
+	return delivery
+}
+
+type WebhookDeliveryDetail struct {
+	Delivery *store.WebhookDelivery  `json:"delivery"`
+	Attempts []*store.WebhookAttempt `json:"attempts"`
+}
+
+// GetWebhookDeliveryHandler returns a delivery with the log of its attempts.
+func (app *application) GetWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	delivery := app.getWebhookDelivery(w, r)
+	if delivery == nil {
+		return
+	}
+
+	attempts, err := app.store.WebhookDeliveries.ListAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, WebhookDeliveryDetail{delivery, attempts}); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// ReplayWebhookDeliveryHandler sends a succeeded or dead delivery again,
+// with the same event ID and body.
+func (app *application) ReplayWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	delivery := app.getWebhookDelivery(w, r)
+	if delivery == nil {
+		return
+	}
+
+	if err := app.store.WebhookDeliveries.Replay(r.Context(), delivery); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("delivery is still queued"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhookWorkers()
+
+	if err := app.jsonResponse(w, http.StatusAccepted, delivery); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
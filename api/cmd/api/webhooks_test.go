@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/store"
+	"github.com/menaguilherme/trigon/internal/webhook"
+)
+
+type fakeWebhookEndpointStore struct {
+	*store.WebhookEndpointStore
+
+	endpoint *store.WebhookEndpoint
+}
+
+func (s *fakeWebhookEndpointStore) GetByID(_ context.Context, id string) (*store.WebhookEndpoint, error) {
+	if s.endpoint == nil || s.endpoint.ID != id {
+		return nil, store.ErrNotFound
+	}
+	return s.endpoint, nil
+}
+
+type fakeWebhookDeliveryStore struct {
+	*store.WebhookDeliveryStore
+
+	mu       sync.Mutex
+	delivery *store.WebhookDelivery
+	attempts []*store.WebhookAttempt
+}
+
+func (s *fakeWebhookDeliveryStore) RecordAttempt(_ context.Context, delivery *store.WebhookDelivery, attempt *store.WebhookAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := *delivery
+	s.delivery = &saved
+	s.attempts = append(s.attempts, attempt)
+
+	return nil
+}
+
+func (s *fakeWebhookDeliveryStore) GetByID(_ context.Context, endpointID, id string) (*store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.delivery == nil || s.delivery.EndpointID != endpointID || s.delivery.ID != id {
+		return nil, store.ErrNotFound
+	}
+
+	delivery := *s.delivery
+	return &delivery, nil
+}
+
+// Replay mimics the query of WebhookDeliveryStore.Replay.
+func (s *fakeWebhookDeliveryStore) Replay(_ context.Context, delivery *store.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.delivery.Status != store.WebhookDeliverySucceeded && s.delivery.Status != store.WebhookDeliveryDead {
+		return store.ErrConflict
+	}
+
+	s.delivery.Status = store.WebhookDeliveryPending
+	s.delivery.Attempts = 0
+	s.delivery.NextAttemptAt = sql.NullTime{Time: time.Now(), Valid: true}
+	s.delivery.CompletedAt = sql.NullTime{}
+
+	*delivery = *s.delivery
+
+	return nil
+}
+
+func (s *fakeWebhookDeliveryStore) last() (*store.WebhookDelivery, *store.WebhookAttempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.attempts) == 0 {
+		return s.delivery, nil
+	}
+	return s.delivery, s.attempts[len(s.attempts)-1]
+}
+
+type webhookTest struct {
+	app        *application
+	deliveries *fakeWebhookDeliveryStore
+}
+
+// newWebhookTest returns an application sending webhooks to handler, whose
+// endpoint has ID "wh_1".
+func newWebhookTest(t *testing.T, handler http.HandlerFunc) *webhookTest {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	secretBox, err := auth.NewSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := secretBox.Seal([]byte("whsec_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := &fakeWebhookDeliveryStore{}
+
+	app := newTestApplication(t, store.Storage{
+		WebhookEndpoints: &fakeWebhookEndpointStore{
+			endpoint: &store.WebhookEndpoint{ID: "wh_1", URL: srv.URL, Secret: secret, IsActive: true},
+		},
+		WebhookDeliveries: deliveries,
+	})
+	app.secretBox = secretBox
+	app.webhooks = webhook.NewClient(100*time.Millisecond, "Trigon-Webhooks/test")
+	app.config.Webhook.MaxAttempts = 3
+	app.config.Webhook.BackoffBase = time.Minute
+	app.config.Webhook.BackoffMax = time.Hour
+
+	return &webhookTest{app: app, deliveries: deliveries}
+}
+
+func newTestDelivery() *store.WebhookDelivery {
+	return &store.WebhookDelivery{
+		ID:         "whd_1",
+		EndpointID: "wh_1",
+		EventID:    "evt_1",
+		Event:      store.EventUserRegistered,
+		Payload:    json.RawMessage(`{"id":"evt_1"}`),
+		Status:     store.WebhookDeliveryProcessing,
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if err := webhook.Verify([]byte("whsec_test"), r.Header, body, time.Minute, time.Now()); err != nil {
+				t.Errorf("receiver: %v", err)
+			}
+
+			w.Write([]byte("thanks"))
+		})
+
+		if err := wt.app.deliverWebhook(context.Background(), newTestDelivery()); err != nil {
+			t.Fatal(err)
+		}
+
+		delivery, attempt := wt.deliveries.last()
+		if delivery.Status != store.WebhookDeliverySucceeded || delivery.Attempts != 1 || delivery.NextAttemptAt.Valid {
+			t.Errorf("got delivery %+v", delivery)
+		}
+
+		if attempt.Error != "" || attempt.StatusCode.Int32 != http.StatusOK || attempt.ResponseBody != "thanks" {
+			t.Errorf("got attempt %+v", attempt)
+		}
+	})
+
+	t.Run("retried on a server error", func(t *testing.T) {
+		wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		})
+
+		delivery := newTestDelivery()
+		delivery.Attempts = 1
+
+		start := time.Now()
+		if err := wt.app.deliverWebhook(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+
+		delivery, attempt := wt.deliveries.last()
+		if delivery.Status != store.WebhookDeliveryPending || delivery.Attempts != 2 {
+			t.Errorf("got delivery %+v", delivery)
+		}
+
+		// The second failure waits twice the base.
+		if delay := delivery.NextAttemptAt.Time.Sub(start); delay < 2*time.Minute || delay > 2*time.Minute+12*time.Second+time.Second {
+			t.Errorf("retried after %s", delay)
+		}
+
+		if attempt.Error != "receiver answered 503" || attempt.StatusCode.Int32 != http.StatusServiceUnavailable {
+			t.Errorf("got attempt %+v", attempt)
+		}
+	})
+
+	t.Run("retried on a timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		})
+		defer close(done)
+
+		if err := wt.app.deliverWebhook(context.Background(), newTestDelivery()); err != nil {
+			t.Fatal(err)
+		}
+
+		delivery, attempt := wt.deliveries.last()
+		if delivery.Status != store.WebhookDeliveryPending || !delivery.NextAttemptAt.Valid {
+			t.Errorf("got delivery %+v", delivery)
+		}
+
+		if attempt.Error == "" || attempt.StatusCode.Valid {
+			t.Errorf("got attempt %+v", attempt)
+		}
+	})
+
+	t.Run("dead after MaxAttempts", func(t *testing.T) {
+		wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+
+		delivery := newTestDelivery()
+		delivery.Attempts = wt.app.config.Webhook.MaxAttempts - 1
+
+		if err := wt.app.deliverWebhook(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+
+		delivery, _ = wt.deliveries.last()
+		if delivery.Status != store.WebhookDeliveryDead || delivery.NextAttemptAt.Valid {
+			t.Errorf("got delivery %+v", delivery)
+		}
+	})
+
+	t.Run("redirects are not followed", func(t *testing.T) {
+		var followed atomic.Bool
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			followed.Store(true)
+		}))
+		defer target.Close()
+
+		wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, target.URL, http.StatusFound)
+		})
+
+		if err := wt.app.deliverWebhook(context.Background(), newTestDelivery()); err != nil {
+			t.Fatal(err)
+		}
+
+		delivery, attempt := wt.deliveries.last()
+		if delivery.Status != store.WebhookDeliveryPending || attempt.StatusCode.Int32 != http.StatusFound {
+			t.Errorf("got delivery %+v, attempt %+v", delivery, attempt)
+		}
+
+		if followed.Load() {
+			t.Error("the redirect was followed")
+		}
+	})
+}
+
+func TestReplayWebhookDelivery(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+
+	wt := newWebhookTest(t, func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	r := chi.NewRouter()
+	r.Post("/webhooks/{webhookID}/deliveries/{deliveryID}/replay", wt.app.ReplayWebhookDeliveryHandler)
+
+	replay := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/webhooks/wh_1/deliveries/whd_1/replay", nil))
+		return rr
+	}
+
+	ctx := context.Background()
+
+	delivery := newTestDelivery()
+	delivery.Attempts = wt.app.config.Webhook.MaxAttempts - 1
+	if err := wt.app.deliverWebhook(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery, _ := wt.deliveries.last(); delivery.Status != store.WebhookDeliveryDead {
+		t.Fatalf("got status %q", delivery.Status)
+	}
+
+	rr := replay()
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %d: %s", rr.Code, rr.Body)
+	}
+
+	var replayed store.WebhookDelivery
+	if err := json.Unmarshal(rr.Body.Bytes(), &replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.Status != store.WebhookDeliveryPending || replayed.Attempts != 0 {
+		t.Errorf("got %+v", replayed)
+	}
+
+	if rr := replay(); rr.Code != http.StatusConflict {
+		t.Errorf("replaying a queued delivery: got %d", rr.Code)
+	}
+
+	// The replay has a fresh set of attempts.
+	fail.Store(false)
+	if err := wt.app.deliverWebhook(ctx, &replayed); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivery, _ := wt.deliveries.last(); delivery.Status != store.WebhookDeliverySucceeded || delivery.Attempts != 1 {
+		t.Errorf("got %+v", delivery)
+	}
+
+	if rr := replay(); rr.Code != http.StatusAccepted {
+		t.Errorf("replaying a succeeded delivery: got %d", rr.Code)
+	}
+}
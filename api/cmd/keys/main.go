@@ -0,0 +1,151 @@
+// Command keys manages the JWT signing keys stored in the database.
+//
+//	keys list
+//	keys generate [-alg ES256]
+//	keys promote <key id>
+//	keys retire <key id>
+//	keys rotate
+//
+// rotate applies the JWT_KEY_ROTATION_* schedule and is meant to run
+// periodically, e.g. hourly from cron. Running instances of the API pick up
+// the changes within JWT_KEY_RING_REFRESH_INTERVAL.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/menaguilherme/trigon/configs"
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/db"
+	"github.com/menaguilherme/trigon/internal/keyring"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	db, err := db.New(
+		configs.Envs.DB.ConnAddr,
+		configs.Envs.DB.MaxOpenConns,
+		configs.Envs.DB.MaxIdleConns,
+		configs.Envs.DB.MaxIdleTime,
+	)
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	encryptionKey, err := configs.Envs.EncryptionKey()
+	if err != nil {
+		fatal(err)
+	}
+
+	secretBox, err := auth.NewSecretBox(encryptionKey)
+	if err != nil {
+		fatal(err)
+	}
+
+	storage := store.NewStorage(db)
+	manager := keyring.NewManager(storage.SigningKeys, secretBox)
+	ctx := context.Background()
+	rotation := configs.Envs.Auth.Token.KeyRing.Rotation
+
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "list":
+		err = list(ctx, manager)
+	case "generate":
+		fs := flag.NewFlagSet("generate", flag.ExitOnError)
+		alg := fs.String("alg", rotation.Algorithm, "signing algorithm: RS256, ES256 or EdDSA")
+		fs.Parse(args)
+
+		var key *store.SigningKey
+		key, err = manager.Generate(ctx, *alg)
+		if err == nil {
+			fmt.Printf("generated pending key %s\n", key.ID)
+		}
+	case "promote":
+		err = withKeyID(args, func(id string) error {
+			return manager.Promote(ctx, id)
+		})
+	case "retire":
+		err = withKeyID(args, func(id string) error {
+			return manager.Retire(ctx, id)
+		})
+	case "rotate":
+		var report *keyring.RotationReport
+		report, err = manager.Rotate(ctx, keyring.RotationPolicy{
+			Algorithm:    rotation.Algorithm,
+			Interval:     rotation.Interval,
+			PublishAhead: rotation.PublishAhead,
+			RetireAfter:  rotation.RetireAfter,
+		}, time.Now())
+		if err == nil {
+			fmt.Printf("generated: %v\npromoted: %v\nretired: %v\n", report.Generated, report.Promoted, report.Retired)
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func list(ctx context.Context, manager *keyring.Manager) error {
+	keys, err := manager.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tALG\tSTATUS\tCREATED\tACTIVATED\tDEACTIVATED")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			key.ID,
+			key.Algorithm,
+			key.Status,
+			key.CreatedAt.Format(time.RFC3339),
+			formatTime(key.ActivatedAt.Time, key.ActivatedAt.Valid),
+			formatTime(key.DeactivatedAt.Time, key.DeactivatedAt.Valid),
+		)
+	}
+
+	return w.Flush()
+}
+
+func withKeyID(args []string, fn func(id string) error) error {
+	if len(args) != 1 {
+		usage()
+	}
+
+	if err := fn(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Println("ok")
+	return nil
+}
+
+func formatTime(t time.Time, valid bool) string {
+	if !valid {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keys list | generate [-alg ES256] | promote <id> | retire <id> | rotate")
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
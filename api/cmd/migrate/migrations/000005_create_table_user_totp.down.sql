@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS set_timestamp ON user_totp;
+
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id TEXT PRIMARY KEY NOT NULL,
+  secret BYTEA NOT NULL,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  confirmed_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON user_totp
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS webauthn_sessions;
+
+DROP TRIGGER IF EXISTS set_timestamp ON webauthn_credentials;
+
+DROP TABLE IF EXISTS webauthn_credentials;
+
+ALTER TABLE users ALTER COLUMN password SET NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS set_timestamp ON signing_keys;
+
+DROP TABLE IF EXISTS signing_keys;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+  id TEXT PRIMARY KEY NOT NULL,
+  algorithm VARCHAR(10) NOT NULL,
+  private_key BYTEA NOT NULL,
+  status VARCHAR(10) NOT NULL DEFAULT 'pending',
+  activated_at TIMESTAMP WITH TIME ZONE,
+  deactivated_at TIMESTAMP WITH TIME ZONE,
+  retired_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT signing_keys_status_check CHECK (status IN ('pending', 'active', 'verify', 'retired'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_single_active ON signing_keys (status) WHERE status = 'active';
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON signing_keys
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
@@ -0,0 +1,6 @@
+-- The plaintext tokens cannot be recovered, so every refresh token is revoked.
+UPDATE refresh_tokens SET revoked_at = NOW() WHERE revoked_at IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash TYPE VARCHAR(255);
+
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
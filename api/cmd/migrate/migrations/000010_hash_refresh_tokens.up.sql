@@ -0,0 +1,6 @@
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash TYPE TEXT;
+
+-- Existing tokens are re-hashed in place so current sessions stay valid.
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
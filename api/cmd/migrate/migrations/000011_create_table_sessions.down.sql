@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS set_timestamp ON sessions;
+
+DROP TABLE IF EXISTS sessions;
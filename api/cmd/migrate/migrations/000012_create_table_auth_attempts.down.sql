@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS account_lockouts;
+
+DROP TABLE IF EXISTS auth_attempts;
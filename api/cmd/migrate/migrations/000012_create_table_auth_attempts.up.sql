@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS auth_attempts (
+  id BIGSERIAL PRIMARY KEY,
+  key TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_attempts_key_created_at ON auth_attempts (key, created_at);
+
+CREATE TABLE IF NOT EXISTS account_lockouts (
+  user_id TEXT PRIMARY KEY NOT NULL,
+  locked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+  unlock_token_hash VARCHAR(64) NOT NULL UNIQUE,
+  unlock_expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
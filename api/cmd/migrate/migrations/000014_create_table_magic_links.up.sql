@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS magic_links (
+  id TEXT PRIMARY KEY NOT NULL,
+  email CITEXT NOT NULL,
+  -- NULL when the link signs up a new account.
+  user_id TEXT,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  device_hash VARCHAR(64),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_links_email ON magic_links (email);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS set_timestamp ON api_keys;
+
+DROP TABLE IF EXISTS api_keys;
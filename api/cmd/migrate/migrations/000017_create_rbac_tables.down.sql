@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS assign_default_role ON users;
+
+DROP FUNCTION IF EXISTS trigger_assign_default_role();
+
+DROP TABLE IF EXISTS user_roles;
+
+DROP TABLE IF EXISTS role_permissions;
+
+DROP TABLE IF EXISTS permissions;
+
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,68 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id TEXT PRIMARY KEY NOT NULL,
+  name VARCHAR(80) NOT NULL UNIQUE,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  name VARCHAR(80) PRIMARY KEY NOT NULL,
+  description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id TEXT NOT NULL,
+  permission VARCHAR(80) NOT NULL,
+  PRIMARY KEY (role_id, permission),
+  CONSTRAINT fk_role FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  CONSTRAINT fk_permission FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id TEXT NOT NULL,
+  role_id TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (user_id, role_id),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  CONSTRAINT fk_role FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (id, name, description) VALUES
+  ('role_user', 'user', 'Every account'),
+  ('role_admin', 'admin', 'Manages users and roles')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+  ('users:read', 'View any user'),
+  ('users:write', 'Modify, block and delete any user'),
+  ('roles:read', 'View roles and their permissions'),
+  ('roles:write', 'Assign roles to users')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission) VALUES
+  ('role_admin', 'users:read'),
+  ('role_admin', 'users:write'),
+  ('role_admin', 'roles:read'),
+  ('role_admin', 'roles:write')
+ON CONFLICT DO NOTHING;
+
+-- Every account has the user role, including the existing ones.
+INSERT INTO user_roles (user_id, role_id)
+SELECT id, 'role_user' FROM users
+ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION trigger_assign_default_role()
+RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO user_roles (user_id, role_id) VALUES (NEW.id, 'role_user')
+  ON CONFLICT DO NOTHING;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER assign_default_role
+AFTER INSERT ON users
+FOR EACH ROW
+EXECUTE FUNCTION trigger_assign_default_role();
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS admin_actions;
+
+ALTER TABLE users
+  DROP COLUMN IF EXISTS blocked_reason,
+  DROP COLUMN IF EXISTS blocked_at;
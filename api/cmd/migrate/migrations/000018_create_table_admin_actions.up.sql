@@ -0,0 +1,18 @@
+ALTER TABLE users
+  ADD COLUMN blocked_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN blocked_reason TEXT;
+
+CREATE TABLE IF NOT EXISTS admin_actions (
+  id TEXT PRIMARY KEY NOT NULL,
+  admin_id TEXT,
+  target_user_id TEXT,
+  action VARCHAR(40) NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  ip TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_admin FOREIGN KEY (admin_id) REFERENCES users(id) ON DELETE SET NULL,
+  CONSTRAINT fk_target_user FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_admin_actions_target_user_id ON admin_actions (target_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_admin_actions_admin_id ON admin_actions (admin_id, created_at DESC);
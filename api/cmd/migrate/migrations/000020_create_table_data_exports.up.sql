@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+  id TEXT PRIMARY KEY NOT NULL,
+  user_id TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  archive BYTEA,
+  error TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  completed_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports (user_id, created_at DESC);
+
+-- A user has at most one export in progress.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_in_progress ON data_exports (user_id)
+WHERE status IN ('pending', 'processing');
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_pending ON data_exports (created_at)
+WHERE status IN ('pending', 'processing');
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON data_exports
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id TEXT PRIMARY KEY NOT NULL,
+  event VARCHAR(40) NOT NULL,
+  user_id TEXT,
+  actor_id TEXT,
+  ip TEXT,
+  user_agent TEXT NOT NULL DEFAULT '',
+  request_id TEXT NOT NULL DEFAULT '',
+  metadata JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  CONSTRAINT fk_actor FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_event ON audit_events (event, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+  ('audit:read', 'View the audit log of every user')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission) VALUES
+  ('role_admin', 'audit:read')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE name IN ('webhooks:read', 'webhooks:write');
+
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+
+DROP TRIGGER IF EXISTS set_timestamp ON webhook_deliveries;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TRIGGER IF EXISTS set_timestamp ON webhook_endpoints;
+
+DROP TABLE IF EXISTS webhook_endpoints;
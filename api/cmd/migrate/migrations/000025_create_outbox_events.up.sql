@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+  id TEXT PRIMARY KEY NOT NULL,
+  event VARCHAR(80) NOT NULL,
+  aggregate_id TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  locked_until TIMESTAMP WITH TIME ZONE,
+  last_error TEXT,
+  processed_at TIMESTAMP WITH TIME ZONE,
+  failed_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_due ON outbox_events (next_attempt_at)
+WHERE processed_at IS NULL AND failed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_processed_at ON outbox_events (processed_at)
+WHERE processed_at IS NOT NULL;
+
+-- Handlers that are done with an event, so a retry only runs the others.
+CREATE TABLE IF NOT EXISTS outbox_handled (
+  event_id TEXT NOT NULL,
+  handler VARCHAR(80) NOT NULL,
+  handled_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  PRIMARY KEY (event_id, handler),
+  CONSTRAINT fk_event FOREIGN KEY (event_id) REFERENCES outbox_events(id) ON DELETE CASCADE
+);
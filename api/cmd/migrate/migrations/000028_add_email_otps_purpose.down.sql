@@ -0,0 +1 @@
+ALTER TABLE email_otps DROP COLUMN IF EXISTS purpose;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_rate_limit_buckets_full_at;
+ALTER TABLE rate_limit_buckets DROP COLUMN IF EXISTS full_at;
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
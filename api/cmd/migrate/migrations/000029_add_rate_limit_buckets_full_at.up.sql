@@ -0,0 +1,4 @@
+-- full_at is when the bucket has refilled, after which it can be deleted.
+ALTER TABLE rate_limit_buckets ADD COLUMN IF NOT EXISTS full_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+DROP INDEX IF EXISTS idx_rate_limit_buckets_updated_at;
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_full_at ON rate_limit_buckets (full_at);
@@ -0,0 +1,101 @@
+// Command roles manages the roles of users. It is how the first admin is
+// appointed.
+//
+//	roles list
+//	roles assign <email> <role>
+//	roles revoke <email> <role>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/menaguilherme/trigon/configs"
+	"github.com/menaguilherme/trigon/internal/db"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	db, err := db.New(
+		configs.Envs.DB.ConnAddr,
+		configs.Envs.DB.MaxOpenConns,
+		configs.Envs.DB.MaxIdleConns,
+		configs.Envs.DB.MaxIdleTime,
+	)
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	storage := store.NewStorage(db)
+	ctx := context.Background()
+
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "list":
+		err = list(ctx, storage)
+	case "assign":
+		err = withUser(ctx, storage, args, func(userID, role string) error {
+			return storage.Roles.Assign(ctx, userID, role)
+		})
+	case "revoke":
+		err = withUser(ctx, storage, args, func(userID, role string) error {
+			return storage.Roles.Unassign(ctx, userID, role)
+		})
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func list(ctx context.Context, storage store.Storage) error {
+	roles, err := storage.Roles.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPERMISSIONS\tDESCRIPTION")
+	for _, role := range roles {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", role.Name, strings.Join(role.Permissions, ","), role.Description)
+	}
+
+	return w.Flush()
+}
+
+func withUser(ctx context.Context, storage store.Storage, args []string, fn func(userID, role string) error) error {
+	if len(args) != 2 {
+		usage()
+	}
+
+	user, err := storage.Users.GetByEmail(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("user %s: %w", args[0], err)
+	}
+
+	if err := fn(user.ID, args[1]); err != nil {
+		return fmt.Errorf("role %s: %w", args[1], err)
+	}
+
+	fmt.Println("ok")
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: roles list | assign <email> <role> | revoke <email> <role>")
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -1,17 +1,36 @@
 package configs
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port string
-	Env  string
-	DB   DbConfig
-	Auth authConfig
+	Port        string
+	Env         string
+	FrontendURL string
+	// APIURL is the public URL of this API, used in links to it.
+	APIURL string
+	// TrustedProxies lists the addresses, or CIDRs, of the reverse proxies in
+	// front of the API. The client address is only taken from the forwarding
+	// headers of requests they send, as any client can set those headers.
+	TrustedProxies []string
+	DB             DbConfig
+	Auth           authConfig
+	Mail           mailConfig
+	RateLimit      rateLimitConfig
+	DataExport     dataExportConfig
+	Blob           blobConfig
+	Avatar         avatarConfig
+	Webhook        webhookConfig
+	Outbox         outboxConfig
 }
 
 type DbConfig struct {
@@ -22,13 +41,228 @@ type DbConfig struct {
 }
 
 type authConfig struct {
-	Token tokenConfig
+	// RegistrationOpen allows new accounts to sign up.
+	RegistrationOpen  bool
+	Token             tokenConfig
+	EmailVerification emailVerificationConfig
+	PasswordReset     passwordResetConfig
+	MFA               mfaConfig
+	WebAuthn          webAuthnConfig
+	Throttle          throttleConfig
+	MagicLink         magicLinkConfig
+	EmailOTP          emailOTPConfig
+	AccountDeletion   accountDeletionConfig
+	EmailChange       emailChangeConfig
+}
+
+type emailVerificationConfig struct {
+	// Policy controls how accounts whose email is not verified yet are
+	// treated: "allow" lets them in, "restrict" lets them sign in and manage
+	// their account but keeps them out of the routes behind
+	// RequireVerifiedEmailMiddleware, and "deny" refuses to sign them in.
+	Policy string
+	Exp    time.Duration
+}
+
+type passwordResetConfig struct {
+	Exp time.Duration
+}
+
+type magicLinkConfig struct {
+	Exp time.Duration
+	// BindDevice only lets the client that requested a link consume it.
+	BindDevice bool
+}
+
+type emailOTPConfig struct {
+	Exp time.Duration
+	// MaxAttempts wrong codes invalidate the code.
+	MaxAttempts int
+	// ResendCooldown is the minimum time between two codes for an account.
+	ResendCooldown time.Duration
+}
+
+// emailChangeConfig controls email changes. The new address has Exp to
+// confirm the change and the old one CancelExp to cancel or revert it.
+type emailChangeConfig struct {
+	Exp       time.Duration
+	CancelExp time.Duration
+}
+
+// accountDeletionConfig keeps deleted accounts for GracePeriod, during which
+// signing in restores them. Accounts past it are purged every PurgeInterval.
+type accountDeletionConfig struct {
+	GracePeriod   time.Duration
+	PurgeInterval time.Duration
+}
+
+type mfaConfig struct {
+	Issuer string
+	// EncryptionKey is the base64 encoded 32 byte key used to encrypt TOTP
+	// secrets at rest.
+	EncryptionKey string
+	ChallengeExp  time.Duration
+	// MaxAttempts wrong codes burn a challenge, the user has to sign in
+	// again.
+	MaxAttempts int
+}
+
+type webAuthnConfig struct {
+	RPID          string
+	RPDisplayName string
+	// RPOrigins lists the origins allowed to run ceremonies, including the
+	// android:apk-key-hash origin of the mobile app.
+	RPOrigins  []string
+	SessionExp time.Duration
+}
+
+// throttleConfig limits attempts on the authentication endpoints. Limits are
+// counted over a sliding Window and a limit of zero disables the check.
+type throttleConfig struct {
+	Window        time.Duration
+	LoginIPMax    int
+	RefreshIPMax  int
+	RegisterIPMax int
+	// WebAuthnBeginMax limits the passkey ceremonies started per IP, and per
+	// account or user, as each one is stored until it expires.
+	WebAuthnBeginMax int
+	// EmailIPMax and EmailAccountMax limit the requests that email a link or
+	// a code, e.g. a password reset, per IP and per address.
+	EmailIPMax      int
+	EmailAccountMax int
+	UnlockIPMax     int
+	// After DelayAfter failed logins on an account, each further attempt has
+	// to wait DelayBase, doubled on every failure up to DelayMax.
+	DelayAfter int
+	DelayBase  time.Duration
+	DelayMax   time.Duration
+	// LockoutThreshold failed logins within the window lock the account for
+	// LockoutDuration. An unlock link valid for UnlockExp is emailed.
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	UnlockExp        time.Duration
+}
+
+type rateLimitConfig struct {
+	// Backend is "memory", which limits each API instance on its own, or
+	// "postgres", which shares the limits across instances.
+	Backend string
+	// Policies are applied to routes by name.
+	Policies map[string]RateLimitPolicy
+}
+
+// RateLimitPolicy allows Rate requests per Period with bursts of up to Burst
+// requests. It is written "rate/period[:burst]", e.g. "60/1m:20".
+type RateLimitPolicy struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// dataExportConfig controls the personal data exports. A ready archive can
+// be downloaded for Exp and is then deleted. Pending exports are picked up
+// every PollInterval.
+type dataExportConfig struct {
+	Exp          time.Duration
+	PollInterval time.Duration
+}
+
+type blobConfig struct {
+	// Backend is "local", which keeps blobs in LocalDir, or "s3", which keeps
+	// them in an S3 compatible bucket.
+	Backend  string
+	LocalDir string
+	S3       s3Config
+}
+
+type s3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// UsePathStyle is needed by most S3 compatible services, such as MinIO.
+	UsePathStyle bool
+}
+
+// avatarConfig limits uploads to MaxBytes and MaxPixels. Avatar URLs are
+// signed for at least URLExp and stay the same for that long so clients can
+// cache them.
+type avatarConfig struct {
+	MaxBytes  int
+	MaxPixels int
+	URLExp    time.Duration
+}
+
+// webhookConfig controls the delivery of webhooks. Workers send due
+// deliveries, polling every PollInterval. A failed attempt is retried after
+// BackoffBase, doubled on every attempt up to BackoffMax, and the delivery is
+// given up after MaxAttempts. Finished deliveries are kept for Retention.
+type webhookConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+	Retention    time.Duration
+}
+
+// outboxConfig controls the relay of domain events. It claims up to
+// BatchSize events every PollInterval and holds them for Lease. A failed
+// event is retried after BackoffBase, doubled on every attempt up to
+// BackoffMax, and given up after MaxAttempts. Handled events are kept for
+// Retention.
+type outboxConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Lease        time.Duration
+	MaxAttempts  int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+	Retention    time.Duration
+}
+
+type mailConfig struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	FromEmail    string
 }
 
 type tokenConfig struct {
-	Secret string
-	Iss    string
-	Aud    string
+	// Algorithm is HS256, which signs with Secret, or RS256, ES256 or EdDSA,
+	// which sign with the PEM private key stored at PrivateKeyFile.
+	Algorithm      string
+	Secret         string
+	PrivateKeyFile string
+	KeyID          string
+	Iss            string
+	Aud            string
+	KeyRing        keyRingConfig
+	// RevokeAllOnRefreshReuse bumps the refresh token version of a user when
+	// a rotated refresh token is replayed, which signs them out everywhere
+	// instead of only revoking the compromised token family.
+	RevokeAllOnRefreshReuse bool
+}
+
+type keyRingConfig struct {
+	// Enabled signs with the active key of the ring stored in the database
+	// instead of Secret or PrivateKeyFile, which are then only used to verify
+	// tokens issued before the switch.
+	Enabled bool
+	// VerifyLegacySecret keeps HS256 tokens signed with Secret valid.
+	VerifyLegacySecret bool
+	RefreshInterval    time.Duration
+	Rotation           keyRotationConfig
+}
+
+type keyRotationConfig struct {
+	Algorithm    string
+	Interval     time.Duration
+	PublishAhead time.Duration
+	RetireAfter  time.Duration
 }
 
 var Envs = initConfig()
@@ -44,11 +278,72 @@ func initConfig() Config {
 	Port := GetString("PORT", ":8080")
 	env := GetString("ENV", "development")
 
+	frontendURL := GetString("FRONTEND_URL", "http://localhost:8081")
+	apiURL := GetString("API_URL", "http://localhost:8080")
+	trustedProxies := GetStringSlice("TRUSTED_PROXIES", nil)
+
+	jwtAlgorithm := GetString("JWT_ALGORITHM", "HS256")
 	jwtSecret := GetString("JWT_SECRET", "secret")
+	jwtPrivateKeyFile := GetString("JWT_PRIVATE_KEY_FILE", "")
+	jwtKeyID := GetString("JWT_KEY_ID", "")
+
+	revokeAllOnRefreshReuse := GetBool("REFRESH_TOKEN_REUSE_REVOKE_ALL", false)
+
+	keyRingEnabled := GetBool("JWT_KEY_RING", false)
+	keyRingVerifyLegacySecret := GetBool("JWT_KEY_RING_VERIFY_LEGACY_SECRET", false)
+	keyRingRefreshInterval := GetDuration("JWT_KEY_RING_REFRESH_INTERVAL", time.Minute)
+	keyRotationAlgorithm := GetString("JWT_KEY_ROTATION_ALGORITHM", "ES256")
+	keyRotationInterval := GetDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour)
+	keyRotationPublishAhead := GetDuration("JWT_KEY_ROTATION_PUBLISH_AHEAD", 24*time.Hour)
+	keyRotationRetireAfter := GetDuration("JWT_KEY_ROTATION_RETIRE_AFTER", 24*time.Hour)
+
+	emailVerificationPolicy := GetString("EMAIL_VERIFICATION_POLICY", "restrict")
+	emailVerificationExp := GetDuration("EMAIL_VERIFICATION_EXP", 24*time.Hour)
+	passwordResetExp := GetDuration("PASSWORD_RESET_EXP", time.Hour)
+
+	registrationOpen := GetBool("REGISTRATION_OPEN", true)
+
+	magicLinkExp := GetDuration("MAGIC_LINK_EXP", 15*time.Minute)
+	magicLinkBindDevice := GetBool("MAGIC_LINK_BIND_DEVICE", true)
+
+	emailOTPExp := GetDuration("EMAIL_OTP_EXP", 10*time.Minute)
+	emailOTPMaxAttempts := GetInt("EMAIL_OTP_MAX_ATTEMPTS", 5)
+	emailOTPResendCooldown := GetDuration("EMAIL_OTP_RESEND_COOLDOWN", time.Minute)
+
+	emailChangeExp := GetDuration("EMAIL_CHANGE_EXP", 24*time.Hour)
+	emailChangeCancelExp := GetDuration("EMAIL_CHANGE_CANCEL_EXP", 7*24*time.Hour)
+
+	accountDeletionGracePeriod := GetDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour)
+	accountPurgeInterval := GetDuration("ACCOUNT_PURGE_INTERVAL", time.Hour)
+
+	mfaEncryptionKey := GetString("MFA_ENCRYPTION_KEY", "")
+	mfaChallengeExp := GetDuration("MFA_CHALLENGE_EXP", 5*time.Minute)
+	mfaMaxAttempts := GetInt("MFA_MAX_ATTEMPTS", 5)
+
+	throttleWindow := GetDuration("THROTTLE_WINDOW", 15*time.Minute)
+	throttleLoginIPMax := GetInt("THROTTLE_LOGIN_IP_MAX", 50)
+	throttleRefreshIPMax := GetInt("THROTTLE_REFRESH_IP_MAX", 120)
+	throttleRegisterIPMax := GetInt("THROTTLE_REGISTER_IP_MAX", 10)
+	throttleWebAuthnBeginMax := GetInt("THROTTLE_WEBAUTHN_BEGIN_MAX", 30)
+	throttleEmailIPMax := GetInt("THROTTLE_EMAIL_IP_MAX", 20)
+	throttleEmailAccountMax := GetInt("THROTTLE_EMAIL_ACCOUNT_MAX", 5)
+	throttleUnlockIPMax := GetInt("THROTTLE_UNLOCK_IP_MAX", 20)
+	throttleDelayAfter := GetInt("THROTTLE_DELAY_AFTER", 3)
+	throttleDelayBase := GetDuration("THROTTLE_DELAY_BASE", time.Second)
+	throttleDelayMax := GetDuration("THROTTLE_DELAY_MAX", time.Minute)
+	lockoutThreshold := GetInt("LOCKOUT_THRESHOLD", 10)
+	lockoutDuration := GetDuration("LOCKOUT_DURATION", 30*time.Minute)
+	lockoutUnlockExp := GetDuration("LOCKOUT_UNLOCK_EXP", time.Hour)
+
+	webAuthnRPID := GetString("WEBAUTHN_RP_ID", "localhost")
+	webAuthnRPOrigins := GetStringSlice("WEBAUTHN_RP_ORIGINS", []string{frontendURL})
 
 	return Config{
-		Port: Port,
-		Env:  env,
+		Port:           Port,
+		Env:            env,
+		FrontendURL:    frontendURL,
+		APIURL:         apiURL,
+		TrustedProxies: trustedProxies,
 		DB: DbConfig{
 			ConnAddr:     connAddr,
 			MaxOpenConns: maxOpenConns,
@@ -56,14 +351,156 @@ func initConfig() Config {
 			MaxIdleTime:  maxIdleTime,
 		},
 		Auth: authConfig{
+			RegistrationOpen: registrationOpen,
 			Token: tokenConfig{
-				Secret: jwtSecret,
-				Iss:    "trigon-api",
-				Aud:    "trigon",
+				Algorithm:               jwtAlgorithm,
+				Secret:                  jwtSecret,
+				PrivateKeyFile:          jwtPrivateKeyFile,
+				KeyID:                   jwtKeyID,
+				Iss:                     "trigon-api",
+				Aud:                     "trigon",
+				RevokeAllOnRefreshReuse: revokeAllOnRefreshReuse,
+				KeyRing: keyRingConfig{
+					Enabled:            keyRingEnabled,
+					VerifyLegacySecret: keyRingVerifyLegacySecret,
+					RefreshInterval:    keyRingRefreshInterval,
+					Rotation: keyRotationConfig{
+						Algorithm:    keyRotationAlgorithm,
+						Interval:     keyRotationInterval,
+						PublishAhead: keyRotationPublishAhead,
+						RetireAfter:  keyRotationRetireAfter,
+					},
+				},
+			},
+			EmailVerification: emailVerificationConfig{
+				Policy: emailVerificationPolicy,
+				Exp:    emailVerificationExp,
+			},
+			PasswordReset: passwordResetConfig{
+				Exp: passwordResetExp,
+			},
+			MFA: mfaConfig{
+				Issuer:        "Trigon",
+				EncryptionKey: mfaEncryptionKey,
+				ChallengeExp:  mfaChallengeExp,
+				MaxAttempts:   mfaMaxAttempts,
+			},
+			WebAuthn: webAuthnConfig{
+				RPID:          webAuthnRPID,
+				RPDisplayName: "Trigon",
+				RPOrigins:     webAuthnRPOrigins,
+				SessionExp:    5 * time.Minute,
+			},
+			MagicLink: magicLinkConfig{
+				Exp:        magicLinkExp,
+				BindDevice: magicLinkBindDevice,
+			},
+			EmailOTP: emailOTPConfig{
+				Exp:            emailOTPExp,
+				MaxAttempts:    emailOTPMaxAttempts,
+				ResendCooldown: emailOTPResendCooldown,
+			},
+			EmailChange: emailChangeConfig{
+				Exp:       emailChangeExp,
+				CancelExp: emailChangeCancelExp,
 			},
+			AccountDeletion: accountDeletionConfig{
+				GracePeriod:   accountDeletionGracePeriod,
+				PurgeInterval: accountPurgeInterval,
+			},
+			Throttle: throttleConfig{
+				Window:           throttleWindow,
+				LoginIPMax:       throttleLoginIPMax,
+				RefreshIPMax:     throttleRefreshIPMax,
+				RegisterIPMax:    throttleRegisterIPMax,
+				WebAuthnBeginMax: throttleWebAuthnBeginMax,
+				EmailIPMax:       throttleEmailIPMax,
+				EmailAccountMax:  throttleEmailAccountMax,
+				UnlockIPMax:      throttleUnlockIPMax,
+				DelayAfter:       throttleDelayAfter,
+				DelayBase:        throttleDelayBase,
+				DelayMax:         throttleDelayMax,
+				LockoutThreshold: lockoutThreshold,
+				LockoutDuration:  lockoutDuration,
+				UnlockExp:        lockoutUnlockExp,
+			},
+		},
+		RateLimit: rateLimitConfig{
+			Backend: GetString("RATE_LIMIT_BACKEND", "memory"),
+			Policies: GetRateLimitPolicies("RATE_LIMIT_POLICIES", map[string]RateLimitPolicy{
+				"auth": {Rate: 30, Period: time.Minute, Burst: 10},
+				"api":  {Rate: 300, Period: time.Minute, Burst: 60},
+			}),
+		},
+		DataExport: dataExportConfig{
+			Exp:          GetDuration("DATA_EXPORT_EXP", 48*time.Hour),
+			PollInterval: GetDuration("DATA_EXPORT_POLL_INTERVAL", 10*time.Second),
+		},
+		Blob: blobConfig{
+			Backend:  GetString("BLOB_BACKEND", "local"),
+			LocalDir: GetString("BLOB_LOCAL_DIR", "./data/blobs"),
+			S3: s3Config{
+				Endpoint:        GetString("S3_ENDPOINT", ""),
+				Region:          GetString("S3_REGION", "us-east-1"),
+				Bucket:          GetString("S3_BUCKET", ""),
+				AccessKeyID:     GetString("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: GetString("S3_SECRET_ACCESS_KEY", ""),
+				UsePathStyle:    GetBool("S3_USE_PATH_STYLE", false),
+			},
+		},
+		Avatar: avatarConfig{
+			MaxBytes:  GetInt("AVATAR_MAX_BYTES", 5<<20),
+			MaxPixels: GetInt("AVATAR_MAX_PIXELS", 2048*2048),
+			URLExp:    GetDuration("AVATAR_URL_EXP", 24*time.Hour),
+		},
+		Webhook: webhookConfig{
+			Workers:      GetInt("WEBHOOK_WORKERS", 2),
+			PollInterval: GetDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+			Timeout:      GetDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			MaxAttempts:  GetInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			BackoffBase:  GetDuration("WEBHOOK_BACKOFF_BASE", 30*time.Second),
+			BackoffMax:   GetDuration("WEBHOOK_BACKOFF_MAX", 6*time.Hour),
+			Retention:    GetDuration("WEBHOOK_RETENTION", 720*time.Hour),
 		},
+		Outbox: outboxConfig{
+			PollInterval: GetDuration("OUTBOX_POLL_INTERVAL", 2*time.Second),
+			BatchSize:    GetInt("OUTBOX_BATCH_SIZE", 50),
+			Lease:        GetDuration("OUTBOX_LEASE", time.Minute),
+			MaxAttempts:  GetInt("OUTBOX_MAX_ATTEMPTS", 10),
+			BackoffBase:  GetDuration("OUTBOX_BACKOFF_BASE", 10*time.Second),
+			BackoffMax:   GetDuration("OUTBOX_BACKOFF_MAX", time.Hour),
+			Retention:    GetDuration("OUTBOX_RETENTION", 168*time.Hour),
+		},
+		Mail: mailConfig{
+			SMTPHost:     GetString("SMTP_HOST", ""),
+			SMTPPort:     GetInt("SMTP_PORT", 587),
+			SMTPUsername: GetString("SMTP_USERNAME", ""),
+			SMTPPassword: GetString("SMTP_PASSWORD", ""),
+			FromEmail:    GetString("MAIL_FROM_EMAIL", "Trigon <no-reply@trigon.app>"),
+		},
+	}
+
+}
+
+// EncryptionKey returns the 32 byte key used to encrypt secrets at rest.
+// Outside of production a key derived from the JWT secret is used when
+// MFA_ENCRYPTION_KEY is not set.
+func (c Config) EncryptionKey() ([]byte, error) {
+	if c.Auth.MFA.EncryptionKey == "" {
+		if c.Env == "production" {
+			return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be set in production")
+		}
+
+		key := sha256.Sum256([]byte(c.Auth.Token.Secret))
+		return key[:], nil
 	}
 
+	key, err := base64.StdEncoding.DecodeString(c.Auth.MFA.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("decoding MFA_ENCRYPTION_KEY: %w", err)
+	}
+
+	return key, nil
 }
 
 func GetString(key, fallback string) string {
@@ -102,3 +539,91 @@ func GetBool(key string, fallback bool) bool {
 
 	return boolVal
 }
+
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return duration
+}
+
+// GetStringSlice reads a comma separated list.
+func GetStringSlice(key string, fallback []string) []string {
+	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return fallback
+	}
+
+	var values []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// GetRateLimitPolicies reads comma separated "name=rate/period[:burst]"
+// policies, e.g. "auth=30/1m:10,api=300/1m". Policies that cannot be parsed
+// are skipped, and policies missing from the variable keep their fallback.
+func GetRateLimitPolicies(key string, fallback map[string]RateLimitPolicy) map[string]RateLimitPolicy {
+	policies := make(map[string]RateLimitPolicy, len(fallback))
+	for name, policy := range fallback {
+		policies[name] = policy
+	}
+
+	for _, entry := range GetStringSlice(key, nil) {
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+
+		policy, err := parseRateLimitPolicy(spec)
+		if err != nil {
+			continue
+		}
+
+		policies[strings.TrimSpace(name)] = policy
+	}
+
+	return policies
+}
+
+func parseRateLimitPolicy(spec string) (RateLimitPolicy, error) {
+	var policy RateLimitPolicy
+
+	spec, burst, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+	rate, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return policy, fmt.Errorf("invalid rate limit policy %q", spec)
+	}
+
+	var err error
+	if policy.Rate, err = strconv.Atoi(rate); err != nil {
+		return policy, err
+	}
+
+	if policy.Period, err = time.ParseDuration(period); err != nil {
+		return policy, err
+	}
+
+	if hasBurst {
+		if policy.Burst, err = strconv.Atoi(burst); err != nil {
+			return policy, err
+		}
+	}
+
+	if policy.Rate <= 0 || policy.Period <= 0 || policy.Burst < 0 {
+		return policy, fmt.Errorf("invalid rate limit policy %q", spec)
+	}
+
+	return policy, nil
+}
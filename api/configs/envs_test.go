@@ -0,0 +1,76 @@
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RateLimitPolicy
+		wantErr bool
+	}{
+		{spec: "30/1m", want: RateLimitPolicy{Rate: 30, Period: time.Minute}},
+		{spec: "30/1m:10", want: RateLimitPolicy{Rate: 30, Period: time.Minute, Burst: 10}},
+		{spec: " 5/24h:0 ", want: RateLimitPolicy{Rate: 5, Period: 24 * time.Hour}},
+		{spec: "1/500ms", want: RateLimitPolicy{Rate: 1, Period: 500 * time.Millisecond}},
+		{spec: "", wantErr: true},
+		{spec: "30", wantErr: true},
+		{spec: "30/", wantErr: true},
+		{spec: "/1m", wantErr: true},
+		{spec: "x/1m", wantErr: true},
+		{spec: "30/1", wantErr: true},
+		{spec: "30/1m:", wantErr: true},
+		{spec: "30/1m:x", wantErr: true},
+		{spec: "0/1m", wantErr: true},
+		{spec: "-1/1m", wantErr: true},
+		{spec: "30/0s", wantErr: true},
+		{spec: "30/-1m", wantErr: true},
+		{spec: "30/1m:-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRateLimitPolicy(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRateLimitPolicies(t *testing.T) {
+	t.Setenv("TEST_RATE_LIMIT_POLICIES", "auth=5/1m:2, api=bad ,broken,upload=10/1h")
+
+	fallback := map[string]RateLimitPolicy{
+		"auth": {Rate: 30, Period: time.Minute, Burst: 10},
+		"api":  {Rate: 300, Period: time.Minute},
+	}
+
+	got := GetRateLimitPolicies("TEST_RATE_LIMIT_POLICIES", fallback)
+
+	want := map[string]RateLimitPolicy{
+		"auth":   {Rate: 5, Period: time.Minute, Burst: 2},
+		"api":    {Rate: 300, Period: time.Minute},
+		"upload": {Rate: 10, Period: time.Hour},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for name, policy := range want {
+		if got[name] != policy {
+			t.Errorf("%s: got %+v, want %+v", name, got[name], policy)
+		}
+	}
+
+	if fallback["auth"].Rate != 30 {
+		t.Error("the fallback was modified")
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// SigningMethodForKey returns the JWT algorithm matching the private key:
+// RS256 for RSA, ES256 for P-256 and EdDSA for Ed25519 keys.
+func SigningMethodForKey(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa keys must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("only P-256 ecdsa keys are supported")
+		}
+		return jwt.SigningMethodES256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// ParsePrivateKey decodes a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unable to parse private key")
+}
+
+// KeyAuthenticator signs tokens with an asymmetric private key and puts its
+// key ID in the token header, so other services can verify them with the
+// public key published in the JWKS.
+type KeyAuthenticator struct {
+	method jwt.SigningMethod
+	kid    string
+	key    crypto.Signer
+	aud    string
+	iss    string
+}
+
+// NewKeyAuthenticator builds an authenticator for the key. When kid is empty
+// the RFC 7638 thumbprint of the public key is used.
+func NewKeyAuthenticator(key crypto.Signer, kid, aud, iss string) (*KeyAuthenticator, error) {
+	method, err := SigningMethodForKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if kid == "" {
+		kid, err = Thumbprint(key.Public())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &KeyAuthenticator{method, kid, key, aud, iss}, nil
+}
+
+func (a *KeyAuthenticator) Algorithm() string {
+	return a.method.Alg()
+}
+
+func (a *KeyAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(a.method, claims)
+	token.Header["kid"] = a.kid
+
+	return token.SignedString(a.key)
+}
+
+func (a *KeyAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	return a.ValidateTokenFor(token, a.aud)
+}
+
+func (a *KeyAuthenticator) ValidateTokenFor(token, aud string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		if kid, _ := t.Header["kid"].(string); kid != a.kid {
+			return nil, ErrUnknownKeyID
+		}
+
+		return a.key.Public(), nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(aud),
+		jwt.WithIssuer(a.iss),
+		jwt.WithValidMethods([]string{a.method.Alg()}),
+	)
+}
+
+func (a *KeyAuthenticator) JWKS() JWKS {
+	jwk, err := NewJWK(a.kid, a.method.Alg(), a.key.Public())
+	if err != nil {
+		return JWKS{Keys: []JWK{}}
+	}
+
+	return JWKS{Keys: []JWK{jwk}}
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testAlgorithms = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+func TestKeyAuthenticator(t *testing.T) {
+	for _, alg := range testAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GeneratePrivateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := NewKeyAuthenticator(key, "", "trigon", "trigon")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if a.Algorithm() != alg {
+				t.Errorf("got %s, want %s", a.Algorithm(), alg)
+			}
+
+			token, err := a.GenerateToken(testClaims("trigon"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := a.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			thumbprint, err := Thumbprint(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if parsed.Header["kid"] != thumbprint {
+				t.Errorf("got kid %v, want the thumbprint %s", parsed.Header["kid"], thumbprint)
+			}
+
+			jwks := a.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != thumbprint || jwks.Keys[0].Alg != alg || jwks.Keys[0].Use != "sig" {
+				t.Errorf("got %+v", jwks)
+			}
+
+			other, err := GeneratePrivateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			impostor, err := NewKeyAuthenticator(other, thumbprint, "trigon", "trigon")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			forged, err := impostor.GenerateToken(testClaims("trigon"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.ValidateToken(forged); err == nil {
+				t.Error("a token signed by another key passed")
+			}
+
+			renamed, err := NewKeyAuthenticator(key, "other", "trigon", "trigon")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err = renamed.GenerateToken(testClaims("trigon"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := a.ValidateToken(token); !errors.Is(err, ErrUnknownKeyID) {
+				t.Errorf("got %v, want %v", err, ErrUnknownKeyID)
+			}
+		})
+	}
+}
+
+func TestMarshalPrivateKey(t *testing.T) {
+	for _, alg := range testAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GeneratePrivateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			der, err := MarshalPrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := UnmarshalPrivateKey(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !parsed.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Error("the key changed")
+			}
+		})
+	}
+
+	if _, err := GeneratePrivateKey(jwt.SigningMethodHS256.Alg()); err == nil {
+		t.Error("generated a key for HS256")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8 := func(key crypto.Signer) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		key   crypto.Signer
+	}{
+		{name: "PKCS#8 RSA", block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(rsaKey)}, key: rsaKey},
+		{name: "PKCS#8 ECDSA", block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(ecKey)}, key: ecKey},
+		{name: "PKCS#8 Ed25519", block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(edKey)}, key: edKey},
+		{name: "PKCS#1", block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, key: rsaKey},
+		{name: "SEC 1", block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, key: ecKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(pem.EncodeToMemory(tt.block))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key.Public()) {
+				t.Error("parsed another key")
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("parsed a file without a PEM block")
+	}
+
+	if _, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")})); err == nil {
+		t.Error("parsed a block that is not a key")
+	}
+}
+
+func TestSigningMethodForKey(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]crypto.Signer{"RSA 1024": weak, "P-384": p384} {
+		if _, err := SigningMethodForKey(key); err == nil {
+			t.Errorf("%s key was accepted", name)
+		}
+
+		if _, err := NewSigningKey("k1", KeyStatusActive, key); err == nil {
+			t.Errorf("%s key was accepted by the key ring", name)
+		}
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	for _, alg := range testAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			key, err := GeneratePrivateKey(alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := NewKeyAuthenticator(key, "k1", "trigon", "trigon")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := a.GenerateToken(testClaims("trigon"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A verifier only has the published key.
+			public := publicKeyFromJWK(t, a.JWKS().Keys[0])
+
+			_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return public, nil },
+				jwt.WithValidMethods([]string{alg}),
+			)
+			if err != nil {
+				t.Errorf("the token did not verify with the published key: %v", err)
+			}
+		})
+	}
+}
+
+func publicKeyFromJWK(t *testing.T, jwk JWK) crypto.PublicKey {
+	t.Helper()
+
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	bigInt := func(s string) *big.Int {
+		return new(big.Int).SetBytes(decode(s))
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{N: bigInt(jwk.N), E: int(bigInt(jwk.E).Int64())}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			t.Fatalf("got curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: bigInt(jwk.X), Y: bigInt(jwk.Y)}
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			t.Fatalf("got curve %s", jwk.Crv)
+		}
+		return ed25519.PublicKey(decode(jwk.X))
+	default:
+		t.Fatalf("got key type %s", jwk.Kty)
+		return nil
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 8037, Appendix A.3.
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Thumbprint(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
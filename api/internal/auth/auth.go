@@ -4,5 +4,11 @@ import "github.com/golang-jwt/jwt/v5"
 
 type Authenticator interface {
 	GenerateToken(claims jwt.Claims) (string, error)
+	// ValidateToken validates a token for the audience of the authenticator,
+	// i.e. an access token.
 	ValidateToken(token string) (*jwt.Token, error)
+	// ValidateTokenFor validates a token for another audience. Tokens only
+	// meant for the issuer itself are given their own audience so that the
+	// verifiers of access tokens, who share the keys, reject them.
+	ValidateTokenFor(token, aud string) (*jwt.Token, error)
 }
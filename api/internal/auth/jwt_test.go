@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testClaims(aud string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "usr_1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"iss": "trigon",
+		"aud": aud,
+	}
+}
+
+func TestValidateTokenFor(t *testing.T) {
+	a := NewJWTAuthenticator("secret", "trigon", "trigon")
+
+	access, err := a.GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internal, err := a.GenerateToken(testClaims("trigon:mfa_challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.ValidateToken(access); err != nil {
+		t.Errorf("access token: %v", err)
+	}
+
+	if _, err := a.ValidateToken(internal); err == nil {
+		t.Error("a token for another audience passed for an access token")
+	}
+
+	if _, err := a.ValidateTokenFor(internal, "trigon:mfa_challenge"); err != nil {
+		t.Errorf("internal token: %v", err)
+	}
+
+	if _, err := a.ValidateTokenFor(access, "trigon:mfa_challenge"); err == nil {
+		t.Error("an access token passed for another audience")
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing key statuses. A key is published as pending before it signs
+// anything so verifiers that cache the JWKS already know it when it becomes
+// active. Once replaced, the previous active key keeps verifying until the
+// tokens it signed have expired and it is retired.
+const (
+	KeyStatusPending = "pending"
+	KeyStatusActive  = "active"
+	KeyStatusVerify  = "verify"
+	KeyStatusRetired = "retired"
+)
+
+var ErrNoActiveKey = errors.New("key ring has no active signing key")
+
+type SigningKey struct {
+	ID     string
+	Status string
+	method jwt.SigningMethod
+	signer crypto.Signer
+	secret []byte
+}
+
+// NewSigningKey wraps an asymmetric private key.
+func NewSigningKey(id, status string, signer crypto.Signer) (*SigningKey, error) {
+	method, err := SigningMethodForKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{ID: id, Status: status, method: method, signer: signer}, nil
+}
+
+// NewLegacySecretKey wraps an HS256 shared secret. It lets a ring keep
+// verifying tokens issued before asymmetric keys were introduced. Such
+// tokens carry no kid, so the key is registered under the empty ID.
+func NewLegacySecretKey(secret string) *SigningKey {
+	return &SigningKey{Status: KeyStatusVerify, method: jwt.SigningMethodHS256, secret: []byte(secret)}
+}
+
+func (k *SigningKey) Algorithm() string {
+	return k.method.Alg()
+}
+
+func (k *SigningKey) signingKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.signer
+}
+
+func (k *SigningKey) verifyingKey() any {
+	if k.secret != nil {
+		return k.secret
+	}
+	return k.signer.Public()
+}
+
+// GeneratePrivateKey creates a private key for RS256, ES256 or EdDSA.
+func GeneratePrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case jwt.SigningMethodES256.Alg():
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+}
+
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+func UnmarshalPrivateKey(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// KeyRing signs with its single active key and verifies with any key it
+// holds that is not retired, selected by the kid header. Keys can be swapped
+// at runtime with Replace.
+type KeyRing struct {
+	aud string
+	iss string
+
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func NewKeyRing(aud, iss string, keys []*SigningKey) (*KeyRing, error) {
+	ring := &KeyRing{aud: aud, iss: iss}
+	if err := ring.Replace(keys); err != nil {
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+// Replace swaps the keys of the ring. Exactly one key must be active.
+func (r *KeyRing) Replace(keys []*SigningKey) error {
+	var active *SigningKey
+	byID := make(map[string]*SigningKey, len(keys))
+
+	for _, key := range keys {
+		switch key.Status {
+		case KeyStatusRetired:
+			continue
+		case KeyStatusActive:
+			if active != nil {
+				return fmt.Errorf("key ring has more than one active key: %s and %s", active.ID, key.ID)
+			}
+			active = key
+		}
+
+		byID[key.ID] = key
+	}
+
+	if active == nil {
+		return ErrNoActiveKey
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.active = active
+	r.keys = byID
+
+	return nil
+}
+
+func (r *KeyRing) GenerateToken(claims jwt.Claims) (string, error) {
+	r.mu.RLock()
+	active := r.active
+	r.mu.RUnlock()
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.ID
+
+	return token.SignedString(active.signingKey())
+}
+
+func (r *KeyRing) ValidateToken(token string) (*jwt.Token, error) {
+	return r.ValidateTokenFor(token, r.aud)
+}
+
+func (r *KeyRing) ValidateTokenFor(token, aud string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		r.mu.RLock()
+		key, ok := r.keys[kid]
+		r.mu.RUnlock()
+
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+
+		if t.Method.Alg() != key.Algorithm() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		return key.verifyingKey(), nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(aud),
+		jwt.WithIssuer(r.iss),
+		jwt.WithValidMethods([]string{
+			jwt.SigningMethodRS256.Alg(),
+			jwt.SigningMethodES256.Alg(),
+			jwt.SigningMethodEdDSA.Alg(),
+			jwt.SigningMethodHS256.Alg(),
+		}),
+	)
+}
+
+// JWKS publishes the public part of every asymmetric key that is not retired,
+// including pending keys that do not sign yet.
+func (r *KeyRing) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if key.signer == nil {
+			continue
+		}
+
+		jwk, err := NewJWK(key.ID, key.Algorithm(), key.signer.Public())
+		if err != nil {
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+
+	return jwks
+}
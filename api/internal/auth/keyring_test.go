@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestSigningKey(t *testing.T, id, status, algorithm string) *SigningKey {
+	t.Helper()
+
+	signer, err := GeneratePrivateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewSigningKey(id, status, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func newTestKeyRing(t *testing.T, keys ...*SigningKey) *KeyRing {
+	t.Helper()
+
+	ring, err := NewKeyRing("trigon", "trigon", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ring
+}
+
+// signWith signs a token with the key as if it were the active key of a
+// ring.
+func signWith(t *testing.T, key *SigningKey) string {
+	t.Helper()
+
+	key = &SigningKey{ID: key.ID, Status: KeyStatusActive, method: key.method, signer: key.signer, secret: key.secret}
+
+	token, err := newTestKeyRing(t, key).GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestKeyRingSelectsKeyByID(t *testing.T) {
+	previous := newTestSigningKey(t, "k1", KeyStatusVerify, jwt.SigningMethodEdDSA.Alg())
+	active := newTestSigningKey(t, "k2", KeyStatusActive, jwt.SigningMethodES256.Alg())
+	pending := newTestSigningKey(t, "k3", KeyStatusPending, jwt.SigningMethodRS256.Alg())
+	ring := newTestKeyRing(t, previous, active, pending)
+
+	token, err := ring.GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ring.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Header["kid"] != "k2" || parsed.Method.Alg() != jwt.SigningMethodES256.Alg() {
+		t.Errorf("signed with %v %v, want the active key", parsed.Header["kid"], parsed.Method.Alg())
+	}
+
+	for _, key := range []*SigningKey{previous, pending} {
+		if _, err := ring.ValidateToken(signWith(t, key)); err != nil {
+			t.Errorf("%s key %s: %v", key.Status, key.ID, err)
+		}
+	}
+
+	unknown := newTestSigningKey(t, "k4", KeyStatusActive, jwt.SigningMethodEdDSA.Alg())
+	if _, err := ring.ValidateToken(signWith(t, unknown)); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("got %v, want %v", err, ErrUnknownKeyID)
+	}
+
+	// A token that claims the ID of a known key but was signed by another one.
+	impostor := newTestSigningKey(t, "k1", KeyStatusActive, jwt.SigningMethodEdDSA.Alg())
+	if _, err := ring.ValidateToken(signWith(t, impostor)); err == nil {
+		t.Error("a token signed by another key passed")
+	}
+}
+
+func TestKeyRingLegacySecret(t *testing.T) {
+	active := newTestSigningKey(t, "k1", KeyStatusActive, jwt.SigningMethodEdDSA.Alg())
+	legacy, err := NewJWTAuthenticator("secret", "trigon", "trigon").GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := newTestKeyRing(t, active, NewLegacySecretKey("secret"))
+	if _, err := ring.ValidateToken(legacy); err != nil {
+		t.Errorf("a legacy HS256 token did not pass: %v", err)
+	}
+
+	token, err := ring.GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ring.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Method.Alg() != jwt.SigningMethodEdDSA.Alg() {
+		t.Errorf("signed with %s, the legacy secret must only verify", parsed.Method.Alg())
+	}
+
+	other, err := NewJWTAuthenticator("other", "trigon", "trigon").GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.ValidateToken(other); err == nil {
+		t.Error("an HS256 token signed with another secret passed")
+	}
+
+	if _, err := newTestKeyRing(t, active).ValidateToken(legacy); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("without the legacy secret got %v, want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestKeyRingRejectsAlgorithmConfusion(t *testing.T) {
+	active := newTestSigningKey(t, "k1", KeyStatusActive, jwt.SigningMethodEdDSA.Alg())
+	ring := newTestKeyRing(t, active)
+
+	// The public key is published, so an attacker can use it as an HMAC
+	// secret and hope the verifier picks the key by kid alone.
+	public := active.signer.Public().(ed25519.PublicKey)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims("trigon"))
+	token.Header["kid"] = "k1"
+
+	forged, err := token.SignedString([]byte(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.ValidateToken(forged); err == nil {
+		t.Error("an HS256 token signed with the public key passed")
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims("trigon"))
+	unsigned.Header["kid"] = "k1"
+
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.ValidateToken(none); err == nil {
+		t.Error("an unsigned token passed")
+	}
+}
+
+func TestKeyRingReplace(t *testing.T) {
+	first := newTestSigningKey(t, "k1", KeyStatusActive, jwt.SigningMethodEdDSA.Alg())
+	ring := newTestKeyRing(t, first)
+	issued := signWith(t, first)
+
+	second := newTestSigningKey(t, "k2", KeyStatusActive, jwt.SigningMethodES256.Alg())
+
+	if err := ring.Replace([]*SigningKey{first, second}); err == nil {
+		t.Error("two active keys were accepted")
+	}
+
+	pending := newTestSigningKey(t, "k2", KeyStatusPending, jwt.SigningMethodES256.Alg())
+	if err := ring.Replace([]*SigningKey{pending}); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("got %v, want %v", err, ErrNoActiveKey)
+	}
+
+	if _, err := NewKeyRing("trigon", "trigon", nil); !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("empty ring got %v, want %v", err, ErrNoActiveKey)
+	}
+
+	// A failed replace keeps the previous keys.
+	if _, err := ring.ValidateToken(issued); err != nil {
+		t.Fatalf("after a failed replace: %v", err)
+	}
+
+	verify := &SigningKey{ID: first.ID, Status: KeyStatusVerify, method: first.method, signer: first.signer}
+	if err := ring.Replace([]*SigningKey{verify, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.ValidateToken(issued); err != nil {
+		t.Errorf("a token of the previous key did not pass: %v", err)
+	}
+
+	token, err := ring.GenerateToken(testClaims("trigon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ring.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Header["kid"] != "k2" {
+		t.Errorf("signed with %v, want k2", parsed.Header["kid"])
+	}
+
+	retired := &SigningKey{ID: first.ID, Status: KeyStatusRetired, method: first.method, signer: first.signer}
+	if err := ring.Replace([]*SigningKey{retired, second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.ValidateToken(issued); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("a token of a retired key got %v, want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestKeyRingJWKS(t *testing.T) {
+	ring := newTestKeyRing(t,
+		newTestSigningKey(t, "k1", KeyStatusRetired, jwt.SigningMethodEdDSA.Alg()),
+		newTestSigningKey(t, "k2", KeyStatusVerify, jwt.SigningMethodES256.Alg()),
+		newTestSigningKey(t, "k3", KeyStatusActive, jwt.SigningMethodEdDSA.Alg()),
+		newTestSigningKey(t, "k4", KeyStatusPending, jwt.SigningMethodES256.Alg()),
+		NewLegacySecretKey("secret"),
+	)
+
+	jwks := ring.JWKS()
+
+	var kids []string
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty == "oct" || jwk.N == "" && jwk.X == "" {
+			t.Errorf("key %q has no public part", jwk.Kid)
+		}
+		kids = append(kids, jwk.Kid)
+	}
+
+	want := []string{"k2", "k3", "k4"}
+	if len(kids) != len(want) {
+		t.Fatalf("got %v, want %v", kids, want)
+	}
+
+	for i := range want {
+		if kids[i] != want[i] {
+			t.Fatalf("got %v, want %v", kids, want)
+		}
+	}
+}
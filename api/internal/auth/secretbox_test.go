@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newTestSecretBox(t *testing.T, fill byte) *SecretBox {
+	t.Helper()
+
+	box, err := NewSecretBox(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return box
+}
+
+func TestSecretBox(t *testing.T) {
+	box := newTestSecretBox(t, 1)
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+
+	sealed, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("the plaintext is in the clear")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("got %q, want %q", opened, plaintext)
+	}
+
+	again, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(again, sealed) {
+		t.Error("sealing twice gave the same ciphertext, the nonce was reused")
+	}
+}
+
+func TestSecretBoxOpenFails(t *testing.T) {
+	box := newTestSecretBox(t, 1)
+
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range sealed {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+
+		if _, err := box.Open(tampered); err == nil {
+			t.Fatalf("byte %d was changed and the box opened", i)
+		}
+	}
+
+	if _, err := newTestSecretBox(t, 2).Open(sealed); err == nil {
+		t.Error("opened with another key")
+	}
+
+	if _, err := box.Open(sealed[:len(sealed)-1]); err == nil {
+		t.Error("opened a truncated ciphertext")
+	}
+
+	if _, err := box.Open(sealed[:4]); !errors.Is(err, ErrCiphertextTooShort) {
+		t.Errorf("got %v, want %v", err, ErrCiphertextTooShort)
+	}
+}
+
+func TestNewSecretBoxKeySize(t *testing.T) {
+	for _, size := range []int{0, 16, 24, 31, 33, 64} {
+		if _, err := NewSecretBox(make([]byte, size)); err == nil {
+			t.Errorf("a %d byte key was accepted", size)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1. The RFC gives 8 digits, the last 6 are
+	// the 6 digit codes.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := tt.want[len(tt.want)-TOTPDigits:]; got != want {
+			t.Errorf("at %d: got %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestTOTPCodeSecretEncoding(t *testing.T) {
+	want, err := TOTPCode(rfc6238Secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Apps show the secret in lower case, and some keep the padding.
+	for _, secret := range []string{strings.ToLower(rfc6238Secret), rfc6238Secret + "===="} {
+		if got, err := TOTPCode(secret, 1); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", secret, got, err)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("an invalid secret was accepted")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+
+	code := func(step int64) string {
+		code, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: code(step), wantStep: step, wantOK: true},
+		{name: "previous step", code: code(step - 1), wantStep: step - 1, wantOK: true},
+		{name: "next step", code: code(step + 1), wantStep: step + 1, wantOK: true},
+		{name: "with spaces", code: " " + code(step) + " ", wantStep: step, wantOK: true},
+		{name: "two steps ago", code: code(step - 2)},
+		{name: "two steps ahead", code: code(step + 2)},
+		{name: "too short", code: code(step)[1:]},
+		{name: "wrong", code: "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("got step %d, %v, want step %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("got %d bytes, %v, want 20", len(key), err)
+	}
+
+	other, _ := GenerateTOTPSecret()
+	if other == secret {
+		t.Error("two secrets are the same")
+	}
+}
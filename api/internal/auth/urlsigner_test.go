@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner([]byte("key"))
+	now := time.Unix(1700000000, 0)
+	path := "/v1/exports/exp_1/download"
+
+	signed, err := url.ParseQuery(signer.Sign(path, now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range signed {
+			query[k] = v
+		}
+		query.Set(key, value)
+		return query
+	}
+
+	tests := []struct {
+		name   string
+		signer *URLSigner
+		path   string
+		query  url.Values
+		now    time.Time
+		want   error
+	}{
+		{name: "valid", path: path, query: signed, now: now},
+		{name: "at expiry", path: path, query: signed, now: now.Add(time.Hour)},
+		{name: "expired", path: path, query: signed, now: now.Add(time.Hour + time.Second), want: ErrURLExpired},
+		{name: "other path", path: "/v1/exports/exp_2/download", query: signed, now: now, want: ErrInvalidSignature},
+		{name: "expiry pushed back", path: path, query: with("expires", "9999999999"), now: now, want: ErrInvalidSignature},
+		{name: "signature changed", path: path, query: with("signature", "AAAA"), now: now, want: ErrInvalidSignature},
+		{name: "signature not base64", path: path, query: with("signature", "!!"), now: now, want: ErrInvalidSignature},
+		{name: "no signature", path: path, query: url.Values{"expires": signed["expires"]}, now: now, want: ErrInvalidSignature},
+		{name: "no query", path: path, query: url.Values{}, now: now, want: ErrInvalidSignature},
+		{name: "other key", signer: NewURLSigner([]byte("other")), path: path, query: signed, now: now, want: ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := signer
+			if tt.signer != nil {
+				s = tt.signer
+			}
+
+			if err := s.Verify(tt.path, tt.query, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
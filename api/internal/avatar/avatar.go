@@ -0,0 +1,146 @@
+// Package avatar turns uploaded pictures into the square JPEG images served
+// as avatars.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"slices"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	FullSize      = 512
+	ThumbnailSize = 128
+
+	ContentType = "image/jpeg"
+
+	quality = 85
+)
+
+var (
+	ErrUnsupportedType = errors.New("avatar must be a JPEG, PNG or GIF image")
+	ErrTooLarge        = errors.New("avatar dimensions are too large")
+)
+
+var supportedTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Images are the re-encoded versions of an upload.
+type Images struct {
+	Full      []byte
+	Thumbnail []byte
+}
+
+// Process checks that data is a supported image by its content, not by what
+// the client claims, then crops it to a centered square and re-encodes it.
+// Re-encoding drops metadata such as the location a photo was taken at.
+// Images of more than maxPixels are refused before being decoded, which
+// guards against decompression bombs: small files that decode to huge images.
+func Process(data []byte, maxPixels int) (*Images, error) {
+	if !slices.Contains(supportedTypes, mimetype.Detect(data).String()) {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	square := cropSquare(src)
+
+	full, err := encode(square, FullSize)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := encode(square, ThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Images{Full: full, Thumbnail: thumbnail}, nil
+}
+
+// cropSquare returns the largest centered square of img, flattened onto a
+// white background as JPEG has no transparency.
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(square, square.Bounds(), img, origin, draw.Over)
+
+	return square
+}
+
+func encode(square *image.RGBA, size int) ([]byte, error) {
+	// Small pictures are not scaled up.
+	size = min(size, square.Bounds().Dx())
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resize(square, size), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encoding avatar: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resize scales a square image down to size by averaging the source pixels
+// covered by each destination pixel.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	if side == size {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, max((y+1)*side/size, y*side/size+1)
+
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, max((x+1)*side/size, x*side/size+1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
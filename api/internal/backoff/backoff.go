@@ -0,0 +1,21 @@
+// Package backoff spaces out the retries of work that failed, such as
+// outbox events and webhook deliveries.
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Delay returns how long to wait after the given number of failed attempts:
+// base after the first, doubled on every attempt up to max, with up to a
+// tenth added at random so that retries failed together do not run together.
+func Delay(attempts int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max)
+
+	return delay + rand.N(delay/10+1)
+}
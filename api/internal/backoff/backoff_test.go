@@ -0,0 +1,30 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{5, 16 * time.Minute},
+		{6, 30 * time.Minute},
+		{100, 30 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			got := Delay(tt.attempts, time.Minute, 30*time.Minute)
+			if got < tt.want || got > tt.want+tt.want/10 {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.attempts, got, tt.want, tt.want+tt.want/10)
+			}
+		}
+	}
+}
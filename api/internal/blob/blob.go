@@ -0,0 +1,20 @@
+// Package blob stores files, such as avatars, outside of the database.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under slash separated keys, e.g. "avatars/user_x/full.jpg".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the content of the blob, or ErrNotFound. The caller closes
+	// it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,189 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/auth"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type Store interface {
+	Create(context.Context, *store.SigningKey) error
+	List(ctx context.Context, includeRetired bool) ([]*store.SigningKey, error)
+	Promote(ctx context.Context, id string) error
+	Retire(ctx context.Context, id string) error
+}
+
+// Manager persists the signing keys of the auth.KeyRing in the database,
+// encrypted with the secret box.
+type Manager struct {
+	store Store
+	box   *auth.SecretBox
+}
+
+func NewManager(store Store, box *auth.SecretBox) *Manager {
+	return &Manager{store, box}
+}
+
+// Load decrypts every key that is not retired.
+func (m *Manager) Load(ctx context.Context) ([]*auth.SigningKey, error) {
+	records, err := m.store.List(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*auth.SigningKey, 0, len(records))
+	for _, record := range records {
+		der, err := m.box.Open(record.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting key %s: %w", record.ID, err)
+		}
+
+		signer, err := auth.UnmarshalPrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key %s: %w", record.ID, err)
+		}
+
+		key, err := auth.NewSigningKey(record.ID, record.Status, signer)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *Manager) List(ctx context.Context) ([]*store.SigningKey, error) {
+	return m.store.List(ctx, true)
+}
+
+// Generate creates a pending key. It is published in the JWKS right away but
+// only signs tokens once promoted.
+func (m *Manager) Generate(ctx context.Context, algorithm string) (*store.SigningKey, error) {
+	signer, err := auth.GeneratePrivateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := auth.MarshalPrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := m.box.Seal(der)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &store.SigningKey{
+		Algorithm:  algorithm,
+		PrivateKey: encrypted,
+	}
+
+	if err := m.store.Create(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m *Manager) Promote(ctx context.Context, id string) error {
+	return m.store.Promote(ctx, id)
+}
+
+func (m *Manager) Retire(ctx context.Context, id string) error {
+	return m.store.Retire(ctx, id)
+}
+
+// RotationPolicy describes the schedule applied by Rotate.
+type RotationPolicy struct {
+	Algorithm string
+	// Interval is how long a key stays active.
+	Interval time.Duration
+	// PublishAhead is how long a key is published as pending before it is
+	// promoted. It must exceed the time verifiers cache the JWKS.
+	PublishAhead time.Duration
+	// RetireAfter is how long a replaced key keeps verifying. It must exceed
+	// the lifetime of access tokens.
+	RetireAfter time.Duration
+}
+
+type RotationReport struct {
+	Generated []string
+	Promoted  []string
+	Retired   []string
+}
+
+// Rotate moves the key ring one step along the rotation policy. It is meant
+// to run on a schedule, e.g. hourly from cron, and does nothing when no step
+// is due.
+func (m *Manager) Rotate(ctx context.Context, policy RotationPolicy, now time.Time) (*RotationReport, error) {
+	report := &RotationReport{}
+
+	records, err := m.store.List(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var active, pending *store.SigningKey
+	for _, record := range records {
+		switch record.Status {
+		case auth.KeyStatusActive:
+			active = record
+		case auth.KeyStatusPending:
+			// records are sorted newest first
+			if pending == nil {
+				pending = record
+			}
+		case auth.KeyStatusVerify:
+			if record.DeactivatedAt.Valid && now.Sub(record.DeactivatedAt.Time) >= policy.RetireAfter {
+				if err := m.store.Retire(ctx, record.ID); err != nil && !errors.Is(err, store.ErrConflict) {
+					return nil, err
+				}
+				report.Retired = append(report.Retired, record.ID)
+			}
+		}
+	}
+
+	// Nothing signs yet: bootstrap the ring without waiting.
+	if active == nil {
+		if pending == nil {
+			pending, err = m.Generate(ctx, policy.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			report.Generated = append(report.Generated, pending.ID)
+		}
+
+		if err := m.store.Promote(ctx, pending.ID); err != nil {
+			return nil, err
+		}
+		report.Promoted = append(report.Promoted, pending.ID)
+
+		return report, nil
+	}
+
+	activeAge := now.Sub(active.ActivatedAt.Time)
+
+	if pending == nil && activeAge >= policy.Interval-policy.PublishAhead {
+		pending, err = m.Generate(ctx, policy.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		report.Generated = append(report.Generated, pending.ID)
+	}
+
+	if pending != nil && activeAge >= policy.Interval && now.Sub(pending.CreatedAt) >= policy.PublishAhead {
+		if err := m.store.Promote(ctx, pending.ID); err != nil {
+			return nil, err
+		}
+		report.Promoted = append(report.Promoted, pending.ID)
+	}
+
+	return report, nil
+}
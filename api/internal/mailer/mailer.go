@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"text/template"
+)
+
+const (
+	VerifyEmailTemplate         = "verify_email.tmpl"
+	ResetPasswordTemplate       = "reset_password.tmpl"
+	RecoveryCodeUsedTemplate    = "recovery_code_used.tmpl"
+	AccountLockedTemplate       = "account_locked.tmpl"
+	MagicLinkTemplate           = "magic_link.tmpl"
+	EmailOTPTemplate            = "email_otp.tmpl"
+	AccountDeletedTemplate      = "account_deleted.tmpl"
+	DataExportReadyTemplate     = "data_export_ready.tmpl"
+	EmailChangeConfirmTemplate  = "email_change_confirm.tmpl"
+	EmailChangeNoticeTemplate   = "email_change_notice.tmpl"
+	AccountDeletionCodeTemplate = "account_deletion_code.tmpl"
+	EmailChangeCodeTemplate     = "email_change_code.tmpl"
+)
+
+//go:embed templates
+var FS embed.FS
+
+type Client interface {
+	Send(templateFile, username, email string, data any) error
+}
+
+type Message struct {
+	To       string
+	Username string
+	Subject  string
+	Body     string
+}
+
+func render(templateFile, username, email string, data any) (*Message, error) {
+	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(body, "body", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:       email,
+		Username: username,
+		Subject:  subject.String(),
+		Body:     body.String(),
+	}, nil
+}
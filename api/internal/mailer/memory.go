@@ -0,0 +1,45 @@
+package mailer
+
+import "sync"
+
+// InMemoryMailer keeps every rendered message in an outbox instead of
+// delivering it. It is meant for tests and local development.
+type InMemoryMailer struct {
+	mu     sync.Mutex
+	outbox []Message
+}
+
+func NewInMemoryMailer() *InMemoryMailer {
+	return &InMemoryMailer{}
+}
+
+func (m *InMemoryMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(templateFile, username, email, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outbox = append(m.outbox, *msg)
+
+	return nil
+}
+
+func (m *InMemoryMailer) Outbox() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outbox := make([]Message, len(m.outbox))
+	copy(outbox, m.outbox)
+
+	return outbox
+}
+
+func (m *InMemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outbox = nil
+}
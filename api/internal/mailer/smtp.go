@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPMailer struct {
+	addr      string
+	auth      smtp.Auth
+	fromEmail string
+}
+
+func NewSMTPMailer(host string, port int, username, password, fromEmail string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr:      net.JoinHostPort(host, fmt.Sprint(port)),
+		auth:      auth,
+		fromEmail: fromEmail,
+	}
+}
+
+func (m *SMTPMailer) Send(templateFile, username, email string, data any) error {
+	msg, err := render(templateFile, username, email, data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.fromEmail)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.TrimSpace(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+
+	from, err := mail.ParseAddress(m.fromEmail)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, from.Address, []string{msg.To}, []byte(b.String()))
+}
@@ -0,0 +1,152 @@
+// Package outbox relays the domain events that the store writes to the
+// outbox to the handlers registered for them.
+//
+// Events are written in the same transaction as the change they describe,
+// so a change is never saved without its event. Delivery is at least once:
+// a handler may see an event again when the relay crashes, or fails to
+// record that it is done, so handlers use the event ID as an idempotency key.
+// A handler that succeeded is not run again when the event is retried for
+// another one.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/backoff"
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+type Store interface {
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*store.OutboxEvent, error)
+	HandledBy(ctx context.Context, eventID string) ([]string, error)
+	MarkHandled(ctx context.Context, eventID, handler string) error
+	Complete(ctx context.Context, eventID string) error
+	Retry(ctx context.Context, eventID string, nextAttemptAt time.Time, reason string) error
+	Fail(ctx context.Context, eventID, reason string) error
+}
+
+// Handler reacts to an event. An error has the event retried later.
+type Handler func(ctx context.Context, event *store.OutboxEvent) error
+
+// Policy controls how events are claimed and retried. A failed event is
+// retried after BackoffBase, doubled on every attempt up to BackoffMax, and
+// given up after MaxAttempts.
+type Policy struct {
+	BatchSize   int
+	Lease       time.Duration
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+type handler struct {
+	name string
+	fn   Handler
+}
+
+type Relay struct {
+	store    Store
+	policy   Policy
+	handlers map[string][]handler
+}
+
+func NewRelay(store Store, policy Policy) *Relay {
+	return &Relay{
+		store:    store,
+		policy:   policy,
+		handlers: map[string][]handler{},
+	}
+}
+
+// Handle registers fn for the event. The name tells the handlers of an event
+// apart and must not change across releases, as it records which of them are
+// done.
+func (r *Relay) Handle(event, name string, fn Handler) {
+	r.handlers[event] = append(r.handlers[event], handler{name, fn})
+}
+
+// Failure is an event that a handler failed to handle.
+type Failure struct {
+	Event   *store.OutboxEvent
+	Handler string
+	Err     error
+	// GaveUp is set when the event ran out of attempts and will not be
+	// retried.
+	GaveUp bool
+}
+
+// Report tells what a call to Process did.
+type Report struct {
+	Claimed  int
+	Failures []Failure
+}
+
+// Process claims a batch of due events and runs their handlers. Events whose
+// outcome could not be recorded are retried once their lease is over.
+func (r *Relay) Process(ctx context.Context) (*Report, error) {
+	events, err := r.store.Claim(ctx, r.policy.BatchSize, r.policy.Lease)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Claimed: len(events)}
+
+	var errs []error
+	for _, event := range events {
+		failures, err := r.process(ctx, event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("event %s: %w", event.ID, err))
+		}
+
+		report.Failures = append(report.Failures, failures...)
+	}
+
+	return report, errors.Join(errs...)
+}
+
+func (r *Relay) process(ctx context.Context, event *store.OutboxEvent) ([]Failure, error) {
+	handled, err := r.store.HandledBy(ctx, event.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []Failure
+	for _, h := range r.handlers[event.Event] {
+		if slices.Contains(handled, h.name) {
+			continue
+		}
+
+		err := h.fn(ctx, event)
+		if err == nil {
+			err = r.store.MarkHandled(ctx, event.ID, h.name)
+		}
+
+		if err != nil {
+			failures = append(failures, Failure{Event: event, Handler: h.name, Err: err})
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil, r.store.Complete(ctx, event.ID)
+	}
+
+	errs := make([]error, len(failures))
+	for i, failure := range failures {
+		errs[i] = fmt.Errorf("%s: %w", failure.Handler, failure.Err)
+	}
+	reason := errors.Join(errs...).Error()
+
+	if event.Attempts >= r.policy.MaxAttempts {
+		for i := range failures {
+			failures[i].GaveUp = true
+		}
+
+		return failures, r.store.Fail(ctx, event.ID, reason)
+	}
+
+	return failures, r.store.Retry(ctx, event.ID, time.Now().Add(backoff.Delay(event.Attempts, r.policy.BackoffBase, r.policy.BackoffMax)), reason)
+}
@@ -0,0 +1,363 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/menaguilherme/trigon/internal/store"
+)
+
+// fakeStore keeps the outbox in memory and mimics the queries of
+// store.OutboxStore.
+type fakeStore struct {
+	mu     sync.Mutex
+	events []*fakeEvent
+
+	// markHandledErr, when set, is returned by MarkHandled.
+	markHandledErr error
+}
+
+type fakeEvent struct {
+	event         store.OutboxEvent
+	handled       []string
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+	completed     bool
+	failed        bool
+	reason        string
+}
+
+func (s *fakeStore) add(id, event string) *fakeEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &fakeEvent{event: store.OutboxEvent{ID: id, Event: event, CreatedAt: time.Now()}}
+	s.events = append(s.events, e)
+
+	return e
+}
+
+// makeDue ends the lease and the backoff of every event, as if time passed.
+func (s *fakeStore) makeDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events {
+		e.nextAttemptAt = time.Time{}
+		e.lockedUntil = time.Time{}
+	}
+}
+
+func (s *fakeStore) get(id string) *fakeEvent {
+	for _, e := range s.events {
+		if e.event.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) Claim(_ context.Context, limit int, lease time.Duration) ([]*store.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	events := []*store.OutboxEvent{}
+	for _, e := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if e.completed || e.failed || e.nextAttemptAt.After(now) || e.lockedUntil.After(now) {
+			continue
+		}
+
+		e.event.Attempts++
+		e.lockedUntil = now.Add(lease)
+
+		event := e.event
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+func (s *fakeStore) HandledBy(_ context.Context, eventID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.get(eventID).handled), nil
+}
+
+func (s *fakeStore) MarkHandled(_ context.Context, eventID, handler string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.markHandledErr != nil {
+		return s.markHandledErr
+	}
+
+	e := s.get(eventID)
+	e.handled = append(e.handled, handler)
+
+	return nil
+}
+
+func (s *fakeStore) Complete(_ context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(eventID).completed = true
+
+	return nil
+}
+
+func (s *fakeStore) Retry(_ context.Context, eventID string, nextAttemptAt time.Time, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.get(eventID)
+	e.nextAttemptAt = nextAttemptAt
+	e.lockedUntil = time.Time{}
+	e.reason = reason
+
+	return nil
+}
+
+func (s *fakeStore) Fail(_ context.Context, eventID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.get(eventID)
+	e.failed = true
+	e.reason = reason
+
+	return nil
+}
+
+var testPolicy = Policy{
+	BatchSize:   10,
+	Lease:       time.Minute,
+	MaxAttempts: 3,
+	BackoffBase: time.Minute,
+	BackoffMax:  time.Hour,
+}
+
+// counter counts the calls of a handler and fails while failing is set.
+type counter struct {
+	mu      sync.Mutex
+	calls   int
+	failing bool
+}
+
+func (c *counter) handle(context.Context, *store.OutboxEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	if c.failing {
+		return errors.New("handler failed")
+	}
+
+	return nil
+}
+
+func TestProcessSkipsHandledHandlers(t *testing.T) {
+	s := &fakeStore{}
+	e := s.add("evt_1", store.EventUserRegistered)
+	e.handled = []string{"welcome_email"}
+
+	var welcome, webhooks counter
+
+	relay := NewRelay(s, testPolicy)
+	relay.Handle(store.EventUserRegistered, "welcome_email", welcome.handle)
+	relay.Handle(store.EventUserRegistered, "webhooks", webhooks.handle)
+
+	report, err := relay.Process(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Claimed != 1 || len(report.Failures) != 0 {
+		t.Errorf("got %+v", report)
+	}
+
+	if welcome.calls != 0 {
+		t.Errorf("the handled handler ran %d times", welcome.calls)
+	}
+
+	if webhooks.calls != 1 {
+		t.Errorf("the other handler ran %d times", webhooks.calls)
+	}
+
+	if !e.completed {
+		t.Error("event not completed")
+	}
+}
+
+func TestProcessRetriesUntilMaxAttempts(t *testing.T) {
+	s := &fakeStore{}
+	e := s.add("evt_1", store.EventUserRegistered)
+
+	h := &counter{failing: true}
+
+	relay := NewRelay(s, testPolicy)
+	relay.Handle(store.EventUserRegistered, "webhooks", h.handle)
+
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= testPolicy.MaxAttempts; attempt++ {
+		start := time.Now()
+
+		report, err := relay.Process(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(report.Failures) != 1 {
+			t.Fatalf("attempt %d: got %d failures", attempt, len(report.Failures))
+		}
+
+		failure := report.Failures[0]
+		if failure.Handler != "webhooks" || failure.Err == nil {
+			t.Errorf("attempt %d: got %+v", attempt, failure)
+		}
+
+		if attempt < testPolicy.MaxAttempts {
+			if failure.GaveUp || e.failed {
+				t.Fatalf("attempt %d: gave up before MaxAttempts", attempt)
+			}
+
+			// The backoff doubles on every attempt.
+			want := testPolicy.BackoffBase << (attempt - 1)
+			if delay := e.nextAttemptAt.Sub(start); delay < want || delay > want+want/10+time.Second {
+				t.Errorf("attempt %d: retried after %s, want %s", attempt, delay, want)
+			}
+
+			// Not due yet.
+			if report, _ := relay.Process(ctx); report.Claimed != 0 {
+				t.Fatalf("attempt %d: claimed before the backoff was over", attempt)
+			}
+
+			s.makeDue()
+			continue
+		}
+
+		if !failure.GaveUp || !e.failed {
+			t.Errorf("did not give up after %d attempts", attempt)
+		}
+
+		if e.reason != "webhooks: handler failed" {
+			t.Errorf("got reason %q", e.reason)
+		}
+	}
+
+	s.makeDue()
+
+	if report, _ := relay.Process(ctx); report.Claimed != 0 {
+		t.Error("a failed event was claimed again")
+	}
+
+	if h.calls != testPolicy.MaxAttempts {
+		t.Errorf("handler ran %d times, want %d", h.calls, testPolicy.MaxAttempts)
+	}
+}
+
+func TestProcessAtLeastOnce(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("only failed handlers are retried", func(t *testing.T) {
+		s := &fakeStore{}
+		e := s.add("evt_1", store.EventUserRegistered)
+
+		welcome := &counter{}
+		webhooks := &counter{failing: true}
+
+		relay := NewRelay(s, testPolicy)
+		relay.Handle(store.EventUserRegistered, "welcome_email", welcome.handle)
+		relay.Handle(store.EventUserRegistered, "webhooks", webhooks.handle)
+
+		if _, err := relay.Process(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		webhooks.failing = false
+		s.makeDue()
+
+		report, err := relay.Process(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Claimed != 1 || len(report.Failures) != 0 || !e.completed {
+			t.Errorf("got %+v, completed %v", report, e.completed)
+		}
+
+		if welcome.calls != 1 || webhooks.calls != 2 {
+			t.Errorf("handlers ran %d and %d times, want 1 and 2", welcome.calls, webhooks.calls)
+		}
+	})
+
+	t.Run("a handler whose success was not recorded runs again", func(t *testing.T) {
+		s := &fakeStore{markHandledErr: errors.New("connection reset")}
+		e := s.add("evt_1", store.EventUserRegistered)
+
+		h := &counter{}
+
+		relay := NewRelay(s, testPolicy)
+		relay.Handle(store.EventUserRegistered, "webhooks", h.handle)
+
+		report, err := relay.Process(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(report.Failures) != 1 || e.completed {
+			t.Fatalf("got %+v, completed %v", report, e.completed)
+		}
+
+		s.markHandledErr = nil
+		s.makeDue()
+
+		if _, err := relay.Process(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.calls != 2 || !e.completed {
+			t.Errorf("handler ran %d times, completed %v", h.calls, e.completed)
+		}
+	})
+
+	t.Run("an event left claimed is claimed again after its lease", func(t *testing.T) {
+		s := &fakeStore{}
+		e := s.add("evt_1", store.EventUserRegistered)
+
+		// A relay that crashed after claiming the event.
+		if _, err := s.Claim(ctx, 1, testPolicy.Lease); err != nil {
+			t.Fatal(err)
+		}
+
+		h := &counter{}
+
+		relay := NewRelay(s, testPolicy)
+		relay.Handle(store.EventUserRegistered, "webhooks", h.handle)
+
+		if report, _ := relay.Process(ctx); report.Claimed != 0 {
+			t.Fatal("claimed an event under lease")
+		}
+
+		s.makeDue()
+
+		if _, err := relay.Process(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.calls != 1 || !e.completed || e.event.Attempts != 2 {
+			t.Errorf("handler ran %d times, completed %v, attempts %d", h.calls, e.completed, e.event.Attempts)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter whose clock only moves with advance.
+func newTestLimiter() (*MemoryLimiter, func(time.Duration)) {
+	now := time.Unix(1700000000, 0)
+
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMemoryLimiterDeniesOnceEmpty(t *testing.T) {
+	l, _ := newTestLimiter()
+	limit := Limit{Rate: 10, Period: time.Minute, Burst: 3}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := l.Allow(ctx, "key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("got %+v, want allowed with %d remaining", result, i)
+		}
+	}
+
+	result, err := l.Allow(ctx, "key", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token takes 6s to refill.
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter != 6*time.Second {
+		t.Errorf("got %+v, want denied for 6s", result)
+	}
+
+	if result, _ := l.Allow(ctx, "other", limit); !result.Allowed {
+		t.Error("the bucket of another key was taken from")
+	}
+}
+
+func TestMemoryLimiterRefills(t *testing.T) {
+	l, advance := newTestLimiter()
+	limit := Limit{Rate: 10, Period: time.Minute, Burst: 3}
+	ctx := context.Background()
+
+	for range 3 {
+		l.Allow(ctx, "key", limit)
+	}
+
+	advance(3 * time.Second)
+
+	result, _ := l.Allow(ctx, "key", limit)
+	if result.Allowed || result.RetryAfter != 3*time.Second {
+		t.Fatalf("half a token: got %+v, want denied for 3s", result)
+	}
+
+	advance(3 * time.Second)
+
+	if result, _ := l.Allow(ctx, "key", limit); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("one token: got %+v", result)
+	}
+
+	// The bucket does not refill past its burst.
+	advance(time.Hour)
+
+	if result, _ := l.Allow(ctx, "key", limit); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("full: got %+v", result)
+	}
+}
+
+func TestMemoryLimiterBurstDefaultsToRate(t *testing.T) {
+	l, _ := newTestLimiter()
+	limit := Limit{Rate: 2, Period: time.Second}
+	ctx := context.Background()
+
+	for i := range 2 {
+		if result, _ := l.Allow(ctx, "key", limit); !result.Allowed {
+			t.Fatalf("request %d denied", i+1)
+		}
+	}
+
+	if result, _ := l.Allow(ctx, "key", limit); result.Allowed {
+		t.Error("allowed past the rate")
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	l, advance := newTestLimiter()
+	ctx := context.Background()
+
+	// Empty, a bucket of the slow limit takes two days to refill.
+	slow := Limit{Rate: 1, Period: 24 * time.Hour, Burst: 2}
+	fast := Limit{Rate: 10, Period: time.Minute, Burst: 10}
+
+	l.Allow(ctx, "slow", slow)
+	l.Allow(ctx, "slow", slow)
+	l.Allow(ctx, "fast", fast)
+
+	advance(2 * time.Hour)
+	l.Allow(ctx, "sweeper", fast)
+
+	if _, ok := l.buckets["fast"]; ok {
+		t.Error("a full bucket was kept")
+	}
+
+	if _, ok := l.buckets["slow"]; !ok {
+		t.Fatal("a bucket that is not full yet was dropped")
+	}
+
+	if result, _ := l.Allow(ctx, "slow", slow); result.Allowed {
+		t.Errorf("the slow limit was reset: got %+v", result)
+	}
+}
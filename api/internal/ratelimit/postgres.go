@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresLimiter keeps the buckets in the rate_limit_buckets table so that
+// every API instance shares them. Each call is a single atomic upsert.
+type PostgresLimiter struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresLimiter(db *sql.DB) *PostgresLimiter {
+	return &PostgresLimiter{db: db, timeout: time.Second}
+}
+
+func (l *PostgresLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	// $2 is the burst, $3 the refill rate per second and $4 the seconds it
+	// takes to refill one token. The SET expressions are evaluated against the
+	// stored row, so the bucket is refilled for the time elapsed since its
+	// last update before a token is taken. full_at is when the bucket is full
+	// again, once the tokens it is short of have refilled.
+	query := `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at, full_at)
+		VALUES ($1, $2 - 1, TRUE, NOW(), NOW() + make_interval(secs => $4))
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE
+				WHEN LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3) >= 1
+				THEN LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3) - 1
+				ELSE LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3)
+			END,
+			allowed = LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3) >= 1,
+			updated_at = NOW(),
+			full_at = NOW() + make_interval(secs => $4 * CASE
+				WHEN LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3) >= 1
+				THEN $2 - LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3) + 1
+				ELSE $2 - LEAST($2, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3)
+			END)
+		RETURNING tokens, allowed
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+
+	err := l.db.QueryRowContext(ctx, query, key, limit.burst(), limit.perSecond(), 1/limit.perSecond()).Scan(&tokens, &allowed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(tokens, allowed, limit), nil
+}
+
+// Prune deletes the buckets that are full again. A missing bucket starts
+// full, so deleting them changes nothing, whatever the limit.
+func (l *PostgresLimiter) Prune(ctx context.Context) error {
+	query := `DELETE FROM rate_limit_buckets WHERE full_at < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	_, err := l.db.ExecContext(ctx, query)
+
+	return err
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens and refills Rate
+// tokens every Period. Every request takes one token.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// perSecond returns the refill rate in tokens per second.
+func (l Limit) perSecond() float64 {
+	return float64(l.Rate) / l.Period.Seconds()
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Rate)
+}
+
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until a token is available again. It is only
+	// set when the request was not allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket of key. Implementations sharing
+// their state, like the Postgres one, apply the limit across API instances.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+func newResult(tokens float64, allowed bool, limit Limit) Result {
+	result := Result{Allowed: allowed, Remaining: int(tokens)}
+
+	if !allowed {
+		result.Remaining = 0
+		result.RetryAfter = time.Duration((1 - tokens) / limit.perSecond() * float64(time.Second))
+	}
+
+	return result
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AccountLockout blocks password logins of a user until LockedUntil, or
+// until the unlock link emailed to them is used.
+type AccountLockout struct {
+	UserID          string    `json:"user_id"`
+	LockedUntil     time.Time `json:"locked_until"`
+	UnlockToken     string    `json:"-"`
+	UnlockExpiresAt time.Time `json:"unlock_expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type AccountLockoutStore struct {
+	db *sql.DB
+}
+
+// Lock creates or replaces the lockout of the user.
+func (s *AccountLockoutStore) Lock(ctx context.Context, lockout *AccountLockout) error {
+	query := `
+		INSERT INTO account_lockouts (user_id, locked_until, unlock_token_hash, unlock_expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET locked_until = EXCLUDED.locked_until,
+		    unlock_token_hash = EXCLUDED.unlock_token_hash,
+		    unlock_expires_at = EXCLUDED.unlock_expires_at,
+		    created_at = NOW()
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		lockout.UserID,
+		lockout.LockedUntil,
+		hashToken(lockout.UnlockToken),
+		lockout.UnlockExpiresAt,
+	).Scan(
+		&lockout.CreatedAt,
+	)
+}
+
+// GetActive returns the lockout of the user if it has not ended yet.
+func (s *AccountLockoutStore) GetActive(ctx context.Context, userID string) (*AccountLockout, error) {
+	query := `
+		SELECT user_id, locked_until, unlock_expires_at, created_at
+		FROM account_lockouts
+		WHERE user_id = $1 AND locked_until > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	lockout := &AccountLockout{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&lockout.UserID,
+		&lockout.LockedUntil,
+		&lockout.UnlockExpiresAt,
+		&lockout.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return lockout, nil
+}
+
+// Unlock removes the lockout matching the plaintext unlock token. Expired
+// tokens are reported as ErrNotFound.
+func (s *AccountLockoutStore) Unlock(ctx context.Context, token string) (*AccountLockout, error) {
+	query := `
+		DELETE FROM account_lockouts
+		WHERE unlock_token_hash = $1 AND unlock_expires_at > NOW()
+		RETURNING user_id, locked_until, unlock_expires_at, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	lockout := &AccountLockout{}
+	err := s.db.QueryRowContext(ctx, query, hashToken(token)).Scan(
+		&lockout.UserID,
+		&lockout.LockedUntil,
+		&lockout.UnlockExpiresAt,
+		&lockout.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return lockout, nil
+}
+
+func (s *AccountLockoutStore) Delete(ctx context.Context, userID string) error {
+	query := `DELETE FROM account_lockouts WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+
+	return err
+}
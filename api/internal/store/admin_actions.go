@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AdminAction records an action taken by an admin on a user account. The
+// record outlives both accounts, whose IDs are then cleared.
+type AdminAction struct {
+	ID           string         `json:"id"`
+	AdminID      sql.NullString `json:"admin_id"`
+	TargetUserID sql.NullString `json:"target_user_id"`
+	Action       string         `json:"action"`
+	Reason       string         `json:"reason"`
+	IP           sql.NullString `json:"ip"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+type AdminActionStore struct {
+	db *sql.DB
+}
+
+// addAdminAction records an admin action. q is the transaction of the action
+// itself, so that an action is never taken without its record.
+func addAdminAction(ctx context.Context, q querier, action *AdminAction) error {
+	query := `
+		INSERT INTO admin_actions (id, admin_id, target_user_id, action, reason, ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`
+
+	actionID, err := generateId("adminaction")
+	if err != nil {
+		return err
+	}
+
+	err = q.QueryRowContext(
+		ctx,
+		query,
+		actionID,
+		action.AdminID,
+		action.TargetUserID,
+		action.Action,
+		action.Reason,
+		action.IP,
+	).Scan(
+		&action.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	action.ID = actionID
+
+	return nil
+}
+
+// ListForUser returns the most recent actions taken on the user, newest
+// first. A limit of zero returns them all.
+func (s *AdminActionStore) ListForUser(ctx context.Context, userID string, limit int) ([]*AdminAction, error) {
+	query := `
+		SELECT id, admin_id, target_user_id, action, reason, ip, created_at
+		FROM admin_actions
+		WHERE target_user_id = $1
+		ORDER BY created_at DESC
+		LIMIT NULLIF($2, 0)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*AdminAction{}
+	for rows.Next() {
+		action := &AdminAction{}
+		err := rows.Scan(
+			&action.ID,
+			&action.AdminID,
+			&action.TargetUserID,
+			&action.Action,
+			&action.Reason,
+			&action.IP,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		actions = append(actions, action)
+	}
+
+	return actions, rows.Err()
+}
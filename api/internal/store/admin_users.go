@@ -0,0 +1,283 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// AdminUser is a user as seen by admins. Unlike the rest of the store it
+// includes blocked users.
+type AdminUser struct {
+	User
+	BlockedAt     sql.NullTime   `json:"blocked_at"`
+	BlockedReason sql.NullString `json:"blocked_reason"`
+	Roles         []string       `json:"roles"`
+	MFAEnabled    bool           `json:"mfa_enabled"`
+}
+
+// UserFilter narrows AdminUserStore.Search. Query matches the start of the
+// email, username, first or last name. Blocked is ignored when nil.
+type UserFilter struct {
+	Query   string
+	Blocked *bool
+	Limit   int
+	Offset  int
+}
+
+type AdminUserStore struct {
+	db *sql.DB
+}
+
+const adminUserColumns = `
+	u.id, u.first_name, u.last_name, u.username, u.email, u.profile_url, u.avatar_key, u.refresh_token_version,
+	u.is_deleted, u.is_blocked, u.email_verified_at, u.deleted_at, u.created_at, u.updated_at,
+	u.blocked_at, u.blocked_reason,
+	COALESCE((
+		SELECT ARRAY_AGG(r.name ORDER BY r.name)
+		FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = u.id
+	), '{}'),
+	EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.confirmed_at IS NOT NULL)
+`
+
+const userFilterWhere = `
+	($1 = '' OR u.email ILIKE $1 || '%' OR u.username ILIKE $1 || '%'
+		OR u.first_name ILIKE $1 || '%' OR u.last_name ILIKE $1 || '%')
+	AND ($2::BOOLEAN IS NULL OR u.is_blocked = $2)
+`
+
+func scanAdminUser(row interface{ Scan(...any) error }, dest ...any) (*AdminUser, error) {
+	user := &AdminUser{}
+	err := row.Scan(append([]any{
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Username,
+		&user.Email,
+		&user.ProfileURL,
+		&user.AvatarKey,
+		&user.RefreshTokenVersion,
+		&user.IsDeleted,
+		&user.IsBlocked,
+		&user.EmailVerifiedAt,
+		&user.DeletedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.BlockedAt,
+		&user.BlockedReason,
+		pq.Array(&user.Roles),
+		&user.MFAEnabled,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Search returns a page of the users matching the filter, newest first,
+// along with the number of matches across all pages.
+func (s *AdminUserStore) Search(ctx context.Context, filter UserFilter) ([]*AdminUser, int, error) {
+	query := `
+		SELECT ` + adminUserColumns + `, COUNT(*) OVER()
+		FROM users u
+		WHERE ` + userFilterWhere + `
+		ORDER BY u.created_at DESC, u.id
+		LIMIT $3 OFFSET $4
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	search := escapeLike(filter.Query)
+
+	rows, err := s.db.QueryContext(ctx, query, search, filter.Blocked, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var total int
+	users := []*AdminUser{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the count.
+	if len(users) == 0 && filter.Offset > 0 {
+		query := `SELECT COUNT(*) FROM users u WHERE ` + userFilterWhere
+
+		err := s.db.QueryRowContext(ctx, query, search, filter.Blocked).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return users, total, nil
+}
+
+func (s *AdminUserStore) GetByID(ctx context.Context, id string) (*AdminUser, error) {
+	query := `SELECT ` + adminUserColumns + ` FROM users u WHERE u.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user, err := scanAdminUser(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// The methods below take an admin action on a user and record it, along
+// with its domain event if any, in the same transaction.
+
+// Block blocks the user and bumps its token version, so its refresh tokens
+// stop working at once, and records EventUserBlocked. The reason of the action
+// is the reason of the block. It returns ErrConflict when the user is already
+// blocked.
+func (s *AdminUserStore) Block(ctx context.Context, user *User, action *AdminAction) error {
+	query := `
+		UPDATE users
+		SET is_blocked = true, blocked_at = NOW(), blocked_reason = $2,
+			refresh_token_version = refresh_token_version + 1
+		WHERE id = $1 AND is_blocked = false
+	`
+
+	event := newUserEvent(user)
+	event.Reason = action.Reason
+
+	return s.setBlocked(ctx, query, user.ID, EventUserBlocked, event, action, action.Reason)
+}
+
+// Unblock lets the user sign in again and records EventUserUnblocked. It
+// returns ErrConflict when the user is not blocked.
+func (s *AdminUserStore) Unblock(ctx context.Context, user *User, action *AdminAction) error {
+	query := `
+		UPDATE users
+		SET is_blocked = false, blocked_at = NULL, blocked_reason = NULL
+		WHERE id = $1 AND is_blocked = true
+	`
+
+	return s.setBlocked(ctx, query, user.ID, EventUserUnblocked, newUserEvent(user), action)
+}
+
+func (s *AdminUserStore) setBlocked(ctx context.Context, query, id, event string, payload any, action *AdminAction, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, append([]any{id}, args...)...)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists); err != nil {
+				return err
+			}
+
+			if !exists {
+				return ErrNotFound
+			}
+
+			return ErrConflict
+		}
+
+		if err := addOutboxEvent(ctx, tx, event, id, payload); err != nil {
+			return err
+		}
+
+		return addAdminAction(ctx, tx, action)
+	})
+}
+
+// ForceLogout bumps the token version of the user, which ends all its
+// sessions. It returns ErrNotFound when the user does not exist.
+func (s *AdminUserStore) ForceLogout(ctx context.Context, user *User, action *AdminAction) error {
+	query := `
+		UPDATE users
+		SET refresh_token_version = refresh_token_version + 1
+		WHERE id = $1
+		RETURNING refresh_token_version
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, user.ID).Scan(&user.RefreshTokenVersion)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return addAdminAction(ctx, tx, action)
+	})
+}
+
+// ResetMFA removes the authenticator app and the recovery codes of the user.
+func (s *AdminUserStore) ResetMFA(ctx context.Context, userID string, action *AdminAction) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		return addAdminAction(ctx, tx, action)
+	})
+}
+
+// CreatePasswordReset creates a password reset on behalf of the user, to be
+// emailed to them.
+func (s *AdminUserStore) CreatePasswordReset(ctx context.Context, reset *PasswordReset, action *AdminAction) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := createPasswordReset(ctx, tx, reset); err != nil {
+			return err
+		}
+
+		return addAdminAction(ctx, tx, action)
+	})
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
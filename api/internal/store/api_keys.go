@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey lets scripts call the API on behalf of its owner, limited to its
+// scopes. Only the SHA-256 digest of Key is stored; Key is set when the key
+// is created and shown to the user once. Prefix is stored in clear so users
+// can recognize their keys.
+type APIKey struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id"`
+	Name       string         `json:"name"`
+	Prefix     string         `json:"prefix"`
+	Key        string         `json:"-"`
+	Scopes     []string       `json:"scopes"`
+	ExpiresAt  sql.NullTime   `json:"expires_at"`
+	LastUsedAt sql.NullTime   `json:"last_used_at"`
+	LastUsedIP sql.NullString `json:"last_used_ip"`
+	RevokedAt  sql.NullTime   `json:"revoked_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, revoked_at, created_at, updated_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	key := &APIKey{}
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.LastUsedIP,
+		&key.RevokedAt,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (s *APIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	keyID, err := generateId("apikey")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		keyID,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		hashToken(key.Key),
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+	).Scan(
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	key.ID = keyID
+
+	return nil
+}
+
+// GetByKey returns the live key matching the plaintext key. Revoked and
+// expired keys are reported as ErrNotFound.
+func (s *APIKeyStore) GetByKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, hashToken(plaintext)))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (s *APIKeyStore) GetByID(ctx context.Context, id, userID string) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, id, userID))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetByUserID returns the keys of the user that are not revoked, including
+// expired ones.
+func (s *APIKeyStore) GetByUserID(ctx context.Context, userID string) ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Update saves the name and scopes of the key.
+func (s *APIKeyStore) Update(ctx context.Context, key *APIKey) error {
+	query := `
+		UPDATE api_keys
+		SET name = $1, scopes = $2
+		WHERE id = $3 AND user_id = $4 AND revoked_at IS NULL
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		key.Name,
+		pq.Array(key.Scopes),
+		key.ID,
+		key.UserID,
+	).Scan(
+		&key.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecordUse updates the last use of the key. Uses within a minute of the
+// previous one are not written, to spare a write on every request.
+func (s *APIKeyStore) RecordUse(ctx context.Context, id, ip string) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, ip)
+
+	return err
+}
+
+func (s *APIKeyStore) Revoke(ctx context.Context, id, userID string) error {
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
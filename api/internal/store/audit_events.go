@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent records a security relevant event of a user account. UserID is
+// the account the event is about and ActorID whoever caused it, which is the
+// same user unless an admin acted on the account. Both are cleared when the
+// account is purged, the event itself is kept.
+type AuditEvent struct {
+	ID        string            `json:"id"`
+	Event     string            `json:"event"`
+	UserID    sql.NullString    `json:"user_id"`
+	ActorID   sql.NullString    `json:"actor_id"`
+	IP        sql.NullString    `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	RequestID string            `json:"request_id"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// AuditEventFilter narrows AuditEventStore.List. Empty fields and nil times
+// are ignored, and a limit of zero returns every match.
+type AuditEventFilter struct {
+	UserID  string
+	ActorID string
+	Event   string
+	IP      string
+	Since   *time.Time
+	Until   *time.Time
+	Limit   int
+	Offset  int
+}
+
+type AuditEventStore struct {
+	db *sql.DB
+}
+
+func (s *AuditEventStore) Create(ctx context.Context, event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (id, event, user_id, actor_id, ip, user_agent, request_id, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	eventID, err := generateId("audit")
+	if err != nil {
+		return err
+	}
+
+	if event.Metadata == nil {
+		event.Metadata = map[string]string{}
+	}
+
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		eventID,
+		event.Event,
+		event.UserID,
+		event.ActorID,
+		event.IP,
+		event.UserAgent,
+		event.RequestID,
+		metadata,
+	).Scan(
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	event.ID = eventID
+
+	return nil
+}
+
+const auditEventFilterWhere = `
+	($1 = '' OR user_id = $1)
+	AND ($2 = '' OR actor_id = $2)
+	AND ($3 = '' OR event = $3)
+	AND ($4 = '' OR ip = $4)
+	AND ($5::TIMESTAMPTZ IS NULL OR created_at >= $5)
+	AND ($6::TIMESTAMPTZ IS NULL OR created_at < $6)
+`
+
+// List returns a page of the events matching the filter, newest first,
+// along with the number of matches across all pages.
+func (s *AuditEventStore) List(ctx context.Context, filter AuditEventFilter) ([]*AuditEvent, int, error) {
+	query := `
+		SELECT id, event, user_id, actor_id, ip, user_agent, request_id, metadata, created_at, COUNT(*) OVER()
+		FROM audit_events
+		WHERE ` + auditEventFilterWhere + `
+		ORDER BY created_at DESC, id
+		LIMIT NULLIF($7, 0) OFFSET $8
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	args := []any{filter.UserID, filter.ActorID, filter.Event, filter.IP, filter.Since, filter.Until}
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var total int
+	events := []*AuditEvent{}
+	for rows.Next() {
+		event := &AuditEvent{}
+		var metadata []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.Event,
+			&event.UserID,
+			&event.ActorID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&metadata,
+			&event.CreatedAt,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+			return nil, 0, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the count.
+	if len(events) == 0 && filter.Offset > 0 {
+		query := `SELECT COUNT(*) FROM audit_events WHERE ` + auditEventFilterWhere
+
+		if err := s.db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AttemptWindow summarizes the attempts recorded under a key since the
+// start of a sliding window. LimitingAttempt is the limit-th most recent
+// attempt: once it leaves the window the key is below the limit again. It is
+// zero while fewer attempts than the limit were made.
+type AttemptWindow struct {
+	Count           int
+	Latest          time.Time
+	LimitingAttempt time.Time
+}
+
+// AuthAttemptStore records authentication attempts under throttling keys
+// such as "login:ip:203.0.113.7". Being backed by the database, the counts
+// are shared by every API instance.
+type AuthAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *AuthAttemptStore) Record(ctx context.Context, key string) error {
+	query := `INSERT INTO auth_attempts (key) VALUES ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+
+	return err
+}
+
+func (s *AuthAttemptStore) Window(ctx context.Context, key string, since time.Time, limit int) (*AttemptWindow, error) {
+	query := `
+		SELECT COUNT(*), MAX(created_at)
+		FROM auth_attempts
+		WHERE key = $1 AND created_at > $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var latest sql.NullTime
+	window := &AttemptWindow{}
+
+	err := s.db.QueryRowContext(ctx, query, key, since).Scan(&window.Count, &latest)
+	if err != nil {
+		return nil, err
+	}
+
+	window.Latest = latest.Time
+
+	if limit <= 0 || window.Count < limit {
+		return window, nil
+	}
+
+	query = `
+		SELECT created_at
+		FROM auth_attempts
+		WHERE key = $1 AND created_at > $2
+		ORDER BY created_at DESC
+		OFFSET $3 LIMIT 1
+	`
+
+	err = s.db.QueryRowContext(ctx, query, key, since, limit-1).Scan(&window.LimitingAttempt)
+	if err != nil {
+		return nil, err
+	}
+
+	return window, nil
+}
+
+func (s *AuthAttemptStore) Clear(ctx context.Context, key string) error {
+	query := `DELETE FROM auth_attempts WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+
+	return err
+}
+
+// Prune deletes the attempts that no window looks at anymore.
+func (s *AuthAttemptStore) Prune(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM auth_attempts WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
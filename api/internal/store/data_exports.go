@@ -0,0 +1,239 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportFailed     = "failed"
+)
+
+// DataExport is an archive of the personal data of a user. It is assembled
+// in the background and can be downloaded until ExpiresAt.
+type DataExport struct {
+	ID          string         `json:"id"`
+	UserID      string         `json:"user_id"`
+	Status      string         `json:"status"`
+	Archive     []byte         `json:"-"`
+	Error       sql.NullString `json:"-"`
+	ExpiresAt   sql.NullTime   `json:"expires_at"`
+	CompletedAt sql.NullTime   `json:"completed_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type DataExportStore struct {
+	db *sql.DB
+}
+
+// stuckExportTimeout is how long an export can stay processing before
+// another worker takes it over, e.g. after a crash.
+const stuckExportTimeout = 10 * time.Minute
+
+// Create queues an export. It returns ErrConflict when the user already has
+// one in progress.
+func (s *DataExportStore) Create(ctx context.Context, export *DataExport) error {
+	query := `
+		INSERT INTO data_exports (id, user_id)
+		VALUES ($1, $2)
+		RETURNING status, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	exportID, err := generateId("export")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		exportID,
+		export.UserID,
+	).Scan(
+		&export.Status,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_data_exports_in_progress"`:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+
+	export.ID = exportID
+
+	return nil
+}
+
+// GetByUserID returns the exports of the user, newest first, without their
+// archives.
+func (s *DataExportStore) GetByUserID(ctx context.Context, userID string) ([]*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, expires_at, completed_at, created_at, updated_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []*DataExport{}
+	for rows.Next() {
+		export := &DataExport{}
+		err := rows.Scan(
+			&export.ID,
+			&export.UserID,
+			&export.Status,
+			&export.ExpiresAt,
+			&export.CompletedAt,
+			&export.CreatedAt,
+			&export.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		exports = append(exports, export)
+	}
+
+	return exports, rows.Err()
+}
+
+// GetReady returns a ready export that has not expired, with its archive.
+func (s *DataExportStore) GetReady(ctx context.Context, id string) (*DataExport, error) {
+	query := `
+		SELECT id, user_id, status, archive, expires_at, completed_at, created_at, updated_at
+		FROM data_exports
+		WHERE id = $1 AND status = 'ready' AND expires_at > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	export := &DataExport{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.Archive,
+		&export.ExpiresAt,
+		&export.CompletedAt,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return export, nil
+}
+
+// Claim marks the oldest pending export as processing and returns it, so
+// that concurrent workers never build the same export. It returns
+// ErrNotFound when there is nothing to do.
+func (s *DataExportStore) Claim(ctx context.Context) (*DataExport, error) {
+	query := `
+		UPDATE data_exports
+		SET status = 'processing'
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = 'pending' OR (status = 'processing' AND updated_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, status, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	export := &DataExport{}
+	err := s.db.QueryRowContext(ctx, query, time.Now().Add(-stuckExportTimeout)).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return export, nil
+}
+
+func (s *DataExportStore) Complete(ctx context.Context, export *DataExport) error {
+	query := `
+		UPDATE data_exports
+		SET status = 'ready', archive = $2, expires_at = $3, completed_at = NOW()
+		WHERE id = $1
+		RETURNING status, completed_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query, export.ID, export.Archive, export.ExpiresAt).Scan(
+		&export.Status,
+		&export.CompletedAt,
+		&export.UpdatedAt,
+	)
+}
+
+func (s *DataExportStore) Fail(ctx context.Context, id, reason string) error {
+	query := `
+		UPDATE data_exports SET status = 'failed', error = $2 WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, reason)
+
+	return err
+}
+
+// Prune deletes the exports that expired before the given time, along with
+// failed exports created before it.
+func (s *DataExportStore) Prune(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM data_exports
+		WHERE expires_at < $1 OR (status = 'failed' AND created_at < $1)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
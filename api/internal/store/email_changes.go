@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EmailChange is a request to change the email of a user. It applies once
+// the new address is confirmed with Token. CancelToken is sent to the old
+// address and cancels the request, or reverts the change if it was already
+// confirmed, until CancelExpiresAt.
+//
+// Only the SHA-256 digests of the tokens are stored.
+type EmailChange struct {
+	ID              string       `json:"id"`
+	UserID          string       `json:"user_id"`
+	OldEmail        string       `json:"old_email"`
+	NewEmail        string       `json:"new_email"`
+	Token           string       `json:"-"`
+	CancelToken     string       `json:"-"`
+	ExpiresAt       time.Time    `json:"expires_at"`
+	CancelExpiresAt time.Time    `json:"cancel_expires_at"`
+	ConfirmedAt     sql.NullTime `json:"confirmed_at"`
+	CancelledAt     sql.NullTime `json:"cancelled_at"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+type EmailChangeStore struct {
+	db *sql.DB
+}
+
+// Create stores the request and withdraws the pending ones of the user, so
+// only the latest confirmation link works.
+func (s *EmailChangeStore) Create(ctx context.Context, change *EmailChange) error {
+	changeID, err := generateId("emailchange")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE email_changes SET cancelled_at = NOW()
+			WHERE user_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+		`
+
+		if _, err := tx.ExecContext(ctx, query, change.UserID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO email_changes (id, user_id, old_email, new_email, token_hash, cancel_token_hash, expires_at, cancel_expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING created_at
+		`
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			changeID,
+			change.UserID,
+			change.OldEmail,
+			change.NewEmail,
+			hashToken(change.Token),
+			hashToken(change.CancelToken),
+			change.ExpiresAt,
+			change.CancelExpiresAt,
+		).Scan(
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		change.ID = changeID
+
+		return nil
+	})
+}
+
+// Confirm applies a pending change. The email counts as verified and every
+// session of the user ends. It returns ErrNotFound when the token is unknown,
+// expired or already used, and ErrDuplicateEmail when the address was taken
+// in the meantime.
+func (s *EmailChangeStore) Confirm(ctx context.Context, token string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	change := &EmailChange{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE email_changes SET confirmed_at = NOW()
+			WHERE token_hash = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL AND expires_at > NOW()
+			RETURNING ` + emailChangeColumns
+
+		if err := scanEmailChange(tx.QueryRowContext(ctx, query, hashToken(token)), change); err != nil {
+			return err
+		}
+
+		// The email must still be the one the change was requested for.
+		return setEmail(ctx, tx, change.UserID, change.OldEmail, change.NewEmail)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// Cancel withdraws a pending change or, when it was already confirmed,
+// restores the old email and ends every session of the user. It returns
+// ErrNotFound when the token is unknown, expired or already used.
+func (s *EmailChangeStore) Cancel(ctx context.Context, cancelToken string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	change := &EmailChange{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE email_changes SET cancelled_at = NOW()
+			WHERE cancel_token_hash = $1 AND cancelled_at IS NULL AND cancel_expires_at > NOW()
+			RETURNING ` + emailChangeColumns
+
+		if err := scanEmailChange(tx.QueryRowContext(ctx, query, hashToken(cancelToken)), change); err != nil {
+			return err
+		}
+
+		if !change.ConfirmedAt.Valid {
+			return nil
+		}
+
+		return setEmail(ctx, tx, change.UserID, change.NewEmail, change.OldEmail)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+const emailChangeColumns = `id, user_id, old_email, new_email, expires_at, cancel_expires_at, confirmed_at, cancelled_at, created_at`
+
+func scanEmailChange(row *sql.Row, change *EmailChange) error {
+	err := row.Scan(
+		&change.ID,
+		&change.UserID,
+		&change.OldEmail,
+		&change.NewEmail,
+		&change.ExpiresAt,
+		&change.CancelExpiresAt,
+		&change.ConfirmedAt,
+		&change.CancelledAt,
+		&change.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// setEmail moves the user from one email to another, signing them out
+// everywhere.
+func setEmail(ctx context.Context, tx *sql.Tx, userID, from, to string) error {
+	query := `
+		UPDATE users
+		SET email = $3, email_verified_at = NOW(), refresh_token_version = refresh_token_version + 1
+		WHERE id = $1 AND email = $2
+	`
+
+	res, err := tx.ExecContext(ctx, query, userID, from, to)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	query = `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// What an EmailOTP is sent for. A code only works for its purpose.
+const (
+	EmailOTPLogin           = "login"
+	EmailOTPAccountDeletion = "account_deletion"
+	EmailOTPEmailChange     = "email_change"
+)
+
+// EmailOTP is a one-time code sent by email, to sign in or to confirm an
+// action. Codes are hashed with bcrypt, the same way user passwords are, and
+// stop working after too many wrong attempts.
+type EmailOTP struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Purpose   string         `json:"purpose"`
+	Code      password       `json:"-"`
+	Attempts  int            `json:"attempts"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UsedAt    sql.NullString `json:"used_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type EmailOTPStore struct {
+	db *sql.DB
+}
+
+// Create stores a new code for the user and invalidates the previous ones
+// for the same purpose, so only the code of the latest email works.
+func (s *EmailOTPStore) Create(ctx context.Context, otp *EmailOTP, code string) error {
+	if err := otp.Code.Set(code); err != nil {
+		return err
+	}
+
+	otpID, err := generateId("otp")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE email_otps SET used_at = NOW()
+			WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+		`
+
+		if _, err := tx.ExecContext(ctx, query, otp.UserID, otp.Purpose); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO email_otps (id, user_id, purpose, code_hash, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING attempts, created_at
+		`
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			otpID,
+			otp.UserID,
+			otp.Purpose,
+			otp.Code.hash,
+			otp.ExpiresAt,
+		).Scan(
+			&otp.Attempts,
+			&otp.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		otp.ID = otpID
+
+		return nil
+	})
+}
+
+// GetLatest returns the most recently sent code of the user for the purpose,
+// whether it is still usable or not.
+func (s *EmailOTPStore) GetLatest(ctx context.Context, userID, purpose string) (*EmailOTP, error) {
+	query := `
+		SELECT id, user_id, purpose, code_hash, attempts, expires_at, used_at, created_at
+		FROM email_otps
+		WHERE user_id = $1 AND purpose = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	otp := &EmailOTP{}
+	err := s.db.QueryRowContext(ctx, query, userID, purpose).Scan(
+		&otp.ID,
+		&otp.UserID,
+		&otp.Purpose,
+		&otp.Code.hash,
+		&otp.Attempts,
+		&otp.ExpiresAt,
+		&otp.UsedAt,
+		&otp.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return otp, nil
+}
+
+// Verify checks the code against the usable code of the user for the
+// purpose. An attempt is
+// counted before the code is compared, so that concurrent guesses cannot go
+// past maxAttempts, after which the code stops working. ErrNotFound is
+// returned for wrong codes and when no usable code exists.
+func (s *EmailOTPStore) Verify(ctx context.Context, userID, purpose, code string, maxAttempts int) error {
+	query := `
+		UPDATE email_otps SET attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM email_otps
+			WHERE user_id = $1 AND purpose = $2
+			ORDER BY created_at DESC
+			LIMIT 1
+		) AND used_at IS NULL AND expires_at > NOW() AND attempts < $3
+		RETURNING id, code_hash
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	otp := &EmailOTP{}
+	err := s.db.QueryRowContext(ctx, query, userID, purpose, maxAttempts).Scan(&otp.ID, &otp.Code.hash)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	if err := otp.Code.Compare(code); err != nil {
+		return ErrNotFound
+	}
+
+	// The code may have been used by a concurrent request in the meantime.
+	query = `UPDATE email_otps SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`
+
+	res, err := s.db.ExecContext(ctx, query, otp.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type EmailVerification struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UsedAt    sql.NullString `json:"used_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type EmailVerificationStore struct {
+	db *sql.DB
+}
+
+func (s *EmailVerificationStore) Create(ctx context.Context, verification *EmailVerification) error {
+	query := `
+		INSERT INTO email_verifications (id, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	verificationID, err := generateId("emailver")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		verificationID,
+		verification.UserID,
+		verification.ExpiresAt,
+	).Scan(
+		&verification.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	verification.ID = verificationID
+
+	return nil
+}
+
+// Verify consumes the verification and marks the owner's email as verified.
+// It returns ErrNotFound when the verification does not exist, belongs to
+// another user, has expired or was already used.
+func (s *EmailVerificationStore) Verify(ctx context.Context, id, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE email_verifications
+			SET used_at = NOW()
+			WHERE id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW()
+		`
+
+		res, err := tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		query = `
+			UPDATE users
+			SET email_verified_at = NOW()
+			WHERE id = $1 AND email_verified_at IS NULL
+		`
+
+		_, err = tx.ExecContext(ctx, query, userID)
+
+		return err
+	})
+}
+
+// InvalidateForUser marks every pending verification of the user as used so
+// that only the most recently sent link keeps working.
+func (s *EmailVerificationStore) InvalidateForUser(ctx context.Context, userID string) error {
+	query := `
+		UPDATE email_verifications SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+
+	return err
+}
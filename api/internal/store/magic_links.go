@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MagicLink signs in the owner of Email. UserID is not set when the link
+// signs up a new account. When DeviceHash is set, the link can only be used
+// by the client holding the device token it is the hash of.
+type MagicLink struct {
+	ID         string         `json:"id"`
+	Email      string         `json:"email"`
+	UserID     sql.NullString `json:"user_id"`
+	Token      string         `json:"-"`
+	DeviceHash string         `json:"-"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	UsedAt     sql.NullString `json:"used_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+func (l *MagicLink) IsSignUp() bool {
+	return !l.UserID.Valid
+}
+
+type MagicLinkStore struct {
+	db *sql.DB
+}
+
+func (s *MagicLinkStore) Create(ctx context.Context, link *MagicLink) error {
+	query := `
+		INSERT INTO magic_links (id, email, user_id, token_hash, device_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	linkID, err := generateId("magiclink")
+	if err != nil {
+		return err
+	}
+
+	var deviceHash sql.NullString
+	if link.DeviceHash != "" {
+		deviceHash = sql.NullString{String: link.DeviceHash, Valid: true}
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		linkID,
+		link.Email,
+		link.UserID,
+		hashToken(link.Token),
+		deviceHash,
+		link.ExpiresAt,
+	).Scan(
+		&link.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	link.ID = linkID
+
+	return nil
+}
+
+// Get returns the usable link matching the plaintext token and device token
+// without consuming it. Expired, used or foreign-device links are reported as
+// ErrNotFound.
+func (s *MagicLinkStore) Get(ctx context.Context, token, deviceToken string) (*MagicLink, error) {
+	query := `
+		SELECT id, email, user_id, expires_at, used_at, created_at
+		FROM magic_links
+		WHERE token_hash = $1 AND (device_hash IS NULL OR device_hash = $2)
+		  AND used_at IS NULL AND expires_at > NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return scanMagicLink(ctx, s.db, query, token, deviceToken)
+}
+
+// Consume marks the link as used and returns it. It fails with ErrNotFound
+// under the same conditions as Get, so a link can only be consumed once.
+func (s *MagicLinkStore) Consume(ctx context.Context, token, deviceToken string) (*MagicLink, error) {
+	query := `
+		UPDATE magic_links
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND (device_hash IS NULL OR device_hash = $2)
+		  AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, email, user_id, expires_at, used_at, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return scanMagicLink(ctx, s.db, query, token, deviceToken)
+}
+
+// ConsumeSignUp consumes a sign-up link and creates the account it signs up
+// for, with its email verified, in one transaction. The link is left unused
+// when the account cannot be created, e.g. because the username is taken,
+// and the account is never sent a verification email. It fails with
+// ErrNotFound under the same conditions as Consume, and for links that sign
+// in an existing account.
+func (s *MagicLinkStore) ConsumeSignUp(ctx context.Context, token, deviceToken string, user *User) (*MagicLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var link *MagicLink
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE magic_links
+			SET used_at = NOW()
+			WHERE token_hash = $1 AND (device_hash IS NULL OR device_hash = $2)
+			  AND used_at IS NULL AND expires_at > NOW() AND user_id IS NULL
+			RETURNING id, email, user_id, expires_at, used_at, created_at
+		`
+
+		var err error
+		link, err = scanMagicLink(ctx, tx, query, token, deviceToken)
+		if err != nil {
+			return err
+		}
+
+		user.Email = link.Email
+
+		if err := createUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		query = `UPDATE users SET email_verified_at = NOW() WHERE id = $1 RETURNING email_verified_at`
+
+		if err := tx.QueryRowContext(ctx, query, user.ID).Scan(&user.EmailVerifiedAt); err != nil {
+			return err
+		}
+
+		return addOutboxEvent(ctx, tx, EventUserRegistered, user.ID, newUserEvent(user))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func scanMagicLink(ctx context.Context, q querier, query, token, deviceToken string) (*MagicLink, error) {
+	link := &MagicLink{}
+	err := q.QueryRowContext(
+		ctx,
+		query,
+		hashToken(token),
+		hashToken(deviceToken),
+	).Scan(
+		&link.ID,
+		&link.Email,
+		&link.UserID,
+		&link.ExpiresAt,
+		&link.UsedAt,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return link, nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MFAChallenge backs the token handed out after a correct first factor. It
+// counts the codes tried against it, so that the second factor cannot be
+// guessed for as long as the token lasts.
+type MFAChallenge struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Attempts  int            `json:"attempts"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UsedAt    sql.NullString `json:"used_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type MFAChallengeStore struct {
+	db *sql.DB
+}
+
+func (s *MFAChallengeStore) Create(ctx context.Context, challenge *MFAChallenge) error {
+	query := `
+		INSERT INTO mfa_challenges (id, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING attempts, created_at
+	`
+
+	challengeID, err := generateId("mfac")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		challengeID,
+		challenge.UserID,
+		challenge.ExpiresAt,
+	).Scan(
+		&challenge.Attempts,
+		&challenge.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	challenge.ID = challengeID
+
+	return nil
+}
+
+// Attempt reserves one of the maxAttempts codes that can be tried against the
+// challenge. The attempt is counted before the code is checked, so that
+// concurrent guesses cannot go past the limit. It returns ErrNotFound when
+// the challenge is used, expired or out of attempts.
+func (s *MFAChallengeStore) Attempt(ctx context.Context, id, userID string, maxAttempts int) error {
+	query := `
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE id = $1 AND user_id = $2 AND used_at IS NULL AND expires_at > NOW() AND attempts < $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID, maxAttempts)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Consume burns the challenge once it has been passed. It returns ErrNotFound
+// when it was already used.
+func (s *MFAChallengeStore) Consume(ctx context.Context, id string) error {
+	query := `
+		UPDATE mfa_challenges SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Prune deletes the challenges that expired before the given time.
+func (s *MFAChallengeStore) Prune(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM mfa_challenges WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Domain events written to the outbox by the store, in the same transaction
+// as the change they describe.
+const (
+	EventUserRegistered = "user.registered"
+	EventUserBlocked    = "user.blocked"
+	EventUserUnblocked  = "user.unblocked"
+	EventUserDeleted    = "user.deleted"
+	EventUserRestored   = "user.restored"
+	EventUserPurged     = "user.purged"
+)
+
+// Requests for an email to an address that may or may not belong to an
+// account. The handlers look the account up and send the email, so that the
+// request takes the same time whether the address is registered or not.
+const (
+	EventPasswordResetRequested     = "password_reset.requested"
+	EventVerificationEmailRequested = "verification_email.requested"
+	EventEmailOTPRequested          = "email_otp.requested"
+	EventMagicLinkRequested         = "magic_link.requested"
+)
+
+// EmailRequest is the payload of the email requests.
+type EmailRequest struct {
+	Email string `json:"email"`
+	// DeviceToken binds the link of EventMagicLinkRequested to the client
+	// that asked for it. Only its hash is written, as DeviceHash.
+	DeviceToken string `json:"-"`
+	DeviceHash  string `json:"device_hash,omitempty"`
+}
+
+// UserEvent is the payload of the user events. It is a snapshot of the user
+// when the event happened, as the account may have changed, or be gone, by
+// the time the event is handled. EventUserPurged only carries the UserID and
+// AvatarKey, nothing personal is kept about a purged account.
+type UserEvent struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email,omitempty"`
+	Username  string `json:"username,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	// Reason is set on EventUserBlocked.
+	Reason string `json:"reason,omitempty"`
+	// AvatarKey is set on EventUserPurged, the avatar blobs are left to the
+	// handlers to delete.
+	AvatarKey string `json:"avatar_key,omitempty"`
+}
+
+func newUserEvent(user *User) *UserEvent {
+	return &UserEvent{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+}
+
+// OutboxEvent is a domain event waiting to be handled. Its ID is the
+// idempotency key of the event: it stays the same across attempts, which
+// may happen more than once.
+type OutboxEvent struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// addOutboxEvent writes an event to the outbox. q is the transaction of the
+// change the event describes, so that both are saved or neither.
+func addOutboxEvent(ctx context.Context, q querier, event, aggregateID string, payload any) error {
+	query := `
+		INSERT INTO outbox_events (id, event, aggregate_id, payload)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	eventID, err := generateId("evt")
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, query, eventID, event, aggregateID, data)
+
+	return err
+}
+
+type OutboxStore struct {
+	db *sql.DB
+}
+
+// RequestEmail writes an email request. Unlike the domain events it does not
+// come with a change.
+func (s *OutboxStore) RequestEmail(ctx context.Context, event string, request *EmailRequest) error {
+	if request.DeviceToken != "" {
+		request.DeviceHash = hashToken(request.DeviceToken)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return addOutboxEvent(ctx, s.db, event, request.Email, request)
+}
+
+// Claim leases up to limit due events, oldest first, and counts an attempt
+// for each. Other relays skip them until the lease is over, after which an
+// event that was not completed, e.g. after a crash, is claimed again.
+func (s *OutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	query := `
+		UPDATE outbox_events
+		SET attempts = attempts + 1, locked_until = $2
+		WHERE id IN (
+			SELECT id FROM outbox_events
+			WHERE processed_at IS NULL AND failed_at IS NULL
+				AND next_attempt_at <= NOW()
+				AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY created_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event, aggregate_id, payload, attempts, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		event := &OutboxEvent{}
+		var payload []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.Event,
+			&event.AggregateID,
+			&payload,
+			&event.Attempts,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// HandledBy returns the handlers that already handled the event.
+func (s *OutboxStore) HandledBy(ctx context.Context, eventID string) ([]string, error) {
+	query := `SELECT ARRAY(SELECT handler FROM outbox_handled WHERE event_id = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var handlers []string
+	if err := s.db.QueryRowContext(ctx, query, eventID).Scan(pq.Array(&handlers)); err != nil {
+		return nil, err
+	}
+
+	return handlers, nil
+}
+
+// MarkHandled records that the handler is done with the event, so that it
+// is not run again when the event is retried for another handler.
+func (s *OutboxStore) MarkHandled(ctx context.Context, eventID, handler string) error {
+	query := `
+		INSERT INTO outbox_handled (event_id, handler)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, eventID, handler)
+
+	return err
+}
+
+// Complete marks the event as handled by every handler.
+func (s *OutboxStore) Complete(ctx context.Context, eventID string) error {
+	query := `
+		UPDATE outbox_events
+		SET processed_at = NOW(), locked_until = NULL, last_error = NULL
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, eventID)
+
+	return err
+}
+
+// Retry releases the event until nextAttemptAt.
+func (s *OutboxStore) Retry(ctx context.Context, eventID string, nextAttemptAt time.Time, reason string) error {
+	query := `
+		UPDATE outbox_events
+		SET next_attempt_at = $2, locked_until = NULL, last_error = $3
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, eventID, nextAttemptAt, reason)
+
+	return err
+}
+
+// Fail gives up on the event. It is kept for inspection but never claimed
+// again.
+func (s *OutboxStore) Fail(ctx context.Context, eventID, reason string) error {
+	query := `
+		UPDATE outbox_events
+		SET failed_at = NOW(), locked_until = NULL, last_error = $2
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, eventID, reason)
+
+	return err
+}
+
+// Prune deletes the events processed before the given time.
+func (s *OutboxStore) Prune(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM outbox_events WHERE processed_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PasswordReset struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Token     string         `json:"-"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	UsedAt    sql.NullString `json:"used_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+func (s *PasswordResetStore) Create(ctx context.Context, reset *PasswordReset) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return createPasswordReset(ctx, s.db, reset)
+}
+
+func createPasswordReset(ctx context.Context, q querier, reset *PasswordReset) error {
+	query := `
+		INSERT INTO password_resets (id, user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`
+
+	resetID, err := generateId("pwreset")
+	if err != nil {
+		return err
+	}
+
+	err = q.QueryRowContext(
+		ctx,
+		query,
+		resetID,
+		reset.UserID,
+		hashToken(reset.Token),
+		reset.ExpiresAt,
+	).Scan(
+		&reset.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	reset.ID = resetID
+
+	return nil
+}
+
+// Reset consumes the reset matching the plaintext token and sets the new
+// password of user, which is filled in with the ID and email of its owner.
+// In the same transaction the token version of the owner is bumped, so that
+// its sessions end, and its other resets are invalidated, so that the token
+// is never spent without the password being changed. Expired or already used
+// resets, and resets of blocked users, are reported as ErrNotFound.
+func (s *PasswordResetStore) Reset(ctx context.Context, token string, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE password_resets
+			SET used_at = NOW()
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+			RETURNING user_id
+		`
+
+		err := tx.QueryRowContext(ctx, query, hashToken(token)).Scan(&user.ID)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		query = `
+			UPDATE users
+			SET password = $1, refresh_token_version = refresh_token_version + 1
+			WHERE id = $2 AND is_blocked = false
+			RETURNING email, refresh_token_version
+		`
+
+		err = tx.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.Email, &user.RefreshTokenVersion)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, user.ID)
+
+		return err
+	})
+}
+
+func (s *PasswordResetStore) InvalidateForUser(ctx context.Context, userID string) error {
+	query := `
+		UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RecoveryCode is a one-time MFA fallback code. Codes are hashed with bcrypt,
+// the same way user passwords are.
+type RecoveryCode struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Code      password       `json:"-"`
+	UsedAt    sql.NullString `json:"used_at"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type RecoveryCodeStore struct {
+	db *sql.DB
+}
+
+// Replace deletes every recovery code of the user and stores the new set.
+func (s *RecoveryCodeStore) Replace(ctx context.Context, userID string, codes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	hashed := make([]RecoveryCode, len(codes))
+	for i, code := range codes {
+		if err := hashed[i].Code.Set(code); err != nil {
+			return err
+		}
+
+		id, err := generateId("recovery")
+		if err != nil {
+			return err
+		}
+		hashed[i].ID = id
+	}
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM mfa_recovery_codes WHERE user_id = $1
+		`
+
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO mfa_recovery_codes (id, user_id, code_hash)
+			VALUES ($1, $2, $3)
+		`
+
+		for _, code := range hashed {
+			if _, err := tx.ExecContext(ctx, query, code.ID, userID, code.Code.hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Use marks the unused recovery code matching the plaintext code as used. It
+// returns ErrNotFound when no unused code matches.
+func (s *RecoveryCodeStore) Use(ctx context.Context, userID, code string) error {
+	query := `
+		SELECT id, code_hash
+		FROM mfa_recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var candidates []RecoveryCode
+	for rows.Next() {
+		var candidate RecoveryCode
+		if err := rows.Scan(&candidate.ID, &candidate.Code.hash); err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Code.Compare(code) != nil {
+			continue
+		}
+
+		query := `
+			UPDATE mfa_recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL
+		`
+
+		res, err := s.db.ExecContext(ctx, query, candidate.ID)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+func (s *RecoveryCodeStore) CountRemaining(ctx context.Context, userID string) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *RecoveryCodeStore) DeleteForUser(ctx context.Context, userID string) error {
+	query := `
+		DELETE FROM mfa_recovery_codes WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -3,45 +3,67 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
+var ErrRefreshTokenReused = errors.New("refresh token already rotated")
+
+// RefreshToken belongs to a family that starts at login and is carried over
+// on every rotation. ReplacedBy is set once the token has been rotated, so a
+// replay of a rotated token can be told apart from a plain revocation.
+//
+// Only the SHA-256 digest of Token is stored. Token is set when the token is
+// created and never read back from the database.
 type RefreshToken struct {
-	ID        string         `json:"id"`
-	UserID    string         `json:"user_id"`
-	Token     string         `json:"token"`
-	Version   int            `json:"version"`
-	ExpiresAt time.Time      `json:"expires_at"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	RevokedAt sql.NullString `json:"revoked_at"`
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id"`
+	FamilyID   string         `json:"family_id"`
+	Token      string         `json:"-"`
+	Version    int            `json:"version"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	RevokedAt  sql.NullString `json:"revoked_at"`
+	ReplacedBy sql.NullString `json:"replaced_by"`
 }
 
 type RefreshTokenStore struct {
 	db *sql.DB
 }
 
+// Create stores the token. A token without a family starts a new one.
 func (s *RefreshTokenStore) Create(ctx context.Context, refresh_token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return createRefreshToken(ctx, s.db, refresh_token)
+}
+
+func createRefreshToken(ctx context.Context, q querier, refresh_token *RefreshToken) error {
 	query := `
-	INSERT INTO refresh_tokens (id, user_id, token, version, expires_at)
-	VALUES ($1, $2, $3, $4, $5)
+	INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, version, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING created_at, updated_at, revoked_at
 	`
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
 	refreshTokenId, err := generateId("reftoken")
 	if err != nil {
 		return err
 	}
 
-	err = s.db.QueryRowContext(
+	familyID := refresh_token.FamilyID
+	if familyID == "" {
+		familyID = refreshTokenId
+	}
+
+	err = q.QueryRowContext(
 		ctx,
 		query,
 		refreshTokenId,
 		refresh_token.UserID,
-		refresh_token.Token,
+		familyID,
+		hashToken(refresh_token.Token),
 		refresh_token.Version,
 		refresh_token.ExpiresAt,
 	).Scan(
@@ -49,22 +71,21 @@ func (s *RefreshTokenStore) Create(ctx context.Context, refresh_token *RefreshTo
 		&refresh_token.UpdatedAt,
 		&refresh_token.RevokedAt,
 	)
-
-	refresh_token.ID = refreshTokenId
-
 	if err != nil {
 		return err
 	}
 
-	return nil
+	refresh_token.ID = refreshTokenId
+	refresh_token.FamilyID = familyID
 
+	return nil
 }
 
 func (s *RefreshTokenStore) GetByToken(ctx context.Context, refresh_token string) (*RefreshToken, error) {
 	query := `
-		SELECT id, user_id, token, version, expires_at, created_at, updated_at, revoked_at
+		SELECT id, user_id, family_id, version, expires_at, created_at, updated_at, revoked_at, replaced_by
 		FROM refresh_tokens
-		WHERE token = $1
+		WHERE token_hash = $1
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -74,16 +95,17 @@ func (s *RefreshTokenStore) GetByToken(ctx context.Context, refresh_token string
 	err := s.db.QueryRowContext(
 		ctx,
 		query,
-		refresh_token,
+		hashToken(refresh_token),
 	).Scan(
 		&refreshToken.ID,
 		&refreshToken.UserID,
-		&refreshToken.Token,
+		&refreshToken.FamilyID,
 		&refreshToken.Version,
 		&refreshToken.ExpiresAt,
 		&refreshToken.CreatedAt,
 		&refreshToken.UpdatedAt,
 		&refreshToken.RevokedAt,
+		&refreshToken.ReplacedBy,
 	)
 	if err != nil {
 		switch err {
@@ -113,3 +135,98 @@ func (s *RefreshTokenStore) RevokeTokenByID(ctx context.Context, id string) erro
 
 	return err
 }
+
+// Rotate revokes the old token and stores its successor in the same family.
+// It returns ErrRefreshTokenReused when the old token is no longer live,
+// which happens when two requests race to rotate the same token.
+func (s *RefreshTokenStore) Rotate(ctx context.Context, old *RefreshToken, next *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		next.FamilyID = old.FamilyID
+
+		if err := createRefreshToken(ctx, tx, next); err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE refresh_tokens
+			SET revoked_at = NOW(), replaced_by = $2
+			WHERE id = $1 AND revoked_at IS NULL
+		`
+
+		res, err := tx.ExecContext(ctx, query, old.ID, next.ID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		return nil
+	})
+}
+
+// RevokeFamily revokes every live token descending from the same login.
+func (s *RefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, familyID)
+
+	return err
+}
+
+// ListForUser returns every refresh token of the user, newest first.
+func (s *RefreshTokenStore) ListForUser(ctx context.Context, userID string) ([]*RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, version, expires_at, created_at, updated_at, revoked_at, replaced_by
+		FROM refresh_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*RefreshToken{}
+	for rows.Next() {
+		token := &RefreshToken{}
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.FamilyID,
+			&token.Version,
+			&token.ExpiresAt,
+			&token.CreatedAt,
+			&token.UpdatedAt,
+			&token.RevokedAt,
+			&token.ReplacedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Session is a login on one device. Its ID is the family ID of the refresh
+// tokens issued for it, so revoking a session revokes its refresh tokens.
+type Session struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id"`
+	DeviceName string         `json:"device_name"`
+	Platform   string         `json:"platform"`
+	UserAgent  string         `json:"user_agent"`
+	IP         string         `json:"ip"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	LastUsedAt time.Time      `json:"last_used_at"`
+	RevokedAt  sql.NullString `json:"revoked_at"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, device_name, platform, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at, updated_at, last_used_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	sessionID, err := generateId("session")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		sessionID,
+		session.UserID,
+		session.DeviceName,
+		session.Platform,
+		session.UserAgent,
+		session.IP,
+	).Scan(
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.LastUsedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.ID = sessionID
+
+	return nil
+}
+
+func (s *SessionStore) GetByID(ctx context.Context, id string) (*Session, error) {
+	query := `
+		SELECT id, user_id, device_name, platform, user_agent, ip, created_at, updated_at, last_used_at, revoked_at
+		FROM sessions
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	session := &Session{}
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.DeviceName,
+		&session.Platform,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.LastUsedAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// ListActive returns the sessions of the user that still hold a live refresh
+// token, most recently used first.
+func (s *SessionStore) ListActive(ctx context.Context, userID string) ([]*Session, error) {
+	query := `
+		SELECT s.id, s.user_id, s.device_name, s.platform, s.user_agent, s.ip, s.created_at, s.updated_at, s.last_used_at, s.revoked_at
+		FROM sessions s
+		WHERE s.user_id = $1
+		  AND s.revoked_at IS NULL
+		  AND EXISTS (
+		    SELECT 1 FROM refresh_tokens rt
+		    JOIN users u ON u.id = rt.user_id
+		    WHERE rt.family_id = s.id
+		      AND rt.revoked_at IS NULL
+		      AND rt.expires_at > NOW()
+		      AND rt.version = u.refresh_token_version
+		  )
+		ORDER BY s.last_used_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSessions(rows)
+}
+
+// ListForUser returns every session of the user, including ended ones,
+// newest first.
+func (s *SessionStore) ListForUser(ctx context.Context, userID string) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, device_name, platform, user_agent, ip, created_at, updated_at, last_used_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSessions(rows)
+}
+
+func scanSessions(rows *sql.Rows) ([]*Session, error) {
+	sessions := []*Session{}
+	for rows.Next() {
+		session := &Session{}
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.DeviceName,
+			&session.Platform,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+			&session.LastUsedAt,
+			&session.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Touch records that the session was used from the given address.
+func (s *SessionStore) Touch(ctx context.Context, id, ip, userAgent string) error {
+	query := `
+		UPDATE sessions SET last_used_at = NOW(), ip = $2, user_agent = $3
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, ip, userAgent)
+
+	return err
+}
+
+// Revoke ends a session of the user along with its refresh tokens. It
+// returns ErrNotFound when the user owns no such session.
+func (s *SessionStore) Revoke(ctx context.Context, id, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW())
+			WHERE id = $1 AND user_id = $2
+		`
+
+		res, err := tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+
+		sessions, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+		`
+
+		res, err = tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if sessions == 0 && tokens == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+// RevokeOthers ends every session of the user except the given one.
+func (s *SessionStore) RevokeOthers(ctx context.Context, userID, keepID string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+		`
+
+		if _, err := tx.ExecContext(ctx, query, userID, keepID); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+		`
+
+		_, err := tx.ExecContext(ctx, query, userID, keepID)
+
+		return err
+	})
+}
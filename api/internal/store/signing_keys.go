@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SigningKey is a JWT signing key of the key ring. PrivateKey holds the
+// encrypted PKCS#8 encoding of the key.
+type SigningKey struct {
+	ID            string       `json:"id"`
+	Algorithm     string       `json:"algorithm"`
+	PrivateKey    []byte       `json:"-"`
+	Status        string       `json:"status"`
+	ActivatedAt   sql.NullTime `json:"activated_at"`
+	DeactivatedAt sql.NullTime `json:"deactivated_at"`
+	RetiredAt     sql.NullTime `json:"retired_at"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+type SigningKeyStore struct {
+	db *sql.DB
+}
+
+func (s *SigningKeyStore) Create(ctx context.Context, key *SigningKey) error {
+	query := `
+		INSERT INTO signing_keys (id, algorithm, private_key, status)
+		VALUES ($1, $2, $3, 'pending')
+		RETURNING status, activated_at, deactivated_at, retired_at, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	keyID, err := generateId("key")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		keyID,
+		key.Algorithm,
+		key.PrivateKey,
+	).Scan(
+		&key.Status,
+		&key.ActivatedAt,
+		&key.DeactivatedAt,
+		&key.RetiredAt,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	key.ID = keyID
+
+	return nil
+}
+
+// List returns every key, newest first. Retired keys are only included when
+// includeRetired is set.
+func (s *SigningKeyStore) List(ctx context.Context, includeRetired bool) ([]*SigningKey, error) {
+	query := `
+		SELECT id, algorithm, private_key, status, activated_at, deactivated_at, retired_at, created_at, updated_at
+		FROM signing_keys
+		WHERE $1 OR status <> 'retired'
+		ORDER BY created_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, includeRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*SigningKey{}
+	for rows.Next() {
+		key := &SigningKey{}
+		err := rows.Scan(
+			&key.ID,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.Status,
+			&key.ActivatedAt,
+			&key.DeactivatedAt,
+			&key.RetiredAt,
+			&key.CreatedAt,
+			&key.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Promote makes a pending or verify-only key the active one. The previously
+// active key is demoted to verify-only so the tokens it signed stay valid.
+func (s *SigningKeyStore) Promote(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE signing_keys
+			SET status = 'verify', deactivated_at = NOW()
+			WHERE status = 'active' AND id <> $1
+		`
+
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE signing_keys
+			SET status = 'active', activated_at = NOW(), deactivated_at = NULL
+			WHERE id = $1 AND status IN ('pending', 'verify', 'active')
+		`
+
+		res, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		return nil
+	})
+}
+
+// Retire stops a key from verifying tokens. The active key cannot be retired
+// and ErrConflict is returned instead.
+func (s *SigningKeyStore) Retire(ctx context.Context, id string) error {
+	query := `
+		UPDATE signing_keys
+		SET status = 'retired', retired_at = NOW()
+		WHERE id = $1 AND status IN ('pending', 'verify')
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
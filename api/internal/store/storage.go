@@ -2,7 +2,9 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -22,21 +24,213 @@ type Storage struct {
 		GetByEmail(ctx context.Context, email string) (*User, error)
 		GetByID(context.Context, string) (*User, error)
 		IncreaseTokenVersion(context.Context, *User) error
+		UpdatePassword(context.Context, *User) error
+		UpdateProfile(context.Context, *User) error
+		CreateWithWebAuthnCredential(context.Context, *User, *WebAuthnCredential) error
+		ClaimUnverified(ctx context.Context, userID string) error
+		Delete(context.Context, *User) error
+		Restore(ctx context.Context, user *User, deletedAfter time.Time) error
+		Purge(ctx context.Context, deletedBefore time.Time) ([]*User, error)
+		SetAvatar(ctx context.Context, user *User, key sql.NullString) (sql.NullString, error)
 	}
 	RefreshTokens interface {
 		Create(context.Context, *RefreshToken) error
 		GetByToken(context.Context, string) (*RefreshToken, error)
 		RevokeTokenByID(context.Context, string) error
+		Rotate(ctx context.Context, old *RefreshToken, next *RefreshToken) error
+		RevokeFamily(ctx context.Context, familyID string) error
+		ListForUser(ctx context.Context, userID string) ([]*RefreshToken, error)
+	}
+	Sessions interface {
+		Create(context.Context, *Session) error
+		GetByID(ctx context.Context, id string) (*Session, error)
+		ListActive(ctx context.Context, userID string) ([]*Session, error)
+		ListForUser(ctx context.Context, userID string) ([]*Session, error)
+		Touch(ctx context.Context, id, ip, userAgent string) error
+		Revoke(ctx context.Context, id, userID string) error
+		RevokeOthers(ctx context.Context, userID, keepID string) error
+	}
+	EmailVerifications interface {
+		Create(context.Context, *EmailVerification) error
+		Verify(ctx context.Context, id, userID string) error
+		InvalidateForUser(ctx context.Context, userID string) error
+	}
+	PasswordResets interface {
+		Create(context.Context, *PasswordReset) error
+		Reset(ctx context.Context, token string, user *User) error
+		InvalidateForUser(ctx context.Context, userID string) error
+	}
+	EmailChanges interface {
+		Create(context.Context, *EmailChange) error
+		Confirm(ctx context.Context, token string) (*EmailChange, error)
+		Cancel(ctx context.Context, cancelToken string) (*EmailChange, error)
+	}
+	MagicLinks interface {
+		Create(context.Context, *MagicLink) error
+		Get(ctx context.Context, token, deviceToken string) (*MagicLink, error)
+		Consume(ctx context.Context, token, deviceToken string) (*MagicLink, error)
+		ConsumeSignUp(ctx context.Context, token, deviceToken string, user *User) (*MagicLink, error)
+	}
+	EmailOTPs interface {
+		Create(ctx context.Context, otp *EmailOTP, code string) error
+		GetLatest(ctx context.Context, userID, purpose string) (*EmailOTP, error)
+		Verify(ctx context.Context, userID, purpose, code string, maxAttempts int) error
+	}
+	TOTP interface {
+		UpsertPending(context.Context, *TOTP) error
+		GetByUserID(ctx context.Context, userID string) (*TOTP, error)
+		UseStep(ctx context.Context, userID string, step int64) error
+		Confirm(ctx context.Context, userID string) error
+		Delete(ctx context.Context, userID string) error
+	}
+	MFAChallenges interface {
+		Create(context.Context, *MFAChallenge) error
+		Attempt(ctx context.Context, id, userID string, maxAttempts int) error
+		Consume(ctx context.Context, id string) error
+		Prune(ctx context.Context, before time.Time) error
+	}
+	RecoveryCodes interface {
+		Replace(ctx context.Context, userID string, codes []string) error
+		Use(ctx context.Context, userID, code string) error
+		CountRemaining(ctx context.Context, userID string) (int, error)
+		DeleteForUser(ctx context.Context, userID string) error
+	}
+	WebAuthnCredentials interface {
+		Create(context.Context, *WebAuthnCredential) error
+		GetByUserID(ctx context.Context, userID string) ([]*WebAuthnCredential, error)
+		RecordUse(context.Context, *WebAuthnCredential) error
+		Delete(ctx context.Context, id, userID string, keepOne bool) error
+	}
+	WebAuthnSessions interface {
+		Create(context.Context, *WebAuthnSession) error
+		Consume(ctx context.Context, id, ceremony string) (*WebAuthnSession, error)
+		Prune(ctx context.Context, before time.Time) error
+	}
+	AuthAttempts interface {
+		Record(ctx context.Context, key string) error
+		Window(ctx context.Context, key string, since time.Time, limit int) (*AttemptWindow, error)
+		Clear(ctx context.Context, key string) error
+		Prune(ctx context.Context, before time.Time) error
+	}
+	AccountLockouts interface {
+		Lock(context.Context, *AccountLockout) error
+		GetActive(ctx context.Context, userID string) (*AccountLockout, error)
+		Unlock(ctx context.Context, token string) (*AccountLockout, error)
+		Delete(ctx context.Context, userID string) error
+	}
+	APIKeys interface {
+		Create(context.Context, *APIKey) error
+		GetByKey(ctx context.Context, key string) (*APIKey, error)
+		GetByID(ctx context.Context, id, userID string) (*APIKey, error)
+		GetByUserID(ctx context.Context, userID string) ([]*APIKey, error)
+		Update(context.Context, *APIKey) error
+		RecordUse(ctx context.Context, id, ip string) error
+		Revoke(ctx context.Context, id, userID string) error
+	}
+	Roles interface {
+		List(context.Context) ([]*Role, error)
+		GetAccess(ctx context.Context, userID string) (*Access, error)
+		Assign(ctx context.Context, userID, roleName string) error
+		Unassign(ctx context.Context, userID, roleName string) error
+	}
+	AdminUsers interface {
+		Search(context.Context, UserFilter) ([]*AdminUser, int, error)
+		GetByID(ctx context.Context, id string) (*AdminUser, error)
+		Block(ctx context.Context, user *User, action *AdminAction) error
+		Unblock(ctx context.Context, user *User, action *AdminAction) error
+		ForceLogout(ctx context.Context, user *User, action *AdminAction) error
+		ResetMFA(ctx context.Context, userID string, action *AdminAction) error
+		CreatePasswordReset(ctx context.Context, reset *PasswordReset, action *AdminAction) error
+	}
+	AdminActions interface {
+		ListForUser(ctx context.Context, userID string, limit int) ([]*AdminAction, error)
+	}
+	AuditEvents interface {
+		Create(context.Context, *AuditEvent) error
+		List(context.Context, AuditEventFilter) ([]*AuditEvent, int, error)
+	}
+	DataExports interface {
+		Create(context.Context, *DataExport) error
+		GetByUserID(ctx context.Context, userID string) ([]*DataExport, error)
+		GetReady(ctx context.Context, id string) (*DataExport, error)
+		Claim(context.Context) (*DataExport, error)
+		Complete(context.Context, *DataExport) error
+		Fail(ctx context.Context, id, reason string) error
+		Prune(ctx context.Context, before time.Time) error
+	}
+	WebhookEndpoints interface {
+		Create(context.Context, *WebhookEndpoint) error
+		List(context.Context) ([]*WebhookEndpoint, error)
+		GetByID(ctx context.Context, id string) (*WebhookEndpoint, error)
+		Update(context.Context, *WebhookEndpoint) error
+		Delete(ctx context.Context, id string) error
+	}
+	WebhookDeliveries interface {
+		Enqueue(ctx context.Context, eventID, event string, payload []byte) (int, error)
+		Claim(context.Context) (*WebhookDelivery, error)
+		RecordAttempt(context.Context, *WebhookDelivery, *WebhookAttempt) error
+		ListForEndpoint(ctx context.Context, endpointID, status string, limit, offset int) ([]*WebhookDelivery, int, error)
+		GetByID(ctx context.Context, endpointID, id string) (*WebhookDelivery, error)
+		ListAttempts(ctx context.Context, deliveryID string) ([]*WebhookAttempt, error)
+		Replay(context.Context, *WebhookDelivery) error
+		Prune(ctx context.Context, before time.Time) error
+	}
+	Outbox interface {
+		RequestEmail(ctx context.Context, event string, request *EmailRequest) error
+		Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error)
+		HandledBy(ctx context.Context, eventID string) ([]string, error)
+		MarkHandled(ctx context.Context, eventID, handler string) error
+		Complete(ctx context.Context, eventID string) error
+		Retry(ctx context.Context, eventID string, nextAttemptAt time.Time, reason string) error
+		Fail(ctx context.Context, eventID, reason string) error
+		Prune(ctx context.Context, before time.Time) error
+	}
+	SigningKeys interface {
+		Create(context.Context, *SigningKey) error
+		List(ctx context.Context, includeRetired bool) ([]*SigningKey, error)
+		Promote(ctx context.Context, id string) error
+		Retire(ctx context.Context, id string) error
 	}
 }
 
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
-		Users:         &UserStore{db},
-		RefreshTokens: &RefreshTokenStore{db},
+		Users:               &UserStore{db},
+		RefreshTokens:       &RefreshTokenStore{db},
+		Sessions:            &SessionStore{db},
+		EmailVerifications:  &EmailVerificationStore{db},
+		PasswordResets:      &PasswordResetStore{db},
+		MagicLinks:          &MagicLinkStore{db},
+		EmailOTPs:           &EmailOTPStore{db},
+		TOTP:                &TOTPStore{db},
+		MFAChallenges:       &MFAChallengeStore{db},
+		RecoveryCodes:       &RecoveryCodeStore{db},
+		WebAuthnCredentials: &WebAuthnCredentialStore{db},
+		WebAuthnSessions:    &WebAuthnSessionStore{db},
+		SigningKeys:         &SigningKeyStore{db},
+		AuthAttempts:        &AuthAttemptStore{db},
+		AccountLockouts:     &AccountLockoutStore{db},
+		APIKeys:             &APIKeyStore{db},
+		Roles:               &RoleStore{db},
+		AdminUsers:          &AdminUserStore{db},
+		AdminActions:        &AdminActionStore{db},
+		AuditEvents:         &AuditEventStore{db},
+		DataExports:         &DataExportStore{db},
+		EmailChanges:        &EmailChangeStore{db},
+		WebhookEndpoints:    &WebhookEndpointStore{db},
+		WebhookDeliveries:   &WebhookDeliveryStore{db},
+		Outbox:              &OutboxStore{db},
 	}
 }
 
+// querier is satisfied by both *sql.DB and *sql.Tx so that queries can be
+// shared between standalone calls and transactions.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -61,3 +255,11 @@ func generateId(prefix string) (string, error) {
 	return fmt.Sprintf("%s_%s", prefix, id), nil
 
 }
+
+// hashToken returns the hex encoded SHA-256 digest of a high entropy token.
+// Only digests are persisted so that a database leak does not expose usable
+// tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
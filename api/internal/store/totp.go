@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTOTPCodeReused = errors.New("totp code already used")
+
+// TOTP holds the encrypted authenticator secret of a user. The secret is only
+// active once ConfirmedAt is set.
+type TOTP struct {
+	UserID       string         `json:"user_id"`
+	Secret       []byte         `json:"-"`
+	LastUsedStep int64          `json:"-"`
+	ConfirmedAt  sql.NullString `json:"confirmed_at"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+func (t *TOTP) IsConfirmed() bool {
+	return t.ConfirmedAt.Valid
+}
+
+type TOTPStore struct {
+	db *sql.DB
+}
+
+// UpsertPending stores a new unconfirmed secret for the user, replacing any
+// previous unconfirmed one. Confirmed secrets are never overwritten and
+// ErrConflict is returned instead.
+func (s *TOTPStore) UpsertPending(ctx context.Context, totp *TOTP) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0
+		WHERE user_totp.confirmed_at IS NULL
+		RETURNING last_used_step, confirmed_at, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		totp.UserID,
+		totp.Secret,
+	).Scan(
+		&totp.LastUsedStep,
+		&totp.ConfirmedAt,
+		&totp.CreatedAt,
+		&totp.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *TOTPStore) GetByUserID(ctx context.Context, userID string) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, last_used_step, confirmed_at, created_at, updated_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	totp := &TOTP{}
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		userID,
+	).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.LastUsedStep,
+		&totp.ConfirmedAt,
+		&totp.CreatedAt,
+		&totp.UpdatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return totp, nil
+}
+
+// UseStep records that the code of the given time step has been accepted. It
+// returns ErrTOTPCodeReused when that step, or a later one, was already used,
+// which makes every code single-use within its validity window.
+func (s *TOTPStore) UseStep(ctx context.Context, userID string, step int64) error {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $1
+		WHERE user_id = $2 AND last_used_step < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTOTPCodeReused
+	}
+
+	return nil
+}
+
+func (s *TOTPStore) Confirm(ctx context.Context, userID string) error {
+	query := `
+		UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1 AND confirmed_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *TOTPStore) Delete(ctx context.Context, userID string) error {
+	query := `
+		DELETE FROM user_totp WHERE user_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID)
+
+	return err
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -23,9 +22,12 @@ type User struct {
 	Email               string         `json:"email"`
 	Password            password       `json:"-"`
 	ProfileURL          sql.NullString `json:"profile_url"`
+	ThumbnailURL        sql.NullString `json:"thumbnail_url"`
+	AvatarKey           sql.NullString `json:"-"`
 	RefreshTokenVersion int            `json:"refresh_token_version"`
 	IsDeleted           bool           `json:"is_deleted"`
 	IsBlocked           bool           `json:"is_blocked"`
+	EmailVerifiedAt     sql.NullString `json:"email_verified_at"`
 	DeletedAt           sql.NullString `json:"deleted_at"`
 	CreatedAt           time.Time      `json:"created_at"`
 	UpdatedAt           time.Time      `json:"updated_at"`
@@ -52,26 +54,58 @@ func (p *password) Compare(text string) error {
 	return bcrypt.CompareHashAndPassword(p.hash, []byte(text))
 }
 
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt.Valid
+}
+
+// HasPassword reports whether the user can sign in with a password.
+// Passwordless accounts only have passkeys.
+func (u *User) HasPassword() bool {
+	return len(u.Password.hash) > 0
+}
+
 type UserStore struct {
 	db *sql.DB
 }
 
+// Create creates the user and records EventUserRegistered along with it.
 func (s *UserStore) Create(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := createUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		return addOutboxEvent(ctx, tx, EventUserRegistered, user.ID, newUserEvent(user))
+	})
+}
+
+// NewUserID returns an ID for a user that has not been created yet. Create
+// keeps a preset ID, which lets ceremonies such as passkey sign-up reference
+// the user before the row exists.
+func NewUserID() (string, error) {
+	return generateId("user")
+}
+
+func createUser(ctx context.Context, q querier, user *User) error {
 	query := `
 		INSERT INTO users (id, first_name, last_name, username, email, password, profile_url)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, refresh_token_version, is_deleted, is_blocked, deleted_at, created_at, updated_at
+		RETURNING id, refresh_token_version, is_deleted, is_blocked, email_verified_at, deleted_at, created_at, updated_at
 	`
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
-	userId, err := generateId("user")
-	if err != nil {
-		return err
+	userId := user.ID
+	if userId == "" {
+		id, err := NewUserID()
+		if err != nil {
+			return err
+		}
+		userId = id
 	}
 
-	err = s.db.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		query,
 		userId,
@@ -86,6 +120,7 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 		&user.RefreshTokenVersion,
 		&user.IsDeleted,
 		&user.IsBlocked,
+		&user.EmailVerifiedAt,
 		&user.DeletedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
@@ -107,7 +142,7 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, first_name, last_name, username, email, password, profile_url, refresh_token_version, is_deleted, is_blocked, deleted_at, created_at, updated_at
+		SELECT id, first_name, last_name, username, email, password, profile_url, avatar_key, refresh_token_version, is_deleted, is_blocked, email_verified_at, deleted_at, created_at, updated_at
 		FROM users
 		WHERE email = $1 AND is_blocked = false
 	`
@@ -128,9 +163,11 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.ProfileURL,
+		&user.AvatarKey,
 		&user.RefreshTokenVersion,
 		&user.IsDeleted,
 		&user.IsBlocked,
+		&user.EmailVerifiedAt,
 		&user.DeletedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
@@ -149,7 +186,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 
 func (s *UserStore) GetByID(ctx context.Context, id string) (*User, error) {
 	query := `
-		SELECT id, first_name, last_name, username, email, password, profile_url, refresh_token_version, is_deleted, is_blocked, deleted_at, created_at, updated_at
+		SELECT id, first_name, last_name, username, email, password, profile_url, avatar_key, refresh_token_version, is_deleted, is_blocked, email_verified_at, deleted_at, created_at, updated_at
 		FROM users
 		WHERE id = $1 AND is_blocked = false
 	`
@@ -170,9 +207,11 @@ func (s *UserStore) GetByID(ctx context.Context, id string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.ProfileURL,
+		&user.AvatarKey,
 		&user.RefreshTokenVersion,
 		&user.IsDeleted,
 		&user.IsBlocked,
+		&user.EmailVerifiedAt,
 		&user.DeletedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
@@ -190,7 +229,6 @@ func (s *UserStore) GetByID(ctx context.Context, id string) (*User, error) {
 }
 
 func (s *UserStore) IncreaseTokenVersion(ctx context.Context, user *User) error {
-	fmt.Println(user)
 	query := `
 		UPDATE users 
 		SET refresh_token_version = refresh_token_version + 1
@@ -220,3 +258,315 @@ func (s *UserStore) IncreaseTokenVersion(ctx context.Context, user *User) error
 
 	return nil
 }
+
+func (s *UserStore) UpdatePassword(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET password = $1
+		WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, user.Password.hash, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// CreateWithWebAuthnCredential creates a passwordless user together with its
+// first passkey, so the account never exists without a way to sign in.
+func (s *UserStore) CreateWithWebAuthnCredential(ctx context.Context, user *User, credential *WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := createUser(ctx, tx, user); err != nil {
+			return err
+		}
+
+		credential.UserID = user.ID
+
+		if err := createWebAuthnCredential(ctx, tx, credential); err != nil {
+			return err
+		}
+
+		return addOutboxEvent(ctx, tx, EventUserRegistered, user.ID, newUserEvent(user))
+	})
+}
+
+// ClaimUnverified records that the user proved access to their mailbox other
+// than through an email verification, e.g. with a magic link. Whoever created
+// an unverified account may not own the mailbox, so the password, passkeys,
+// authenticator app and recovery codes are removed and every session is
+// ended, leaving the account to the owner of the mailbox. It does nothing when
+// the email is already verified.
+func (s *UserStore) ClaimUnverified(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET email_verified_at = NOW(), password = NULL, refresh_token_version = refresh_token_version + 1
+			WHERE id = $1 AND email_verified_at IS NULL
+		`
+
+		res, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		queries := []string{
+			`DELETE FROM webauthn_credentials WHERE user_id = $1`,
+			`DELETE FROM user_totp WHERE user_id = $1`,
+			`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+			`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+			`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		}
+
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Delete marks the account deleted, ends all its sessions and records
+// EventUserDeleted. The row is kept until Purge so the owner can restore it.
+// It returns ErrNotFound when the account is already deleted.
+func (s *UserStore) Delete(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET is_deleted = true, deleted_at = NOW(), refresh_token_version = refresh_token_version + 1
+			WHERE id = $1 AND is_deleted = false
+			RETURNING is_deleted, deleted_at, refresh_token_version
+		`
+
+		err := tx.QueryRowContext(ctx, query, user.ID).Scan(
+			&user.IsDeleted,
+			&user.DeletedAt,
+			&user.RefreshTokenVersion,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		query = `
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE user_id = $1 AND revoked_at IS NULL
+		`
+
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE user_id = $1 AND revoked_at IS NULL
+		`
+
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+
+		return addOutboxEvent(ctx, tx, EventUserDeleted, user.ID, newUserEvent(user))
+	})
+}
+
+// Restore undoes Delete for an account deleted after deletedAfter and
+// records EventUserRestored. It returns ErrNotFound when the account was
+// deleted earlier and is awaiting purge.
+func (s *UserStore) Restore(ctx context.Context, user *User, deletedAfter time.Time) error {
+	query := `
+		UPDATE users
+		SET is_deleted = false, deleted_at = NULL
+		WHERE id = $1 AND is_deleted = true AND deleted_at > $2
+		RETURNING is_deleted, deleted_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, user.ID, deletedAfter).Scan(
+			&user.IsDeleted,
+			&user.DeletedAt,
+		)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return addOutboxEvent(ctx, tx, EventUserRestored, user.ID, newUserEvent(user))
+	})
+}
+
+// Purge permanently removes the accounts deleted before deletedBefore and
+// records EventUserPurged for each, with only the ID and avatar key of the
+// account. Their refresh tokens, sessions and other credentials go with them,
+// the emails recorded in their audit events are scrubbed, while the avatar
+// blobs are left to the handlers of the event. It returns the purged accounts
+// with only their ID and avatar key set.
+func (s *UserStore) Purge(ctx context.Context, deletedBefore time.Time) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	users := []*User{}
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		// The audit events outlive the account, but not as its events: the
+		// deletion sets their user_id to NULL.
+		query := `
+			UPDATE audit_events SET metadata = metadata - 'email'
+			WHERE metadata ? 'email' AND user_id IN (
+				SELECT id FROM users WHERE is_deleted = true AND deleted_at < $1
+			)
+		`
+
+		if _, err := tx.ExecContext(ctx, query, deletedBefore); err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM users
+			WHERE is_deleted = true AND deleted_at < $1
+			RETURNING id, avatar_key
+		`
+
+		rows, err := tx.QueryContext(ctx, query, deletedBefore)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user := &User{}
+			if err := rows.Scan(&user.ID, &user.AvatarKey); err != nil {
+				return err
+			}
+
+			users = append(users, user)
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			event := &UserEvent{UserID: user.ID, AvatarKey: user.AvatarKey.String}
+
+			if err := addOutboxEvent(ctx, tx, EventUserPurged, user.ID, event); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateProfile saves the names and username of the user.
+func (s *UserStore) UpdateProfile(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET first_name = $1, last_name = $2, username = $3
+		WHERE id = $4
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		user.FirstName,
+		user.LastName,
+		user.Username,
+		user.ID,
+	).Scan(
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetAvatar points the user at a new avatar, or at none when key is not
+// valid. It returns the previous key so its blobs can be deleted.
+func (s *UserStore) SetAvatar(ctx context.Context, user *User, key sql.NullString) (sql.NullString, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var previous sql.NullString
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT avatar_key FROM users WHERE id = $1 FOR UPDATE`
+
+		if err := tx.QueryRowContext(ctx, query, user.ID).Scan(&previous); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		query = `
+			UPDATE users SET avatar_key = $2 WHERE id = $1
+			RETURNING updated_at
+		`
+
+		return tx.QueryRowContext(ctx, query, user.ID, key).Scan(&user.UpdatedAt)
+	})
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	user.AvatarKey = key
+
+	return previous, nil
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrLastCredential = errors.New("cannot remove the last passkey of an account without a password")
+
+type WebAuthnCredential struct {
+	ID              string         `json:"id"`
+	UserID          string         `json:"user_id"`
+	Name            string         `json:"name"`
+	CredentialID    []byte         `json:"-"`
+	PublicKey       []byte         `json:"-"`
+	AttestationType string         `json:"attestation_type"`
+	AAGUID          []byte         `json:"-"`
+	SignCount       uint32         `json:"-"`
+	Transports      []string       `json:"transports"`
+	UserPresent     bool           `json:"-"`
+	UserVerified    bool           `json:"-"`
+	BackupEligible  bool           `json:"backup_eligible"`
+	BackupState     bool           `json:"backup_state"`
+	LastUsedAt      sql.NullString `json:"last_used_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+type WebAuthnCredentialStore struct {
+	db *sql.DB
+}
+
+func (s *WebAuthnCredentialStore) Create(ctx context.Context, credential *WebAuthnCredential) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return createWebAuthnCredential(ctx, s.db, credential)
+}
+
+func createWebAuthnCredential(ctx context.Context, q querier, credential *WebAuthnCredential) error {
+	query := `
+		INSERT INTO webauthn_credentials (
+			id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count,
+			transports, user_present, user_verified, backup_eligible, backup_state
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING created_at, updated_at
+	`
+
+	credentialID, err := generateId("passkey")
+	if err != nil {
+		return err
+	}
+
+	err = q.QueryRowContext(
+		ctx,
+		query,
+		credentialID,
+		credential.UserID,
+		credential.Name,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.AAGUID,
+		int64(credential.SignCount),
+		pq.Array(credential.Transports),
+		credential.UserPresent,
+		credential.UserVerified,
+		credential.BackupEligible,
+		credential.BackupState,
+	).Scan(
+		&credential.CreatedAt,
+		&credential.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "webauthn_credentials_credential_id_key"`:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+
+	credential.ID = credentialID
+
+	return nil
+}
+
+func (s *WebAuthnCredentialStore) GetByUserID(ctx context.Context, userID string) ([]*WebAuthnCredential, error) {
+	query := `
+		SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count,
+			transports, user_present, user_verified, backup_eligible, backup_state, last_used_at, created_at, updated_at
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*WebAuthnCredential{}
+	for rows.Next() {
+		credential := &WebAuthnCredential{}
+
+		var signCount int64
+		err := rows.Scan(
+			&credential.ID,
+			&credential.UserID,
+			&credential.Name,
+			&credential.CredentialID,
+			&credential.PublicKey,
+			&credential.AttestationType,
+			&credential.AAGUID,
+			&signCount,
+			pq.Array(&credential.Transports),
+			&credential.UserPresent,
+			&credential.UserVerified,
+			&credential.BackupEligible,
+			&credential.BackupState,
+			&credential.LastUsedAt,
+			&credential.CreatedAt,
+			&credential.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credential.SignCount = uint32(signCount)
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// RecordUse stores the sign count and flags reported by the last assertion.
+// The update only applies while the stored counter is lower, so two
+// concurrent logins with the same counter cannot both succeed.
+func (s *WebAuthnCredentialStore) RecordUse(ctx context.Context, credential *WebAuthnCredential) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET sign_count = $1, user_present = $2, user_verified = $3, backup_state = $4, last_used_at = NOW()
+		WHERE id = $5 AND (sign_count < $1 OR (sign_count = 0 AND $1 = 0))
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		query,
+		int64(credential.SignCount),
+		credential.UserPresent,
+		credential.UserVerified,
+		credential.BackupState,
+		credential.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Delete removes a passkey of the user. With keepOne it returns
+// ErrLastCredential rather than remove the only passkey left. The user row is
+// locked meanwhile, so concurrent deletes cannot both pass the check.
+func (s *WebAuthnCredentialStore) Delete(ctx context.Context, id, userID string, keepOne bool) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`
+
+		var locked int
+		if err := tx.QueryRowContext(ctx, query, userID).Scan(&locked); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		query = `
+			DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2
+		`
+
+		res, err := tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		if !keepOne {
+			return nil
+		}
+
+		query = `SELECT EXISTS (SELECT 1 FROM webauthn_credentials WHERE user_id = $1)`
+
+		var remaining bool
+		if err := tx.QueryRowContext(ctx, query, userID).Scan(&remaining); err != nil {
+			return err
+		}
+
+		if !remaining {
+			return ErrLastCredential
+		}
+
+		return nil
+	})
+}
+
+// WebAuthnSession keeps the server side state of a registration or login
+// ceremony between its begin and finish requests.
+type WebAuthnSession struct {
+	ID        string          `json:"id"`
+	Ceremony  string          `json:"ceremony"`
+	UserID    sql.NullString  `json:"user_id"`
+	Data      json.RawMessage `json:"data"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type WebAuthnSessionStore struct {
+	db *sql.DB
+}
+
+func (s *WebAuthnSessionStore) Create(ctx context.Context, session *WebAuthnSession) error {
+	query := `
+		INSERT INTO webauthn_sessions (id, ceremony, user_id, data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	sessionID, err := generateId("wasess")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		sessionID,
+		session.Ceremony,
+		session.UserID,
+		[]byte(session.Data),
+		session.ExpiresAt,
+	).Scan(
+		&session.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.ID = sessionID
+
+	return nil
+}
+
+// Consume deletes and returns an unexpired session of the given ceremony, so
+// every ceremony can be finished at most once.
+func (s *WebAuthnSessionStore) Consume(ctx context.Context, id, ceremony string) (*WebAuthnSession, error) {
+	query := `
+		DELETE FROM webauthn_sessions
+		WHERE id = $1 AND ceremony = $2 AND expires_at > NOW()
+		RETURNING id, ceremony, user_id, data, expires_at, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	session := &WebAuthnSession{}
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		id,
+		ceremony,
+	).Scan(
+		&session.ID,
+		&session.Ceremony,
+		&session.UserID,
+		&session.Data,
+		&session.ExpiresAt,
+		&session.CreatedAt,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return session, nil
+}
+
+// Prune deletes the sessions of ceremonies that were never finished.
+func (s *WebAuthnSessionStore) Prune(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM webauthn_sessions WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
@@ -0,0 +1,406 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	WebhookDeliveryPending    = "pending"
+	WebhookDeliveryProcessing = "processing"
+	WebhookDeliverySucceeded  = "succeeded"
+	// WebhookDeliveryDead is the dead-letter state of deliveries that ran out
+	// of attempts. They stay there until replayed.
+	WebhookDeliveryDead = "dead"
+)
+
+// WebhookDelivery is an event queued for an endpoint. Payload is the exact
+// body sent on every attempt.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	EndpointID     string          `json:"endpoint_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  sql.NullTime    `json:"next_attempt_at"`
+	LastAttemptAt  sql.NullTime    `json:"last_attempt_at"`
+	LastStatusCode sql.NullInt32   `json:"last_status_code"`
+	LastError      sql.NullString  `json:"last_error"`
+	CompletedAt    sql.NullTime    `json:"completed_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// WebhookAttempt logs a single attempt of a delivery. StatusCode is null
+// when no response was received, Error says why.
+type WebhookAttempt struct {
+	ID           string        `json:"id"`
+	DeliveryID   string        `json:"delivery_id"`
+	StatusCode   sql.NullInt32 `json:"status_code"`
+	Error        string        `json:"error"`
+	ResponseBody string        `json:"response_body"`
+	DurationMS   int           `json:"duration_ms"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+type WebhookDeliveryStore struct {
+	db *sql.DB
+}
+
+// stuckDeliveryTimeout is how long a delivery can stay processing before
+// another worker takes it over, e.g. after a crash. It is well above the
+// timeout of a single attempt.
+const stuckDeliveryTimeout = 5 * time.Minute
+
+const webhookDeliveryColumns = `
+	id, endpoint_id, event_id, event, payload, status, attempts, next_attempt_at, last_attempt_at,
+	last_status_code, last_error, completed_at, created_at, updated_at
+`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }, dest ...any) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	var payload []byte
+	err := row.Scan(append([]any{
+		&delivery.ID,
+		&delivery.EndpointID,
+		&delivery.EventID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CompletedAt,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+
+	return delivery, nil
+}
+
+// Enqueue queues the event for every active endpoint subscribed to it and
+// returns how many deliveries were queued. Queuing the same event ID again
+// does nothing.
+func (s *WebhookDeliveryStore) Enqueue(ctx context.Context, eventID, event string, payload []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	queued := 0
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			SELECT id FROM webhook_endpoints
+			WHERE is_active AND (CARDINALITY(events) = 0 OR $1 = ANY(events))
+		`
+
+		rows, err := tx.QueryContext(ctx, query, event)
+		if err != nil {
+			return err
+		}
+
+		var endpointIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			endpointIDs = append(endpointIDs, id)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event, payload)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (endpoint_id, event_id) DO NOTHING
+		`
+
+		for _, endpointID := range endpointIDs {
+			deliveryID, err := generateId("whdel")
+			if err != nil {
+				return err
+			}
+
+			res, err := tx.ExecContext(ctx, query, deliveryID, endpointID, eventID, event, payload)
+			if err != nil {
+				return err
+			}
+
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			queued += int(n)
+		}
+
+		return nil
+	})
+
+	return queued, err
+}
+
+// Claim marks the delivery that has been due the longest as processing and
+// returns it, so that concurrent workers never send the same delivery.
+// Deliveries of inactive endpoints wait until the endpoint is activated
+// again. It returns ErrNotFound when there is nothing to do.
+func (s *WebhookDeliveryStore) Claim(ctx context.Context) (*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'processing'
+		WHERE id = (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhook_endpoints e ON e.id = d.endpoint_id
+			WHERE e.is_active AND (
+				(d.status = 'pending' AND d.next_attempt_at <= NOW())
+				OR (d.status = 'processing' AND d.updated_at < $1)
+			)
+			ORDER BY d.next_attempt_at
+			LIMIT 1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	delivery, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, query, time.Now().Add(-stuckDeliveryTimeout)))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return delivery, nil
+}
+
+// RecordAttempt logs the attempt and saves the status, attempt count and
+// next attempt of the delivery, which the caller has updated.
+func (s *WebhookDeliveryStore) RecordAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookAttempt) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO webhook_delivery_attempts (id, delivery_id, status_code, error, response_body, duration_ms)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING created_at
+		`
+
+		attemptID, err := generateId("whatt")
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(
+			ctx,
+			query,
+			attemptID,
+			delivery.ID,
+			attempt.StatusCode,
+			attempt.Error,
+			attempt.ResponseBody,
+			attempt.DurationMS,
+		).Scan(&attempt.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		attempt.ID = attemptID
+		attempt.DeliveryID = delivery.ID
+
+		query = `
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, next_attempt_at = $4, last_attempt_at = $5,
+				last_status_code = $6, last_error = NULLIF($7, ''),
+				completed_at = CASE WHEN $2 = 'succeeded' THEN NOW() END
+			WHERE id = $1
+			RETURNING last_attempt_at, last_status_code, last_error, completed_at, updated_at
+		`
+
+		return tx.QueryRowContext(
+			ctx,
+			query,
+			delivery.ID,
+			delivery.Status,
+			delivery.Attempts,
+			delivery.NextAttemptAt,
+			attempt.CreatedAt,
+			attempt.StatusCode,
+			attempt.Error,
+		).Scan(
+			&delivery.LastAttemptAt,
+			&delivery.LastStatusCode,
+			&delivery.LastError,
+			&delivery.CompletedAt,
+			&delivery.UpdatedAt,
+		)
+	})
+}
+
+// ListForEndpoint returns a page of the deliveries of the endpoint, newest
+// first, along with the number of deliveries across all pages. An empty
+// status matches every delivery.
+func (s *WebhookDeliveryStore) ListForEndpoint(ctx context.Context, endpointID, status string, limit, offset int) ([]*WebhookDelivery, int, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `, COUNT(*) OVER()
+		FROM webhook_deliveries
+		WHERE endpoint_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, endpointID, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var total int
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the count.
+	if len(deliveries) == 0 && offset > 0 {
+		query := `SELECT COUNT(*) FROM webhook_deliveries WHERE endpoint_id = $1 AND ($2 = '' OR status = $2)`
+
+		if err := s.db.QueryRowContext(ctx, query, endpointID, status).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return deliveries, total, nil
+}
+
+func (s *WebhookDeliveryStore) GetByID(ctx context.Context, endpointID, id string) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1 AND endpoint_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	delivery, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, query, id, endpointID))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return delivery, nil
+}
+
+// ListAttempts returns the attempts of the delivery, oldest first.
+func (s *WebhookDeliveryStore) ListAttempts(ctx context.Context, deliveryID string) ([]*WebhookAttempt, error) {
+	query := `
+		SELECT id, delivery_id, status_code, error, response_body, duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY created_at, id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*WebhookAttempt{}
+	for rows.Next() {
+		attempt := &WebhookAttempt{}
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.DeliveryID,
+			&attempt.StatusCode,
+			&attempt.Error,
+			&attempt.ResponseBody,
+			&attempt.DurationMS,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// Replay queues a finished delivery again with a fresh set of attempts. It
+// returns ErrConflict when the delivery is still queued.
+func (s *WebhookDeliveryStore) Replay(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), completed_at = NULL
+		WHERE id = $1 AND status IN ('succeeded', 'dead')
+		RETURNING ` + webhookDeliveryColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	replayed, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, query, delivery.ID))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+
+	*delivery = *replayed
+
+	return nil
+}
+
+// Prune deletes the finished deliveries, and their attempts, created before
+// the given time.
+func (s *WebhookDeliveryStore) Prune(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE status IN ('succeeded', 'dead') AND created_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, before)
+
+	return err
+}
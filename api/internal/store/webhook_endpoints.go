@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// WebhookEndpoint receives the events it subscribed to, or every event when
+// Events is empty. Secret holds the encrypted signing secret.
+type WebhookEndpoint struct {
+	ID          string         `json:"id"`
+	URL         string         `json:"url"`
+	Description string         `json:"description"`
+	Secret      []byte         `json:"-"`
+	Events      []string       `json:"events"`
+	IsActive    bool           `json:"is_active"`
+	CreatedBy   sql.NullString `json:"created_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type WebhookEndpointStore struct {
+	db *sql.DB
+}
+
+const webhookEndpointColumns = `id, url, description, secret, events, is_active, created_by, created_at, updated_at`
+
+func scanWebhookEndpoint(row interface{ Scan(...any) error }) (*WebhookEndpoint, error) {
+	endpoint := &WebhookEndpoint{}
+	err := row.Scan(
+		&endpoint.ID,
+		&endpoint.URL,
+		&endpoint.Description,
+		&endpoint.Secret,
+		pq.Array(&endpoint.Events),
+		&endpoint.IsActive,
+		&endpoint.CreatedBy,
+		&endpoint.CreatedAt,
+		&endpoint.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoint, nil
+}
+
+func (s *WebhookEndpointStore) Create(ctx context.Context, endpoint *WebhookEndpoint) error {
+	query := `
+		INSERT INTO webhook_endpoints (id, url, description, secret, events, is_active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	endpointID, err := generateId("whep")
+	if err != nil {
+		return err
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		query,
+		endpointID,
+		endpoint.URL,
+		endpoint.Description,
+		endpoint.Secret,
+		pq.Array(endpoint.Events),
+		endpoint.IsActive,
+		endpoint.CreatedBy,
+	).Scan(
+		&endpoint.CreatedAt,
+		&endpoint.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	endpoint.ID = endpointID
+
+	return nil
+}
+
+// List returns every endpoint, newest first.
+func (s *WebhookEndpointStore) List(ctx context.Context) ([]*WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []*WebhookEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+func (s *WebhookEndpointStore) GetByID(ctx context.Context, id string) (*WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	endpoint, err := scanWebhookEndpoint(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return endpoint, nil
+}
+
+// Update saves the URL, description, events and state of the endpoint. The
+// secret cannot be changed.
+func (s *WebhookEndpointStore) Update(ctx context.Context, endpoint *WebhookEndpoint) error {
+	query := `
+		UPDATE webhook_endpoints
+		SET url = $2, description = $3, events = $4, is_active = $5
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		endpoint.ID,
+		endpoint.URL,
+		endpoint.Description,
+		pq.Array(endpoint.Events),
+		endpoint.IsActive,
+	).Scan(&endpoint.UpdatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the endpoint along with its deliveries.
+func (s *WebhookEndpointStore) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM webhook_endpoints WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package webhook signs and sends webhook requests.
+//
+// Every request carries the event ID, the time it was sent and a signature
+// of both along with the body:
+//
+//	Webhook-Id: evt_...
+//	Webhook-Timestamp: 1700000000
+//	Webhook-Signature: v1=<hex HMAC-SHA256 of "<id>.<timestamp>.<body>">
+//
+// Receivers recompute the signature with the endpoint secret and reject
+// requests whose timestamp is too old, which stops replays. The ID is the
+// same for every attempt and can be used to drop duplicate deliveries.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+
+	signatureVersion = "v1"
+)
+
+// maxResponseBody is how much of the response of a receiver is kept for the
+// delivery logs.
+const maxResponseBody = 1024
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidTimestamp = errors.New("webhook timestamp is missing or outside the tolerance")
+)
+
+// Sign returns the value of the signature header of a request.
+func Sign(secret []byte, id string, timestamp time.Time, body []byte) string {
+	return signatureVersion + "=" + hex.EncodeToString(mac(secret, id, timestamp.Unix(), body))
+}
+
+func mac(secret []byte, id string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(id + "." + strconv.FormatInt(timestamp, 10) + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks the signature of a received webhook and that it was sent
+// within tolerance of now. The signature header may list several space
+// separated signatures, any of which may match.
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	if sent := time.Unix(timestamp, 0); sent.Before(now.Add(-tolerance)) || sent.After(now.Add(tolerance)) {
+		return ErrInvalidTimestamp
+	}
+
+	expected := mac(secret, header.Get(HeaderID), timestamp, body)
+
+	for _, signature := range strings.Fields(header.Get(HeaderSignature)) {
+		version, digest, ok := strings.Cut(signature, "=")
+		if !ok || version != signatureVersion {
+			continue
+		}
+
+		decoded, err := hex.DecodeString(digest)
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// Request is a single delivery attempt of an event to an endpoint.
+type Request struct {
+	URL    string
+	Secret []byte
+	// ID identifies the event and stays the same across attempts.
+	ID   string
+	Body []byte
+}
+
+// Response is what the receiver answered. Body is cut to the first
+// kilobyte.
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// OK reports whether the receiver accepted the event.
+func (r *Response) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+type Client struct {
+	http      *http.Client
+	userAgent string
+}
+
+// NewClient returns a client giving up on receivers after timeout. Redirects
+// are not followed, as the signature is only meant for the registered URL.
+func NewClient(timeout time.Duration, userAgent string) *Client {
+	return &Client{
+		http: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		userAgent: userAgent,
+	}
+}
+
+// Send posts the event. An error is only returned when no response was
+// received; any status, including errors, comes back as a Response.
+func (c *Client) Send(ctx context.Context, req Request) (*Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	httpReq.Header.Set(HeaderID, req.ID)
+	httpReq.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	httpReq.Header.Set(HeaderSignature, Sign(req.Secret, req.ID, now, req.Body))
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	// Drain a little more so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Body:       strings.ToValidUTF8(string(body), ""),
+	}, nil
+}
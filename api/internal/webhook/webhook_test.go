@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	testSecret = []byte("whsec_test")
+	testBody   = []byte(`{"event":"user.registered"}`)
+)
+
+func signedHeader(secret []byte, id string, timestamp time.Time, body []byte) http.Header {
+	header := http.Header{}
+	header.Set(HeaderID, id)
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	header.Set(HeaderSignature, Sign(secret, id, timestamp, body))
+	return header
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tolerance := 5 * time.Minute
+
+	tests := []struct {
+		name   string
+		header func() http.Header
+		body   []byte
+		want   error
+	}{
+		{
+			name:   "valid",
+			header: func() http.Header { return signedHeader(testSecret, "evt_1", now, testBody) },
+			body:   testBody,
+		},
+		{
+			name:   "at the edge of the tolerance",
+			header: func() http.Header { return signedHeader(testSecret, "evt_1", now.Add(-tolerance), testBody) },
+			body:   testBody,
+		},
+		{
+			name: "one of several signatures",
+			header: func() http.Header {
+				header := signedHeader(testSecret, "evt_1", now, testBody)
+				header.Set(HeaderSignature, "v0=abc v1=zz "+Sign([]byte("old secret"), "evt_1", now, testBody)+" "+header.Get(HeaderSignature))
+				return header
+			},
+			body: testBody,
+		},
+		{
+			name: "too old",
+			header: func() http.Header {
+				return signedHeader(testSecret, "evt_1", now.Add(-tolerance-time.Second), testBody)
+			},
+			body: testBody,
+			want: ErrInvalidTimestamp,
+		},
+		{
+			name:   "in the future",
+			header: func() http.Header { return signedHeader(testSecret, "evt_1", now.Add(tolerance+time.Second), testBody) },
+			body:   testBody,
+			want:   ErrInvalidTimestamp,
+		},
+		{
+			name: "missing timestamp",
+			header: func() http.Header {
+				header := signedHeader(testSecret, "evt_1", now, testBody)
+				header.Del(HeaderTimestamp)
+				return header
+			},
+			body: testBody,
+			want: ErrInvalidTimestamp,
+		},
+		{
+			name: "timestamp changed",
+			header: func() http.Header {
+				header := signedHeader(testSecret, "evt_1", now, testBody)
+				header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()+1, 10))
+				return header
+			},
+			body: testBody,
+			want: ErrInvalidSignature,
+		},
+		{
+			name: "ID changed",
+			header: func() http.Header {
+				header := signedHeader(testSecret, "evt_1", now, testBody)
+				header.Set(HeaderID, "evt_2")
+				return header
+			},
+			body: testBody,
+			want: ErrInvalidSignature,
+		},
+		{
+			name:   "body changed",
+			header: func() http.Header { return signedHeader(testSecret, "evt_1", now, testBody) },
+			body:   []byte(`{"event":"user.deleted"}`),
+			want:   ErrInvalidSignature,
+		},
+		{
+			name:   "other secret",
+			header: func() http.Header { return signedHeader([]byte("other"), "evt_1", now, testBody) },
+			body:   testBody,
+			want:   ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(testSecret, tt.header(), tt.body, tolerance, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	var received atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("User-Agent") != "Trigon-Webhooks/test" {
+			t.Errorf("got %s with headers %v", r.Method, r.Header)
+		}
+
+		if r.Header.Get(HeaderID) != "evt_1" {
+			t.Errorf("got ID %q", r.Header.Get(HeaderID))
+		}
+
+		if err := Verify(testSecret, r.Header, body, time.Minute, time.Now()); err != nil {
+			t.Errorf("the receiver could not verify the request: %v", err)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	client := NewClient(time.Second, "Trigon-Webhooks/test")
+
+	resp, err := client.Send(context.Background(), Request{URL: srv.URL, Secret: testSecret, ID: "evt_1", Body: testBody})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resp.OK() || resp.StatusCode != http.StatusAccepted || resp.Body != "ok" {
+		t.Errorf("got %+v", resp)
+	}
+
+	if received.Load() != 1 {
+		t.Errorf("received %d requests", received.Load())
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, strings.Repeat("x", 4*maxResponseBody))
+	}))
+	defer srv.Close()
+
+	resp, err := NewClient(time.Second, "test").Send(context.Background(), Request{URL: srv.URL, Secret: testSecret, ID: "evt_1", Body: testBody})
+	if err != nil {
+		t.Fatalf("an error status is not an error: %v", err)
+	}
+
+	if resp.OK() || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %+v", resp)
+	}
+
+	if len(resp.Body) != maxResponseBody {
+		t.Errorf("kept %d bytes of the body, want %d", len(resp.Body), maxResponseBody)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	resp, err := NewClient(50*time.Millisecond, "test").Send(context.Background(), Request{URL: srv.URL, Secret: testSecret, ID: "evt_1", Body: testBody})
+	if err == nil {
+		t.Fatalf("got %+v, want a timeout", resp)
+	}
+
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	var followed atomic.Int32
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed.Add(1)
+	}))
+	defer target.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+
+	resp, err := NewClient(time.Second, "test").Send(context.Background(), Request{URL: srv.URL, Secret: testSecret, ID: "evt_1", Body: testBody})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.OK() || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("got %+v, want the redirect itself", resp)
+	}
+
+	if followed.Load() != 0 {
+		t.Error("the redirect was followed")
+	}
+}